@@ -17,7 +17,8 @@ import (
 )
 
 var (
-	g_IdSeed int64
+	g_IdSeed  int64
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 const (
@@ -54,6 +55,7 @@ type (
 		Stop()
 		SendMsg(head rpc.RpcHead, funcName string, params ...interface{})
 		Send(head rpc.RpcHead, packet rpc.Packet)
+		Post(fun func())
 		RegisterTimer(duration time.Duration, fun func(), opts ...timer.OpOption)
 		GetId() int64
 		GetState() int32
@@ -61,6 +63,7 @@ type (
 		GetName() string
 		GetActorType() ACTOR_TYPE
 		HasRpc(string) bool
+		Stats() ActorStats
 		getActor() *Actor
 		register(IActor, Op)
 		setState(state int32)
@@ -88,12 +91,14 @@ type (
 		timerId   *int64
 		pool      IActorPool
 		timerMap  map[uintptr]func()
+		stat      actorStat
 	}
 
 	CallIO struct {
 		rpc.RpcHead
 		*rpc.Packet
 		Buff []byte
+		fun  func()
 	}
 
 	TraceInfo struct {
@@ -153,9 +158,26 @@ func (a *Actor) Send(head rpc.RpcHead, packet rpc.Packet) {
 
 	var io CallIO
 	io.RpcHead = head
-	io.RpcPacket = packet.RpcPacket
+	//CallIO内嵌*rpc.Packet, 直接写RpcPacket会对nil解引用
+	io.Packet = &packet
 	io.Buff = packet.Buff
-	a.mailBox.Push(&io)
+	a.push(&io)
+}
+
+// 投递一个函数到actor协程执行
+func (a *Actor) Post(fun func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			base.TraceCode(err)
+		}
+	}()
+
+	a.push(&CallIO{fun: fun})
+}
+
+func (a *Actor) push(io *CallIO) {
+	a.mailBox.Push(io)
+	atomic.AddInt64(&a.stat.msgIn, 1)
 	if atomic.LoadInt64(&a.mailIn[0]) == 0 && atomic.CompareAndSwapInt64(&a.mailIn[0], 0, 1) {
 		a.mailChan <- true
 	}
@@ -184,8 +206,11 @@ func (a *Actor) GetState() int32 {
 }
 
 func (a *Actor) GetRpcHead(ctx context.Context) rpc.RpcHead {
-	rpcHead := ctx.Value("rpcHead").(rpc.RpcHead)
-	return rpcHead
+	rpcHead, bOk := rpc.FromContext(ctx)
+	if !bOk {
+		return rpc.RpcHead{}
+	}
+	return *rpcHead
 }
 
 func (a *Actor) GetName() string {
@@ -271,10 +296,22 @@ func (a *Actor) loop() bool {
 func (a *Actor) consume() {
 	atomic.StoreInt64(&a.mailIn[0], 0)
 	for data := a.mailBox.Pop(); data != nil; data = a.mailBox.Pop() {
-		a.call(data)
+		a.handle(data)
 	}
 }
 
+func (a *Actor) handle(io *CallIO) {
+	start := time.Now()
+	defer a.stat.done(start)
+	if io.fun != nil {
+		a.Trace("post")
+		io.fun()
+		a.Trace("")
+		return
+	}
+	a.call(io)
+}
+
 func (a *Actor) call(io *CallIO) {
 	rpcPakcet := io.RpcPacket
 	rpcHead := io.RpcHead
@@ -286,6 +323,18 @@ func (a *Actor) call(io *CallIO) {
 		return
 	}
 
+	method := rpc.GetSchema(a.actorName, funcName)
+	if method == nil {
+		method = rpc.NewMethodSchema(m)
+	}
+	if err := rpc.CheckFingerprint(rpcPakcet, method); err != nil {
+		log.Printf("actor %s %v", a.actorName, err)
+		if rpcHead.Reply != "" {
+			rpc.MGR.Call(&rpcHead, err)
+		}
+		return
+	}
+
 	rpcPakcet.RpcHead.SocketId = io.SocketId
 	params := rpc.UnmarshalBody(rpcPakcet, m.Type)
 	if len(params) >= 1 {
@@ -301,8 +350,17 @@ func (a *Actor) call(io *CallIO) {
 		ret := m.Func.Call(in)
 		a.Trace("")
 		if ret != nil && rpcHead.Reply != "" {
-			ret = append([]reflect.Value{reflect.ValueOf(&rpcHead)}, ret...)
-			rpc.MGR.Call(ret)
+			//回包格式: head, error, 其余返回值, 与Cluster.Call解包顺序一致
+			//error单独取出, 不论handler把它放在返回值的哪一位
+			reply := []interface{}{&rpcHead, nil}
+			for _, v := range ret {
+				if v.Type() == errorType {
+					reply[1] = v.Interface()
+					continue
+				}
+				reply = append(reply, v.Interface())
+			}
+			rpc.MGR.Call(reply...)
 		}
 	} else {
 		log.Printf("func %s params too short", funcName)
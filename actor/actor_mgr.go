@@ -70,6 +70,7 @@ func (a *ActorMgr) RegisterActor(ac IActor, params ...OpOption) {
 	}
 	op.name = name
 	ac.register(ac, op)
+	rpc.RegisterSchema(name, rType)
 	a.actorTypeMap[rType] = ac
 	a.actorMap[name] = ac
 	if op.pool != nil {
@@ -101,7 +102,17 @@ func (a *ActorMgr) SendActor(funcName string, head rpc.RpcHead, packet rpc.Packe
 }
 
 func (a *ActorMgr) PacketFunc(packet rpc.Packet) bool {
-	rpcPacket, head := rpc.Unmarshal(packet.Buff)
+	rpcPacket, head := packet.RpcPacket, rpc.RpcHead{}
+	if rpcPacket == nil || rpcPacket.RpcHead == nil {
+		var err error
+		rpcPacket, head, err = rpc.UnmarshalPacket(packet.Buff)
+		if err != nil {
+			log.Printf("rpc [%s] decompress error %v, dropped", rpcPacket.FuncName, err)
+			return false
+		}
+	} else {
+		head = *rpcPacket.RpcHead
+	}
 	packet.RpcPacket = rpcPacket
 	head.SocketId = packet.Id
 	head.Reply = packet.Reply
@@ -44,7 +44,7 @@ func (a *ActorPool) GetPoolSize() int32 {
 func (a *ActorPool) SendActor(head rpc.RpcHead, packet rpc.Packet) bool {
 	if a.MGR.HasRpc(packet.RpcPacket.FuncName) {
 		switch head.SendType {
-		case rpc.SEND_POINT:
+		case rpc.SEND_POINT, rpc.SEND_BALANCE:
 			index := head.Id % int64(a.actorSize)
 			a.actorList[index].getActor().Send(head, packet)
 		default:
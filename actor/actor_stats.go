@@ -0,0 +1,109 @@
+package actor
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// actor运行统计, 池类型为所有actor的合计
+	ActorStats struct {
+		Name    string        `json:"name"`
+		Type    ACTOR_TYPE    `json:"type"`
+		Num     int           `json:"num"`     //actor数量
+		MsgNum  int64         `json:"msgnum"`  //已处理消息数
+		Pending int64         `json:"pending"` //待处理消息数
+		Cost    time.Duration `json:"cost"`    //累计处理耗时
+		MaxCost time.Duration `json:"maxcost"` //单条消息最大耗时
+	}
+
+	actorStat struct {
+		msgIn   int64
+		msgNum  int64
+		cost    int64
+		maxCost int64
+	}
+
+	// 池类型actor提供合计统计
+	IActorPoolStats interface {
+		PoolStats() ActorStats
+	}
+)
+
+func (a *actorStat) done(start time.Time) {
+	cost := int64(time.Since(start))
+	atomic.AddInt64(&a.msgNum, 1)
+	atomic.AddInt64(&a.cost, cost)
+	for {
+		maxCost := atomic.LoadInt64(&a.maxCost)
+		if cost <= maxCost || atomic.CompareAndSwapInt64(&a.maxCost, maxCost, cost) {
+			break
+		}
+	}
+}
+
+func (a *Actor) Stats() ActorStats {
+	msgNum := atomic.LoadInt64(&a.stat.msgNum)
+	return ActorStats{
+		Name:    a.actorName,
+		Type:    a.actorType,
+		Num:     1,
+		MsgNum:  msgNum,
+		Pending: atomic.LoadInt64(&a.stat.msgIn) - msgNum,
+		Cost:    time.Duration(atomic.LoadInt64(&a.stat.cost)),
+		MaxCost: time.Duration(atomic.LoadInt64(&a.stat.maxCost)),
+	}
+}
+
+func (s *ActorStats) add(other ActorStats) {
+	s.Num += other.Num
+	s.MsgNum += other.MsgNum
+	s.Pending += other.Pending
+	s.Cost += other.Cost
+	if other.MaxCost > s.MaxCost {
+		s.MaxCost = other.MaxCost
+	}
+}
+
+func (a *ActorPool) PoolStats() ActorStats {
+	stats := ActorStats{Name: a.MGR.GetName(), Type: ACTOR_TYPE_POOL}
+	for _, ac := range a.actorList {
+		stats.add(ac.Stats())
+	}
+	return stats
+}
+
+func (a *ActorPoolDynamic) PoolStats() ActorStats {
+	stats := ActorStats{Name: a.MGR.GetName(), Type: ACTOR_TYPE_VIRTUAL}
+	a.actorLock.RLock()
+	for _, ac := range a.actorMap {
+		stats.add(ac.Stats())
+	}
+	a.actorLock.RUnlock()
+	return stats
+}
+
+// 所有注册actor的统计, 按名字排序
+func (a *ActorMgr) Stats() []ActorStats {
+	statsList := make([]ActorStats, 0, len(a.actorMap))
+	for _, ac := range a.actorMap {
+		if pool, bOk := ac.getPool().(IActorPoolStats); bOk {
+			statsList = append(statsList, pool.PoolStats())
+			continue
+		}
+		statsList = append(statsList, ac.Stats())
+	}
+	sort.Slice(statsList, func(i, j int) bool {
+		return statsList[i].Name < statsList[j].Name
+	})
+	return statsList
+}
+
+func (a *ActorMgr) GetActor(name string) IActor {
+	ac, bEx := a.actorMap[name]
+	if bEx {
+		return ac
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package base
 import (
 	"errors"
 	"hash/crc32"
+	"sort"
 	"strconv"
 	"sync"
 
@@ -10,7 +11,8 @@ import (
 )
 
 const (
-	REPLICASNUM = 5
+	REPLICASNUM      = 5   //StubHashRing和HashRing默认每单位权重的虚拟节点数
+	BALANCE_REPLICAS = 160 //SetBalance后HashRing每单位权重的虚拟节点数
 )
 
 // ErrEmptyRing is the error returned when trying to get an element when nothing has been added to hash.
@@ -19,14 +21,17 @@ var ErrEmptyRing = errors.New("empty ring")
 type (
 	// HashRing holds the information about the members of the consistent hash ring.
 	HashRing struct {
-		ringMap    map[uint32]string
-		memberMap  map[string]bool
+		ringMap    map[uint32][]string //虚拟节点 -> member, hash冲突时多个member共用, 第一个生效
+		memberMap  map[string]int      //member -> weight
 		sortedKeys *maps.Map[uint32, uint32]
+		replicas   int
+		balance    bool
 		sync.RWMutex
 	}
 
 	IHashRing interface {
 		Add(elt string)
+		AddWeight(elt string, weight int)
 		Remove(elt string)
 		HasMember(elt string) bool
 		Members() []string
@@ -35,16 +40,36 @@ type (
 	}
 )
 
-// New creates a new HashRing( object with a default setting of 20 replicas for each entry.
-// To change the number of replicas, set NumberOfReplicas before adding entries.
+// New creates a new HashRing( object with a default setting of REPLICASNUM replicas for each unit of weight.
+// 默认与旧版本的分布一致, 权重为1时key的归属不变
 func NewHashRing() *HashRing {
 	ring := new(HashRing)
-	ring.ringMap = make(map[uint32]string)
-	ring.memberMap = make(map[string]bool)
+	ring.ringMap = make(map[uint32][]string)
+	ring.memberMap = make(map[string]int)
 	ring.sortedKeys = &maps.Map[uint32, uint32]{}
+	ring.replicas = REPLICASNUM
 	return ring
 }
 
+// SetBalance switches to BALANCE_REPLICAS replicas and mixed ring positions, call it before adding entries.
+// crc32对相近的key是线性的, 虚拟节点少时分布不均, 开启后分布均匀
+// 开启后所有key重新分配, 与旧版本不兼容, 需全集群同时开启
+func (h *HashRing) SetBalance() {
+	h.Lock()
+	defer h.Unlock()
+	h.balance = true
+	h.replicas = BALANCE_REPLICAS
+}
+
+// SetReplicas changes the number of replicas per unit of weight, call it before adding entries.
+func (h *HashRing) SetReplicas(replicas int) {
+	h.Lock()
+	defer h.Unlock()
+	if replicas > 0 {
+		h.replicas = replicas
+	}
+}
+
 func hash(key string) uint32 {
 	if len(key) < 64 {
 		var scratch [64]byte
@@ -54,6 +79,26 @@ func hash(key string) uint32 {
 	return crc32.ChecksumIEEE([]byte(key))
 }
 
+// 环上位置, 开启balance时再做一次混淆打散
+// member id仍用hash(elt), 与集群id一致
+func (h *HashRing) ringHash(key string) uint32 {
+	if !h.balance {
+		return hash(key)
+	}
+	return mixHash(key)
+}
+
+// crc32是线性的, 相近的key会聚在一起, 用murmur3的finalizer打散
+func mixHash(key string) uint32 {
+	x := hash(key)
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
 // eltKey generates a string key for an element with an index.
 func (h *HashRing) eltKey(elt string, idx int) string {
 	// return elt + "|" + strconv.Itoa(idx)
@@ -61,30 +106,72 @@ func (h *HashRing) eltKey(elt string, idx int) string {
 }
 
 // need c.Lock() before calling
-func (h *HashRing) add(elt string) {
-	for i := 0; i < REPLICASNUM; i++ {
-		Id := hash(h.eltKey(elt, i))
-		h.ringMap[Id] = elt
-		h.sortedKeys.Put(Id, hash(elt))
+func (h *HashRing) add(elt string, weight int) {
+	for i := 0; i < h.replicas*weight; i++ {
+		Id := h.ringHash(h.eltKey(elt, i))
+		h.putPoint(Id, append(h.ringMap[Id], elt))
 	}
-	h.memberMap[elt] = true
+	h.memberMap[elt] = weight
 }
 
 // need c.Lock() before calling
 func (h *HashRing) remove(elt string) {
-	for i := 0; i < REPLICASNUM; i++ {
-		Id := hash(h.eltKey(elt, i))
+	weight, bEx := h.memberMap[elt]
+	if !bEx {
+		return
+	}
+	for i := 0; i < h.replicas*weight; i++ {
+		Id := h.ringHash(h.eltKey(elt, i))
+		//虚拟节点hash冲突时只移除自己, 其他member仍占用该位置
+		owners := []string{}
+		for _, v := range h.ringMap[Id] {
+			if v != elt {
+				owners = append(owners, v)
+			}
+		}
+		h.putPoint(Id, owners)
+	}
+	delete(h.memberMap, elt)
+}
+
+// 冲突的member按名字排序, 归属与加入顺序无关
+// need c.Lock() before calling
+func (h *HashRing) putPoint(Id uint32, owners []string) {
+	if len(owners) == 0 {
 		delete(h.ringMap, Id)
 		h.sortedKeys.Remove(Id)
+		return
 	}
-	delete(h.memberMap, elt)
+	sort.Strings(owners)
+	h.ringMap[Id] = owners
+	h.sortedKeys.Put(Id, hash(owners[0]))
 }
 
 // Add inserts a string element in the consistent hash.
 func (h *HashRing) Add(elt string) {
+	h.AddWeight(elt, 1)
+}
+
+// AddWeight inserts an element with weight*replicas virtual nodes, re-adding updates the weight.
+func (h *HashRing) AddWeight(elt string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
 	h.Lock()
 	defer h.Unlock()
-	h.add(elt)
+	if w, bEx := h.memberMap[elt]; bEx {
+		if w == weight {
+			return
+		}
+		h.remove(elt)
+	}
+	h.add(elt, weight)
+}
+
+func (h *HashRing) Weight(elt string) int {
+	h.RLock()
+	defer h.RUnlock()
+	return h.memberMap[elt]
 }
 
 // Remove removes an element from the hash.
@@ -118,16 +205,16 @@ func (h *HashRing) Get(name string) (error, string) {
 	if len(h.ringMap) == 0 {
 		return ErrEmptyRing, ""
 	}
-	key := hash(name)
+	key := h.ringHash(name)
 	node, bOk := h.sortedKeys.Ceiling(key)
 	if !bOk {
 		itr := h.sortedKeys.Iterator()
 		if itr.First() {
-			return nil, h.ringMap[itr.Key()]
+			return nil, h.ringMap[itr.Key()][0]
 		}
 		return ErrEmptyRing, ""
 	}
-	return nil, h.ringMap[node.Key]
+	return nil, h.ringMap[node.Key][0]
 }
 
 func (h *HashRing) Get64(val int64) (error, uint32) {
@@ -136,7 +223,7 @@ func (h *HashRing) Get64(val int64) (error, uint32) {
 	if len(h.ringMap) == 0 {
 		return ErrEmptyRing, 0
 	}
-	key := hash(strconv.FormatInt(val, 10))
+	key := h.ringHash(strconv.FormatInt(val, 10))
 	node, bOk := h.sortedKeys.Ceiling(key)
 	if !bOk {
 		itr := h.sortedKeys.Iterator()
@@ -194,3 +281,14 @@ func (h *StubHashRing) Get(val int64) (error, uint32) {
 	}
 	return nil, node.Value
 }
+
+// 跳跃一致性hash, key映射到[0, buckets), buckets变化时只有约1/buckets的key迁移
+func JumpHash(key uint64, buckets int64) int64 {
+	var b, j int64 = -1, 0
+	for j < buckets {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return b
+}
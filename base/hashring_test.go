@@ -0,0 +1,183 @@
+package base
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	HASH_TEST_KEYS = 100000
+)
+
+// 按Get64统计各member分到的key比例
+func ringShare(h *HashRing, members []string) map[string]float64 {
+	idMap := make(map[uint32]string)
+	for _, elt := range members {
+		idMap[hash(elt)] = elt
+	}
+	share := make(map[string]float64)
+	for i := int64(0); i < HASH_TEST_KEYS; i++ {
+		_, id := h.Get64(i)
+		share[idMap[id]] += 1.0 / HASH_TEST_KEYS
+	}
+	return share
+}
+
+func TestHashRingWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight map[string]int
+	}{
+		{"equal", map[string]int{"192.168.1.10:31001": 1, "192.168.1.11:31001": 1, "192.168.1.12:31001": 1, "192.168.1.13:31001": 1}},
+		{"double", map[string]int{"192.168.1.10:31001": 1, "192.168.1.11:31001": 2}},
+		{"mixed", map[string]int{"192.168.1.10:31001": 1, "192.168.1.11:31001": 3, "192.168.1.12:31001": 4}},
+		{"adjacent names", map[string]int{"10.0.0.1:1": 1, "10.0.0.2:1": 1, "10.0.0.3:1": 1}},
+		{"zero as one", map[string]int{"192.168.1.10:31001": 0, "192.168.1.11:31001": 1}},
+	}
+	for _, test := range tests {
+		h := NewHashRing()
+		h.SetBalance()
+		total, members := 0, []string{}
+		for elt, weight := range test.weight {
+			h.AddWeight(elt, weight)
+			total += weight
+			if weight <= 0 {
+				total++
+			}
+			members = append(members, elt)
+		}
+		share := ringShare(h, members)
+		for elt := range test.weight {
+			want := float64(h.Weight(elt)) / float64(total)
+			if math.Abs(share[elt]-want) > 0.05 {
+				t.Errorf("%s: member %s share %.3f, want %.3f", test.name, elt, share[elt], want)
+			}
+		}
+	}
+}
+
+// 修改权重或增删member时, 只有相关member的key迁移
+func TestHashRingRebalance(t *testing.T) {
+	h := NewHashRing()
+	h.AddWeight("a", 1)
+	h.AddWeight("b", 1)
+	before := make([]uint32, HASH_TEST_KEYS)
+	for i := range before {
+		_, before[i] = h.Get64(int64(i))
+	}
+
+	h.AddWeight("b", 3)
+	if h.Weight("b") != 3 {
+		t.Fatalf("weight %d", h.Weight("b"))
+	}
+	for i := range before {
+		if _, id := h.Get64(int64(i)); before[i] == hash("b") && id != hash("b") {
+			t.Fatalf("key %d moved away from the member gaining weight", i)
+		}
+	}
+
+	h.Add("c")
+	h.Remove("c")
+	h.AddWeight("b", 1)
+	for i := range before {
+		if _, id := h.Get64(int64(i)); id != before[i] {
+			t.Fatalf("key %d not restored", i)
+		}
+	}
+	if len(h.ringMap) != h.sortedKeys.Size() {
+		t.Fatalf("ring %d sorted %d", len(h.ringMap), h.sortedKeys.Size())
+	}
+
+	h.Remove("a")
+	h.Remove("b")
+	if err, _ := h.Get64(1); err != ErrEmptyRing {
+		t.Fatalf("empty ring %v", err)
+	}
+}
+
+// 默认与旧版本的环一致: 每个member REPLICASNUM个crc32虚拟节点
+func TestHashRingLegacy(t *testing.T) {
+	members := []string{"192.168.1.10:31001", "192.168.1.11:31001", "192.168.1.12:31001"}
+	h := NewHashRing()
+	legacy := &StubHashRing{}
+	legacy.Init(members)
+	for _, elt := range members {
+		h.Add(elt)
+	}
+	for i := int64(0); i < HASH_TEST_KEYS; i++ {
+		_, id := h.Get64(i)
+		if _, want := legacy.Get(i); id != want {
+			t.Fatalf("key %d on %d, want %d", i, id, want)
+		}
+	}
+}
+
+// 虚拟节点hash冲突时, 移除一个member不影响另一个, 归属与加入顺序无关
+func TestHashRingCollision(t *testing.T) {
+	//两个第0个虚拟节点位置相同的member
+	a, b := "10.25.210.196:31001", "10.31.144.0:31001"
+	if hash("0"+a) != hash("0"+b) {
+		t.Fatal("members not collide")
+	}
+	owner := a
+	if b < a {
+		owner = b
+	}
+	for _, order := range [][]string{{a, b}, {b, a}} {
+		h := NewHashRing()
+		h.SetReplicas(1)
+		for _, elt := range order {
+			h.Add(elt)
+		}
+		if _, got := h.Get(""); len(h.ringMap) != 1 || got != owner {
+			t.Fatalf("order %v owner %s, want %s", order, got, owner)
+		}
+		h.Remove(order[1])
+		if _, got := h.Get(""); got != order[0] || h.sortedKeys.Size() != 1 {
+			t.Fatalf("order %v removed %s, owner %s", order, order[1], got)
+		}
+		h.Remove(order[0])
+		if err, _ := h.Get(""); err != ErrEmptyRing || h.sortedKeys.Size() != 0 {
+			t.Fatalf("order %v not empty %v", order, err)
+		}
+	}
+}
+
+// 桶数增加时key只会迁移到新桶, 迁移比例约为1/buckets
+func TestJumpHash(t *testing.T) {
+	tests := []struct {
+		from int64
+		to   int64
+	}{
+		{1, 2},
+		{3, 4},
+		{10, 11},
+		{10, 20},
+		{64, 65},
+	}
+	for _, test := range tests {
+		moved := 0
+		for key := uint64(0); key < HASH_TEST_KEYS; key++ {
+			b1, b2 := JumpHash(key, test.from), JumpHash(key, test.to)
+			if b1 < 0 || b1 >= test.from || b2 < 0 || b2 >= test.to {
+				t.Fatalf("key %d bucket %d/%d out of range", key, b1, b2)
+			}
+			if b1 != b2 {
+				if b2 < test.from {
+					t.Fatalf("%d->%d: key %d moved between old buckets %d->%d", test.from, test.to, key, b1, b2)
+				}
+				moved++
+			}
+			if JumpHash(key, test.from) != b1 {
+				t.Fatalf("key %d not stable", key)
+			}
+		}
+		want := float64(test.to-test.from) / float64(test.to)
+		if share := float64(moved) / HASH_TEST_KEYS; math.Abs(share-want) > 0.02 {
+			t.Errorf("%d->%d: moved %.3f, want %.3f", test.from, test.to, share, want)
+		}
+	}
+	if b := JumpHash(1, 0); b != -1 {
+		t.Fatalf("no bucket %d", b)
+	}
+}
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -21,6 +22,8 @@ import (
 * 1. 41位时间截(毫秒级)，注意这是时间截的差值（当前时间截 - 开始时间截)。可以使用约69年: (1L << 41) / (1000L * 60 * 60 * 24 * 365) = 69
 * 2. 12位数据机器位，可以部署在4096个节点
 * 3. 10位序列，毫秒内的计数，同一机器，同一时间截并发1024个序号
+*
+* 可通过SetLayout在机器位前加入数据中心位, 并调整机器位和序列位, 总位数不超过24
  */
 
 const (
@@ -32,19 +35,52 @@ const (
 	workeridShift  = sequenceBits                     //机器id左移位数
 	timestampShift = sequenceBits + workeridBits      //时间戳左移位数
 	WorkeridMax    = workeridMax                      //集群自增量
+	layoutMaxBits  = uint(24)                         //数据中心+机器+序列的最大位数, 时间戳至少39位
+	MAX_ROLLBACK   = 5 * time.Millisecond             //默认可等待的时钟回拨
+)
+
+var (
+	ErrClockRollback   = errors.New("snowflake clock moved backwards")
+	ErrLayout          = errors.New("snowflake layout invalid")
+	ErrWorkeridRevoked = errors.New("snowflake workerid revoked")
+	ErrNotReserved     = errors.New("snowflake timestamp not reserved")
 )
 
 type (
+	// id位布局, 零值为默认布局
+	SnowflakeLayout struct {
+		DatacenterBits uint          `yaml:"datacenter_bits"` //数据中心所占的位数
+		WorkeridBits   uint          `yaml:"workerid_bits"`   //机器id所占的位数
+		SequenceBits   uint          `yaml:"sequence_bits"`   //序列所占的位数
+		Datacenter     int64         `yaml:"datacenter"`      //本节点数据中心
+		MaxRollback    time.Duration `yaml:"max_rollback"`    //时钟回拨不超过该值时等待追上, 超过时NextId返回错误
+	}
+
 	Snowflake struct {
-		sequence  int64
-		workerid  int64
-		timestamp int64
+		sequence    int64
+		workerid    int64
+		timestamp   int64
+		layout      SnowflakeLayout
+		workeridMax int64
+		sequenceMax int64
+		restored    int64 //恢复的时间戳, 时钟未追上前沿用不视为回拨
+		reserved    int64 //已持久化的时间戳上限, 0不检查
+		revoked     bool  //机器id已失效, 重新Init前不分配
 		sync.Mutex
 	}
 
 	ISnowflake interface {
 		Init(workerid int64)
 		UUID() int64
+		NextId() (int64, error)
+		SetLayout(layout SnowflakeLayout) error
+		Layout() SnowflakeLayout
+		WorkeridMax() int64
+		Restore(timestamp int64)
+		Reserve(timestamp int64)
+		Revoke()
+		Timestamp() int64
+		Parse(id int64) (ts int64, datacenter int64, workerId int64, seq int64)
 	}
 
 	WorkIdQue struct { //workid que
@@ -60,36 +96,208 @@ type (
 	}
 )
 
+// 设置位布局, 需在Init前调用
+func (s *Snowflake) SetLayout(layout SnowflakeLayout) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.setLayout(layout)
+}
+
+// need s.Lock before calling
+func (s *Snowflake) setLayout(layout SnowflakeLayout) error {
+	if layout.WorkeridBits == 0 && layout.SequenceBits == 0 {
+		layout.WorkeridBits, layout.SequenceBits = workeridBits, sequenceBits
+	}
+	if layout.WorkeridBits == 0 || layout.SequenceBits == 0 || layout.DatacenterBits+layout.WorkeridBits+layout.SequenceBits > layoutMaxBits {
+		return ErrLayout
+	}
+	if layout.Datacenter < 0 || layout.Datacenter > int64(-1^(-1<<layout.DatacenterBits)) {
+		return ErrLayout
+	}
+	if layout.MaxRollback == 0 {
+		layout.MaxRollback = MAX_ROLLBACK
+	}
+	s.layout = layout
+	s.workeridMax = int64(-1 ^ (-1 << layout.WorkeridBits))
+	s.sequenceMax = int64(-1 ^ (-1 << layout.SequenceBits))
+	return nil
+}
+
+// 未设置布局时使用默认布局, need s.Lock before calling
+func (s *Snowflake) defaultLayout() {
+	if s.workeridMax == 0 {
+		s.setLayout(SnowflakeLayout{})
+	}
+}
+
+func (s *Snowflake) Layout() SnowflakeLayout {
+	s.Lock()
+	defer s.Unlock()
+	s.defaultLayout()
+	return s.layout
+}
+
+// 当前布局支持的最大机器id
+func (s *Snowflake) WorkeridMax() int64 {
+	s.Lock()
+	defer s.Unlock()
+	s.defaultLayout()
+	return s.workeridMax
+}
+
 func (s *Snowflake) Init(workerid int64) {
-	if workerid < 0 || workerid > workeridMax {
-		log.Fatalln("workerid must be between 0 and 1023")
+	if max := s.WorkeridMax(); workerid < 0 || workerid > max {
+		log.Fatalln("workerid must be between 0 and", max)
 		return
 	}
 
+	s.Lock()
 	s.workerid = workerid
+	s.revoked = false
+	s.Unlock()
 	log.Println("snowflake [  workid : ", workerid, "]")
 }
 
+// 恢复上次持久化的时间戳, 时钟追上前沿用该时间戳继续分配
+func (s *Snowflake) Restore(timestamp int64) {
+	s.Lock()
+	if timestamp > s.timestamp {
+		s.timestamp = timestamp
+	}
+	if timestamp > s.restored {
+		s.restored = timestamp
+	}
+	s.Unlock()
+}
+
+// 时间戳上限已持久化, 只分配不超过上限的id
+// 宕机重启后从持久化的上限恢复, 不会重复
+func (s *Snowflake) Reserve(timestamp int64) {
+	s.Lock()
+	if timestamp > s.reserved {
+		s.reserved = timestamp
+	}
+	s.Unlock()
+}
+
+// 机器id失效(租约丢失或释放), 重新Init前NextId返回错误, UUID记录错误后照常分配
+func (s *Snowflake) Revoke() {
+	s.Lock()
+	s.revoked = true
+	s.Unlock()
+}
+
+// 最近生成id使用的时间戳(ms)
+func (s *Snowflake) Timestamp() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.timestamp
+}
+
 // Generate creates and returns a unique snowflake ID
+// 不阻塞: 时钟回拨超过MaxRollback时沿用上次时间戳继续分配
+// 机器id失效或时间戳超过持久化上限时记录错误后照常分配, 需要严格检查时使用NextId
 func (s *Snowflake) UUID() int64 {
+	id, err := s.nextId(true)
+	if err != nil {
+		log.Println("snowflake uuid error", err)
+	}
+	return id
+}
+
+// 生成id, 时钟回拨超过MaxRollback时返回ErrClockRollback
+// 机器id失效返回ErrWorkeridRevoked, 时间戳超过持久化上限返回ErrNotReserved
+func (s *Snowflake) NextId() (int64, error) {
+	return s.nextId(false)
+}
+
+// borrow为true时回拨沿用上次时间戳, 失效或未预留时仍返回id和对应错误
+func (s *Snowflake) nextId(borrow bool) (int64, error) {
 	s.Lock()
-	now := time.Now().UnixNano() / 1000000
-	if s.timestamp == now {
-		s.sequence = (s.sequence + 1) & sequenceMask
+	defer s.Unlock()
+	s.defaultLayout()
+	waited := false
+	for {
+		var err error
+		now := time.Now().UnixNano() / 1000000
+		if s.revoked {
+			if !borrow {
+				return 0, ErrWorkeridRevoked
+			}
+			err = ErrWorkeridRevoked
+		}
+		//序列用完会进到下一毫秒, 留出1ms
+		if s.reserved != 0 && (now >= s.reserved || s.timestamp >= s.reserved) {
+			if !borrow {
+				return 0, ErrNotReserved
+			}
+			err = ErrNotReserved
+		}
 
-		if s.sequence == 0 {
-			for now <= s.timestamp {
-				now = time.Now().UnixNano() / 1000000
+		//小幅回拨或序列用完时等待时钟, 等待期间释放锁
+		var sleep time.Duration
+		if now < s.timestamp && !waited {
+			if rollback := time.Duration(s.timestamp-now) * time.Millisecond; rollback <= s.layout.MaxRollback {
+				sleep = rollback
 			}
+		} else if now == s.timestamp && (s.sequence+1)&s.sequenceMax == 0 {
+			sleep = time.Until(time.UnixMilli(s.timestamp + 1))
+		}
+		if sleep > 0 {
+			waited = true
+			s.Unlock()
+			time.Sleep(sleep)
+			s.Lock()
+			continue
+		}
+
+		id, genErr := s.generate(now, borrow)
+		if genErr != nil {
+			return 0, genErr
+		}
+		return id, err
+	}
+}
+
+// need s.Lock before calling
+func (s *Snowflake) generate(now int64, borrow bool) (int64, error) {
+	if now < s.timestamp {
+		//时钟还未追上恢复的时间戳时不是回拨
+		if !borrow && now >= s.restored {
+			return 0, ErrClockRollback
+		}
+		now = s.timestamp
+	}
+
+	if s.timestamp == now {
+		s.sequence = (s.sequence + 1) & s.sequenceMax
+		if s.sequence == 0 {
+			//沿用旧时间戳时序列用完, 直接进一毫秒
+			now = s.timestamp + 1
 		}
 	} else {
 		s.sequence = 0
 	}
 
 	s.timestamp = now
-	r := int64((now-twepoch)<<timestampShift | (s.workerid << workeridShift) | (s.sequence))
-	s.Unlock()
-	return r
+	sequenceBits := s.layout.SequenceBits
+	workeridShift := sequenceBits
+	datacenterShift := workeridShift + s.layout.WorkeridBits
+	timestampShift := datacenterShift + s.layout.DatacenterBits
+	return (now-twepoch)<<timestampShift | s.layout.Datacenter<<datacenterShift | s.workerid<<workeridShift | s.sequence, nil
+}
+
+// 按当前布局解析id
+func (s *Snowflake) Parse(id int64) (ts int64, datacenter int64, workerId int64, seq int64) {
+	layout := s.Layout()
+	seq = id & int64(-1^(-1<<layout.SequenceBits))
+	id >>= layout.SequenceBits
+	workerId = id & int64(-1^(-1<<layout.WorkeridBits))
+	id >>= layout.WorkeridBits
+	datacenter = id & int64(-1^(-1<<layout.DatacenterBits))
+	id >>= layout.DatacenterBits
+	ts = id + twepoch
+	return ts, datacenter, workerId, seq
 }
 
 func ParseUUID(id int64) (ts int64, workerId int64, seq int64) {
@@ -0,0 +1,160 @@
+package base
+
+import (
+	"testing"
+	"time"
+)
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / 1000000
+}
+
+func TestSnowflakeSequenceOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout SnowflakeLayout
+	}{
+		{"default", SnowflakeLayout{}},
+		{"small sequence", SnowflakeLayout{WorkeridBits: 12, SequenceBits: 2}},
+		{"datacenter", SnowflakeLayout{DatacenterBits: 3, WorkeridBits: 8, SequenceBits: 3, Datacenter: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Snowflake{}
+			if err := s.SetLayout(tt.layout); err != nil {
+				t.Fatal(err)
+			}
+			s.Init(7)
+			last := int64(0)
+			for i := 0; i < 5000; i++ {
+				id, err := s.NextId()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id <= last {
+					t.Fatalf("id %d not increasing after %d", id, last)
+				}
+				last = id
+				_, datacenter, workerId, _ := s.Parse(id)
+				if workerId != 7 || datacenter != tt.layout.Datacenter {
+					t.Fatalf("parse id %d got workerid %d datacenter %d", id, workerId, datacenter)
+				}
+			}
+		})
+	}
+}
+
+func TestSnowflakeRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		ahead    int64 //上次时间戳领先时钟的ms
+		restored bool
+		err      error
+	}{
+		{"small rollback waits", 2, false, nil},
+		{"big rollback", 1000, false, ErrClockRollback},
+		{"restored ahead", 1000, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Snowflake{}
+			s.Init(1)
+			ts := nowMs() + tt.ahead
+			if tt.restored {
+				s.Restore(ts)
+			} else {
+				s.timestamp = ts
+			}
+			id, err := s.NextId()
+			if err != tt.err {
+				t.Fatalf("NextId error %v, want %v", err, tt.err)
+			}
+			if err == nil {
+				if got, _, _, _ := s.Parse(id); got < ts {
+					t.Fatalf("id timestamp %d before last %d", got, ts)
+				}
+			}
+			//UUID沿用上次时间戳, 不早于已用过的
+			if got, _, _, _ := s.Parse(s.UUID()); got < ts {
+				t.Fatalf("uuid timestamp %d before last %d", got, ts)
+			}
+		})
+	}
+}
+
+func TestSnowflakeRevoke(t *testing.T) {
+	s := &Snowflake{}
+	s.Init(1)
+	s.Revoke()
+	if _, err := s.NextId(); err != ErrWorkeridRevoked {
+		t.Fatalf("NextId error %v, want %v", err, ErrWorkeridRevoked)
+	}
+	//UUID不阻塞, 沿用原机器id
+	if _, _, workerId, _ := s.Parse(s.UUID()); workerId != 1 {
+		t.Fatalf("workerid %d, want 1", workerId)
+	}
+	s.Init(2)
+	id, err := s.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, workerId, _ := s.Parse(id); workerId != 2 {
+		t.Fatalf("workerid %d, want 2", workerId)
+	}
+}
+
+func TestSnowflakeReserve(t *testing.T) {
+	s := &Snowflake{}
+	s.Init(1)
+	s.Reserve(nowMs() - 1)
+	if _, err := s.NextId(); err != ErrNotReserved {
+		t.Fatalf("NextId error %v, want %v", err, ErrNotReserved)
+	}
+	last := s.UUID()
+	if last == 0 || s.UUID() <= last {
+		t.Fatalf("uuid blocked or not increasing after %d", last)
+	}
+	s.Reserve(nowMs() + 1000)
+	if _, err := s.NextId(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnowflakeRollbackUnlocked(t *testing.T) {
+	s := &Snowflake{}
+	if err := s.SetLayout(SnowflakeLayout{MaxRollback: 300 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	s.Init(1)
+	s.timestamp = nowMs() + 200
+	done := make(chan error)
+	go func() {
+		_, err := s.NextId()
+		done <- err
+	}()
+	//等待回拨期间不持有锁
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	s.Timestamp()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("lock held while waiting rollback %v", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnowflakeLayoutInvalid(t *testing.T) {
+	tests := []SnowflakeLayout{
+		{WorkeridBits: 12},
+		{WorkeridBits: 16, SequenceBits: 10},
+		{DatacenterBits: 2, WorkeridBits: 10, SequenceBits: 10, Datacenter: 4},
+		{WorkeridBits: 10, SequenceBits: 10, Datacenter: -1},
+	}
+	for _, layout := range tests {
+		s := &Snowflake{}
+		if err := s.SetLayout(layout); err != ErrLayout {
+			t.Fatalf("layout %+v error %v, want %v", layout, err, ErrLayout)
+		}
+	}
+}
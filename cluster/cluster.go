@@ -2,7 +2,6 @@ package cluster
 
 import (
 	"context"
-	"errors"
 	"reflect"
 	"sync"
 	"time"
@@ -10,16 +9,18 @@ import (
 	"github.com/fengqk/mars-base/actor"
 	"github.com/fengqk/mars-base/base"
 	"github.com/fengqk/mars-base/base/vector"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/cluster/etcd"
+	"github.com/fengqk/mars-base/cluster/transport"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/network"
 	"github.com/fengqk/mars-base/rpc"
-	"github.com/nats-io/nats.go"
 )
 
 const (
 	MAX_CLUSTER_NUM = int(rpc.SERVICE_NUM)
 	CALL_TIME_OUT   = 500 * time.Millisecond
+	READY_CHECK     = 100 * time.Millisecond //等待缓存同步的检查间隔
 )
 
 var (
@@ -31,9 +32,21 @@ type (
 	ClusterSocketMap map[uint32]*common.ClusterInfo
 
 	Op struct {
+		discovery            discovery.Discovery
+		transport            transport.Transport
+		transportConf        *common.Transport
+		mailBox              bool
 		mailBoxEndpoints     []string
+		mailBoxConf          *common.MailBox
+		adminConf            *common.Admin
+		stubMailBox          bool
 		stubMailBoxEndpoints []string
 		stub                 common.Stub
+		namespace            string
+		realmId              uint32
+		manualReady          bool
+		balanceRing          bool
+		callPolicyConf       []common.CallPolicy
 	}
 
 	OpOption func(*Op)
@@ -47,8 +60,26 @@ type (
 		GetCluster(rpc.RpcHead) *common.ClusterInfo
 		BindPacketFunc(packetFunc network.PacketFunc)
 		CallMsg(interface{}, rpc.RpcHead, string, ...interface{}) error //同步给集群特定服务器
-		RandomCluster(head rpc.RpcHead) rpc.RpcHead                     //随机分配
+		CallMsgTimeout(interface{}, rpc.RpcHead, time.Duration, string, ...interface{}) error
+		AsyncCallMsg(actor.IActor, interface{}, rpc.RpcHead, time.Duration, string, ...interface{}) //异步call, 结果投递到调用actor
+		SetCallPolicy(service rpc.SERVICE, policy CallPolicy)                                       //重试/熔断/对冲策略
+		RandomCluster(head rpc.RpcHead) rpc.RpcHead                                                 //随机分配
 		IsEnoughStub(stub rpc.STUB) bool
+		StubOwners(stub rpc.STUB) map[int64]uint32                       //stub id到持有者集群id
+		OpenStream(rpc.RpcHead, string, ...interface{}) (*Stream, error) //建立集群流
+		AcceptStream(ctx context.Context) *Stream                        //服务端获取流
+		WatchCluster(funcName string, services ...rpc.SERVICE)           //订阅集群成员变化
+		UnWatchCluster(funcName string)
+		SetNodeState(state rpc.NODE)                        //修改本节点状态, 注册时为starting, 就绪后需设为ready
+		Drain()                                             //排空本节点
+		Close()                                             //停止本节点, 通告stopping后注销
+		SetLoad(load int64, metrics map[string]int64)       //上报本节点负载
+		NewElection(name string, funcName string) *Election //集群单例服务选主
+		Synced() bool                                       //本地缓存已同步
+		RegisterAdmin(cmd string, fun AdminFunc)            //注册管理命令
+		RealmId() uint32                                    //本集群所属区服
+		//跨区服网关
+		NewFederation(conf *common.Federation) (*Federation, error)
 	}
 
 	Cluster struct {
@@ -57,14 +88,37 @@ type (
 		clusterMap     [MAX_CLUSTER_NUM]ClusterMap
 		clusterLocker  [MAX_CLUSTER_NUM]*sync.RWMutex
 		hashRing       [MAX_CLUSTER_NUM]*base.HashRing
-		conn           *nats.Conn
+		transport      transport.Transport
+		compress       int //发往传输层时RpcBody超过该字节数压缩, 0不压缩
 		dieChan        chan bool
 		master         *Master
+		discovery      discovery.Discovery
 		clusterInfoMap map[uint32]*common.ClusterInfo
 		packetFuncList *vector.Vector[network.PacketFunc]
 		MailBox        etcd.MailBox
 		StubMailBox    etcd.StubMailBox
 		Stub           common.Stub
+		streamSeed     uint32
+		streamMap      map[streamKey]*Stream
+		streamLocker   *sync.RWMutex
+		callSeed       uint32
+		callMap        map[string]chan []byte
+		callLocker     *sync.RWMutex
+		policyMap      map[rpc.SERVICE]*CallPolicy
+		breakerMap     map[uint32]*breaker
+		policyLocker   *sync.RWMutex
+		watcherList    []*clusterWatcher
+		stubList       []*Stub
+		stubLocker     *sync.Mutex
+		stubPendingMap map[rpc.STUB][]*stubPacket
+		adminMap       map[string]AdminFunc
+		adminLocker    *sync.RWMutex
+		adminToken     string
+		adminAllowMap  map[string]bool
+		realmId        uint32
+		federation     *Federation
+		realmLocker    *sync.Mutex
+		federationMap  map[uint32]chan []byte
 	}
 
 	EmptyClusterInfo struct {
@@ -88,44 +142,175 @@ func (c *Cluster) InitCluster(info *common.ClusterInfo, endpoints []string, nats
 	}
 	c.clusterInfoMap = make(map[uint32]*common.ClusterInfo)
 	c.packetFuncList = &vector.Vector[network.PacketFunc]{}
+	c.streamMap = make(map[streamKey]*Stream)
+	c.streamLocker = &sync.RWMutex{}
+	c.callMap = make(map[string]chan []byte)
+	c.callLocker = &sync.RWMutex{}
+	c.policyMap = make(map[rpc.SERVICE]*CallPolicy)
+	c.breakerMap = make(map[uint32]*breaker)
+	c.policyLocker = &sync.RWMutex{}
+	c.stubLocker = &sync.Mutex{}
+	c.stubPendingMap = make(map[rpc.STUB][]*stubPacket)
+	c.realmLocker = &sync.Mutex{}
+	c.federationMap = make(map[uint32]chan []byte)
+	go c.runStream()
 
-	conn, err := setupNatsConn(
-		natsUrl,
-		c.dieChan,
-	)
-	if err != nil {
-		base.LOG.Fatalln("nats connect error!!!!")
+	op := Op{}
+	op.applyOpts(params)
+	c.realmId = op.realmId
+	if op.balanceRing {
+		for i := 0; i < MAX_CLUSTER_NUM; i++ {
+			c.hashRing[i].SetBalance()
+		}
 	}
-	c.conn = conn
+	if err := c.SetCallPolicyConf(op.callPolicyConf); err != nil {
+		base.LOG.Fatalln("call policy error", err)
+	}
+	if op.transportConf != nil {
+		c.compress = int(op.transportConf.Compress)
+	}
+	c.transport = op.transport
+	if c.transport == nil {
+		var err error
+		if op.transportConf != nil {
+			c.transport, err = transport.New(op.transportConf, info)
+		} else {
+			c.transport, err = transport.NewNats(natsUrl, c.dieChan)
+		}
+		if err != nil {
+			base.LOG.Fatalln("transport connect error!!!!", err)
+		}
+	}
+	c.transport = transport.NewNamespace(c.transport, op.namespace)
+
+	c.transport.Subscribe(getChannel(*info), func(data []byte, reply string) {
+		c.HandlePacket(rpc.Packet{Buff: data})
+	})
 
-	c.conn.Subscribe(getChannel(*info), func(msg *nats.Msg) {
-		c.HandlePacket(rpc.Packet{Buff: msg.Data})
+	c.transport.Subscribe(getTopicChannel(*info), func(data []byte, reply string) {
+		packet := rpc.Packet{Buff: data}
+		rpcPacket, _, err := rpc.UnmarshalPacket(data)
+		if err != nil {
+			base.LOG.Printf("rpc [%s] decompress error %v, dropped", rpcPacket.FuncName, err)
+			return
+		}
+		packet.RpcPacket = rpcPacket
+		if c.isLocalSrc(packet) { //本节点的广播已本地投递
+			return
+		}
+		c.HandlePacket(packet)
 	})
 
-	c.conn.Subscribe(getTopicChannel(*info), func(msg *nats.Msg) {
-		c.HandlePacket(rpc.Packet{Buff: msg.Data})
+	c.transport.Subscribe(getCallChannel(*info), func(data []byte, reply string) {
+		c.HandlePacket(rpc.Packet{Buff: data, Reply: reply})
 	})
 
-	c.conn.Subscribe(getCallChannel(*info), func(msg *nats.Msg) {
-		c.HandlePacket(rpc.Packet{Buff: msg.Data, Reply: msg.Reply})
+	c.transport.Subscribe(getStreamChannel(info.Id()), func(data []byte, reply string) {
+		c.handleStream(data)
 	})
 
-	op := Op{}
-	op.applyOpts(params)
+	c.initAdmin(info.Id(), op.adminConf)
+
+	c.discovery = op.discovery
+	if c.discovery == nil {
+		c.discovery = discovery.NewEtcd(endpoints)
+	}
+	c.discovery = discovery.NewNamespace(c.discovery, op.namespace)
 	if len(op.mailBoxEndpoints) > 0 {
-		c.MailBox.Init(info, op.mailBoxEndpoints)
+		c.MailBox.InitConf(info, discovery.NewNamespace(discovery.NewEtcd(op.mailBoxEndpoints), op.namespace), op.mailBoxConf)
+	} else if op.mailBox {
+		c.MailBox.InitConf(info, c.discovery, op.mailBoxConf)
 	}
 	if len(op.stubMailBoxEndpoints) > 0 {
-		c.StubMailBox.Init(info, op.stubMailBoxEndpoints)
+		c.StubMailBox.Init(info, discovery.NewNamespace(discovery.NewEtcd(op.stubMailBoxEndpoints), op.namespace))
+		c.Stub = op.stub
+		c.StubMailBox.OnChange(c.flushStub)
+	} else if op.stubMailBox {
+		c.StubMailBox.Init(info, c.discovery)
 		c.Stub = op.stub
+		c.StubMailBox.OnChange(c.flushStub)
 	}
 
 	rpc.MGR = c
 	actor.MGR.RegisterActor(c)
 	c.Actor.Start()
 	//注册服务器
-	c.Service = NewService(info, endpoints)
-	c.master = NewMaster(&EmptyClusterInfo{}, endpoints)
+	c.Service = NewService(info, c.discovery)
+	c.master = NewMaster(&EmptyClusterInfo{}, c.discovery)
+	if !op.manualReady {
+		go c.readyOnSynced()
+	}
+}
+
+// 缓存同步完成后由starting切换为ready, 期间已drain或close则不再切换
+func (c *Cluster) readyOnSynced() {
+	ticker := time.NewTicker(READY_CHECK)
+	defer ticker.Stop()
+	for range ticker.C {
+		service := (*etcd.Service)(c.Service)
+		if service.NodeState() != rpc.NODE_STARTING {
+			return
+		}
+		if c.Synced() {
+			service.SwapNodeState(rpc.NODE_STARTING, rpc.NODE_READY)
+			return
+		}
+	}
+}
+
+// 本节点注册会话, 可通过OnLost感知注册丢失
+func (c *Cluster) Session() *discovery.Session {
+	return (*etcd.Service)(c.Service).Session()
+}
+
+// 修改本节点状态, 非ready节点不参与随机和负载均衡分配
+func (c *Cluster) SetNodeState(state rpc.NODE) {
+	(*etcd.Service)(c.Service).SetNodeState(state)
+}
+
+// 停止本节点, 先通告stopping再注销注册信息
+func (c *Cluster) Close() {
+	(*etcd.Service)(c.Service).Close()
+}
+
+// 排空本节点, 滚动发布前调用, 持有的stub迁移到其他节点
+func (c *Cluster) Drain() {
+	(*etcd.Service)(c.Service).Drain()
+	c.handoffStub()
+}
+
+// 上报本节点负载
+func (c *Cluster) SetLoad(load int64, metrics map[string]int64) {
+	(*etcd.Service)(c.Service).SetLoad(load, metrics)
+}
+
+// 集群成员和mailbox/stub缓存已与注册中心同步, 监听中断重新同步期间为false
+func (c *Cluster) Synced() bool {
+	if !(*etcd.Master)(c.master).Synced() {
+		return false
+	}
+	if c.MailBox.Discovery() != nil && !c.MailBox.Synced() {
+		return false
+	}
+	if c.StubMailBox.Discovery() != nil && !c.StubMailBox.Synced() {
+		return false
+	}
+	return true
+}
+
+// 本集群所属区服, 0为未配置
+func (c *Cluster) RealmId() uint32 {
+	return c.realmId
+}
+
+// 集群使用的传输层
+func (c *Cluster) Transport() transport.Transport {
+	return c.transport
+}
+
+// 集群使用的服务发现
+func (c *Cluster) Discovery() discovery.Discovery {
+	return c.discovery
 }
 
 func (c *Cluster) RegisterClusterCall() {
@@ -136,7 +321,15 @@ func (c *Cluster) AddCluster(info *common.ClusterInfo) {
 	c.clusterLocker[info.Type].Lock()
 	c.clusterMap[info.Type][info.Id()] = info
 	c.clusterLocker[info.Type].Unlock()
-	c.hashRing[info.Type].Add(info.IpString())
+	//非ready节点不参与分配, 点对点照常投递
+	if info.State == rpc.NODE_READY {
+		c.hashRing[info.Type].AddWeight(info.IpString(), clusterWeight(info))
+	} else {
+		c.hashRing[info.Type].Remove(info.IpString())
+	}
+	if t, bOk := c.transport.(transport.PeerTransport); bOk {
+		t.AddPeer(info)
+	}
 	base.LOG.Printf("服务器[%s:%s:%d]建立连接", info.String(), info.Ip, info.Port)
 }
 
@@ -151,6 +344,10 @@ func (c *Cluster) DelCluster(info *common.ClusterInfo) {
 	}
 
 	c.hashRing[info.Type].Remove(info.IpString())
+	c.delBreaker(info.Id())
+	if t, bOk := c.transport.(transport.PeerTransport); bOk {
+		t.DelPeer(info)
+	}
 	base.LOG.Printf("服务器[%s:%s:%d]断开连接", info.String(), info.Ip, info.Port)
 }
 
@@ -168,54 +365,149 @@ func (c *Cluster) BindPacketFunc(callfunc network.PacketFunc) {
 	c.packetFuncList.PushBack(callfunc)
 }
 
-func (c *Cluster) HandlePacket(packet rpc.Packet) {
+func (c *Cluster) HandlePacket(packet rpc.Packet) bool {
 	for _, v := range c.packetFuncList.Values() {
 		if v(packet) {
-			break
+			return true
 		}
 	}
+	return false
 }
 
 func (c *Cluster) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
 	head.SrcClusterId = c.Id()
+	head.SrcRealmId = c.realmId
 	c.Send(head, rpc.Marshal(&head, &funcName, params...))
 }
 
 func (c *Cluster) Send(head rpc.RpcHead, packet rpc.Packet) {
+	//其他区服的消息交给本区服网关
+	if head.RealmId != 0 && head.RealmId != c.realmId {
+		c.sendFederation(head.RealmId, c.wire(packet))
+		return
+	}
+
 	switch head.SendType {
-	//case rpc.SEND_BALANCE:
-	//	_, head.ClusterId = c.hashRing[head.DestServerType].Get64(head.Id)
-	//	c.conn.Publish(getRpcChannel(head), packet.Buff)
+	case rpc.SEND_BALANCE:
+		if !c.balance(&head) {
+			base.LOG.Printf("SEND MSG [%s] NO CLUSTER TO BALANCE", head.DestServerType.String())
+			return
+		}
+		c.sendPoint(head, packet)
 	case rpc.SEND_POINT:
-		if head.ClusterId == 0 && head.DestServerType == rpc.SERVICE_GAME {
-			pMailBox := c.MailBox.Get(head.Id)
-			if pMailBox != nil {
-				head.ClusterId = pMailBox.ClusterId
+		if !c.route(&head) {
+			c.pendStub(head, packet, time.Now().Add(STUB_PENDING_TIME))
+			return
+		}
+		c.sendPoint(head, packet)
+	default:
+		if head.DestServerType == c.Type && c.isLocalSrc(packet) {
+			c.sendLocal(head, packet)
+		}
+		c.transport.Publish(getRpcTopicChannel(head), c.wire(packet))
+	}
+}
+
+func (c *Cluster) sendPoint(head rpc.RpcHead, packet rpc.Packet) {
+	if head.ClusterId == c.Id() {
+		c.sendLocal(head, packet)
+		return
+	}
+	c.transport.Publish(getRpcChannel(head), c.wire(packet))
+}
+
+// 发往传输层的数据, 按传输配置压缩, 本地投递仍用RpcPacket原文
+func (c *Cluster) wire(packet rpc.Packet) []byte {
+	return rpc.Compress(packet, c.compress)
+}
+
+// SEND_POINT未指定集群id时, 通过mailbox或stub查找目标集群, stub暂无持有者时返回false
+func (c *Cluster) route(head *rpc.RpcHead) bool {
+	if head.ClusterId != 0 {
+		return true
+	}
+	//ActorName为MAIL类型时按(MailType, Id)查找, game服的其他actor兼容按player查找
+	mailType, bEx := rpc.MAIL_value[head.ActorName]
+	if !bEx && head.DestServerType == rpc.SERVICE_GAME {
+		mailType, bEx = int32(rpc.MAIL_Player), true
+	}
+	if bEx && c.MailBox.Discovery() != nil {
+		pMailBox := c.MailBox.GetMail(rpc.MAIL(mailType), head.Id)
+		if pMailBox != nil {
+			head.ClusterId = pMailBox.ClusterId
+		}
+	} else {
+		stubType, bEx := rpc.STUB_value[head.ActorName]
+		if !bEx || stubType >= int32(rpc.STUB_END) {
+			return true
+		}
+		stubCount := c.StubCount(rpc.STUB(stubType))
+		if stubCount > 0 {
+			index := head.Id % stubCount
+			if c.Stub.JumpHash {
+				index = base.JumpHash(uint64(head.Id), stubCount)
 			}
-		} else if head.ClusterId == 0 {
-			stubCount, bEx := c.Stub.StubCount[head.ActorName]
-			if bEx {
-				index := head.Id % stubCount
-				stubType := rpc.STUB(rpc.STUB_value[head.ActorName])
-				pStub := c.StubMailBox.Get(stubType, index)
-				if pStub != nil {
-					head.ClusterId = pStub.ClusterId
-				}
+			pStub := c.StubMailBox.Get(rpc.STUB(stubType), index)
+			if pStub == nil {
+				return false
 			}
+			head.ClusterId = pStub.ClusterId
 		}
-		c.conn.Publish(getRpcChannel(head), packet.Buff)
-	default:
-		c.conn.Publish(getRpcTopicChannel(head), packet.Buff)
 	}
+	return true
+}
+
+// SEND_BALANCE, 有Id按一致性hash粘滞到同一节点, 否则按Weight加权随机
+func (c *Cluster) balance(head *rpc.RpcHead) bool {
+	if head.Id != 0 {
+		err, clusterId := c.hashRing[head.DestServerType].Get64(head.Id)
+		head.ClusterId = clusterId
+		return err == nil
+	}
+
+	c.clusterLocker[head.DestServerType].RLock()
+	defer c.clusterLocker[head.DestServerType].RUnlock()
+	total := 0
+	for _, v := range c.clusterMap[head.DestServerType] {
+		if v.State == rpc.NODE_READY {
+			total += clusterWeight(v)
+		}
+	}
+	if total == 0 {
+		return false
+	}
+	n := base.RAND.RandI(1, total)
+	for clusterId, v := range c.clusterMap[head.DestServerType] {
+		if v.State != rpc.NODE_READY {
+			continue
+		}
+		n -= clusterWeight(v)
+		if n <= 0 {
+			head.ClusterId = clusterId
+			return true
+		}
+	}
+	return false
+}
+
+func clusterWeight(info *common.ClusterInfo) int {
+	if info.Weight <= 0 {
+		return 1
+	}
+	return int(info.Weight)
 }
 
 // params[0]:rpc.RpcHead
 // params[1]:error
+// params[2:]:返回值
 func (c *Cluster) Call(parmas ...interface{}) {
 	head := *parmas[0].(*rpc.RpcHead)
 	reply := head.Reply
 	head.Reply = ""
 	head.ClusterId = head.SrcClusterId
+	if len(parmas) < 2 {
+		parmas = append(parmas, nil)
+	}
 	if parmas[1] == nil {
 		parmas[1] = ""
 	} else {
@@ -223,65 +515,56 @@ func (c *Cluster) Call(parmas ...interface{}) {
 	}
 	funcName := ""
 	packet := rpc.Marshal(&head, &funcName, parmas[1:]...)
-	c.conn.Publish(reply, packet.Buff)
+	if c.replyLocal(reply, packet.Buff) {
+		return
+	}
+	c.transport.Publish(reply, c.wire(packet))
 }
 
 func (c *Cluster) CallMsg(cb interface{}, head rpc.RpcHead, funcName string, params ...interface{}) error {
-	head.SrcClusterId = c.Id()
-	packet := rpc.Marshal(&head, &funcName, params...)
-
-	switch head.SendType {
-	//case rpc.SEND_BALANCE:
-	//	_, head.ClusterId = c.hashRing[head.DestServerType].Get64(head.Id)
-	//	c.conn.Publish(getRpcChannel(head), packet.Buff)
-	case rpc.SEND_POINT:
-		if head.ClusterId == 0 && head.DestServerType == rpc.SERVICE_GAME {
-			pMailBox := c.MailBox.Get(head.Id)
-			if pMailBox != nil {
-				head.ClusterId = pMailBox.ClusterId
-			}
-		} else if head.ClusterId == 0 {
-			stubCount, bEx := c.Stub.StubCount[head.ActorName]
-			if bEx {
-				index := head.Id % stubCount
-				stubType := rpc.STUB(rpc.STUB_value[head.ActorName])
-				pStub := c.StubMailBox.Get(stubType, index)
-				if pStub != nil {
-					head.ClusterId = pStub.ClusterId
-				}
-			}
-		}
-	default:
-		base.LOG.Printf("CALL MSG [%s] CAN NOT BOARDCAST", funcName)
-		//_, head.ClusterId = c.hashRing[head.DestServerType].Get64(head.Id)
-	}
+	return c.CallMsgTimeout(cb, head, CALL_TIME_OUT, funcName, params...)
+}
 
-	reply, err := c.conn.Request(getRpcCallChannel(head), packet.Buff, CALL_TIME_OUT)
+// 同CallMsg, 指定超时
+func (c *Cluster) CallMsgTimeout(cb interface{}, head rpc.RpcHead, timeout time.Duration, funcName string, params ...interface{}) error {
+	head, packet := c.prepareCall(head, funcName, params...)
+	data, err := c.request(head, packet, timeout)
 	if err == nil {
-		rpcPacket, _ := rpc.Unmarshal(reply.Data)
-		cf := &CallFunc{Func: cb, FuncVal: reflect.ValueOf(cb), FuncType: reflect.TypeOf(cb), FuncParams: reflect.TypeOf(cb).String()}
-		f := cf.FuncVal
-		k := cf.FuncType
-		err, params := rpc.UnmarshalBodyCall(rpcPacket, k)
+		var in []reflect.Value
+		in, err = decodeCallBack(cb, data)
 		if err != nil {
 			return err
 		}
-		iLen := len(params)
-		if iLen >= 1 {
-			in := make([]reflect.Value, iLen)
-			for i, param := range params {
-				in[i] = reflect.ValueOf(param)
-			}
-
-			f.Call(in)
-		} else {
-			base.LOG.Printf("CallMsg [%s] params at least one context", funcName)
-			return errors.New("callmsg params at least one context")
-		}
+		reflect.ValueOf(cb).Call(in)
 	}
 	return err
 }
 
+// 目标集群在request中按次查找, 重试时重新balance/route
+func (c *Cluster) prepareCall(head rpc.RpcHead, funcName string, params ...interface{}) (rpc.RpcHead, rpc.Packet) {
+	head.SrcClusterId = c.Id()
+	packet := rpc.Marshal(&head, &funcName, params...)
+	if head.SendType != rpc.SEND_BALANCE && head.SendType != rpc.SEND_POINT {
+		base.LOG.Printf("CALL MSG [%s] CAN NOT BOARDCAST", funcName)
+	}
+	return head, packet
+}
+
+// call的目标集群, 找不到时返回ErrNoCluster, 不再向集群id 0发请求
+func (c *Cluster) callTarget(head *rpc.RpcHead) error {
+	bOk := false
+	switch head.SendType {
+	case rpc.SEND_BALANCE:
+		bOk = c.balance(head)
+	case rpc.SEND_POINT:
+		bOk = c.route(head)
+	}
+	if !bOk || head.ClusterId == 0 {
+		return ErrNoCluster
+	}
+	return nil
+}
+
 func (c *Cluster) RandomCluster(head rpc.RpcHead) rpc.RpcHead {
 	if head.Id == 0 {
 		head.Id = int64(uint32(base.RAND.RandI(1, 0xFFFFFFFF)))
@@ -295,22 +578,34 @@ func (c *Cluster) RandomCluster(head rpc.RpcHead) rpc.RpcHead {
 }
 
 func (c *Cluster) IsEnoughStub(stub rpc.STUB) bool {
-	return c.StubMailBox.Count(stub) == c.Stub.StubCount[stub.String()]
+	return c.StubMailBox.Count(stub) >= c.StubCount(stub)
 }
 
 // 集群新加member
 func (c *Cluster) Cluster_Add(ctx context.Context, info *common.ClusterInfo) {
-	_, bEx := c.clusterInfoMap[info.Id()]
+	pInfo, bEx := c.clusterInfoMap[info.Id()]
+	c.clusterInfoMap[info.Id()] = info
 	if !bEx {
 		c.AddCluster(info)
-		c.clusterInfoMap[info.Id()] = info
+		c.notifyCluster(CLUSTER_JOIN, info)
+	} else if pInfo.Weight != info.Weight || pInfo.State != info.State {
+		c.AddCluster(info)
+		c.notifyCluster(CLUSTER_UPDATE, info)
+	} else { //只有负载变化, 不通知
+		c.clusterLocker[info.Type].Lock()
+		c.clusterMap[info.Type][info.Id()] = info
+		c.clusterLocker[info.Type].Unlock()
 	}
 }
 
 // 集群删除member
 func (c *Cluster) Cluster_Del(ctx context.Context, info *common.ClusterInfo) {
+	_, bEx := c.clusterInfoMap[info.Id()]
 	delete(c.clusterInfoMap, info.Id())
 	c.DelCluster(info)
+	if bEx {
+		c.notifyCluster(CLUSTER_LEAVE, info)
+	}
 }
 
 func (op *Op) applyOpts(opts []OpOption) {
@@ -319,12 +614,62 @@ func (op *Op) applyOpts(opts []OpOption) {
 	}
 }
 
+// 指定服务发现后端, 默认使用endpoints连接etcd
+func WithDiscovery(d discovery.Discovery) OpOption {
+	return func(op *Op) {
+		op.discovery = d
+	}
+}
+
+// mailbox使用集群的服务发现
+func WithMailBox() OpOption {
+	return func(op *Op) {
+		op.mailBox = true
+	}
+}
+
+// stub mailbox使用集群的服务发现
+func WithStubMailBox(stub *common.Stub) OpOption {
+	return func(op *Op) {
+		op.stubMailBox = true
+		op.stub = *stub
+	}
+}
+
+// 指定传输层, 默认使用natsUrl连接nats
+func WithTransport(t transport.Transport) OpOption {
+	return func(op *Op) {
+		op.transport = t
+	}
+}
+
+// 按配置创建传输层和设置压缩阈值, 同时指定WithTransport时只设置压缩阈值
+func WithTransportConf(conf *common.Transport) OpOption {
+	return func(op *Op) {
+		op.transportConf = conf
+	}
+}
+
 func WithMailBoxEtcd(Endpoints []string) OpOption {
 	return func(op *Op) {
 		op.mailBoxEndpoints = Endpoints
 	}
 }
 
+// mailbox租约和key布局, 与旧版本节点混部时使用
+func WithMailBoxConf(conf *common.MailBox) OpOption {
+	return func(op *Op) {
+		op.mailBoxConf = conf
+	}
+}
+
+// 管理命令鉴权, 不配置时拒绝全部管理命令
+func WithAdminConf(conf *common.Admin) OpOption {
+	return func(op *Op) {
+		op.adminConf = conf
+	}
+}
+
 func WithStubMailBoxEtcd(Endpoints []string, stub *common.Stub) OpOption {
 	return func(op *Op) {
 		op.stubMailBoxEndpoints = Endpoints
@@ -332,6 +677,41 @@ func WithStubMailBoxEtcd(Endpoints []string, stub *common.Stub) OpOption {
 	}
 }
 
+// 集群命名空间, etcd key和subject都加上前缀
+func WithNamespace(conf *common.Namespace) OpOption {
+	return func(op *Op) {
+		op.namespace = conf.Name
+	}
+}
+
+// 节点保持starting直到业务调用SetNodeState(rpc.NODE_READY), 默认缓存同步后自动ready
+func WithManualReady() OpOption {
+	return func(op *Op) {
+		op.manualReady = true
+	}
+}
+
+// 按权重均匀分布的一致性hash环, 开启后按Id的路由全部重新分配, 需全集群同时开启
+func WithBalanceRing() OpOption {
+	return func(op *Op) {
+		op.balanceRing = true
+	}
+}
+
+// 按配置设置各服务类型的call策略, 也可运行时调用SetCallPolicy
+func WithCallPolicyConf(conf []common.CallPolicy) OpOption {
+	return func(op *Op) {
+		op.callPolicyConf = conf
+	}
+}
+
+// 集群所属区服, 跨区服消息通过RpcHead.RealmId指定目标区服
+func WithRealm(realmId uint32) OpOption {
+	return func(op *Op) {
+		op.realmId = realmId
+	}
+}
+
 func (c *EmptyClusterInfo) String() string {
 	return ""
 }
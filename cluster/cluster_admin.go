@@ -0,0 +1,265 @@
+package cluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	ADMIN_TIME_OUT = 3 * time.Second
+)
+
+var (
+	ErrAdminCmd    = errors.New("admin cmd not found")
+	ErrAdminArgs   = errors.New("admin args invalid")
+	ErrAdminReload = errors.New("admin reload not registered")
+	ErrAdminAuth   = errors.New("admin token invalid")
+	ErrAdminAllow  = errors.New("admin call not allowed")
+
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+type (
+	// 管理命令, 通过admin subject发给指定节点
+	AdminRequest struct {
+		Token string   `json:"token"`
+		Cmd   string   `json:"cmd"`
+		Args  []string `json:"args,omitempty"`
+	}
+
+	AdminResponse struct {
+		Error string          `json:"error,omitempty"`
+		Data  json.RawMessage `json:"data,omitempty"`
+	}
+
+	// 返回值按json编码回给请求方
+	AdminFunc func(args []string) (interface{}, error)
+
+	AdminStub struct {
+		Count  int64            `json:"count"`
+		Owners map[int64]uint32 `json:"owners"`
+	}
+
+	AdminMem struct {
+		HeapAlloc  uint64 `json:"heapalloc"`
+		HeapSys    uint64 `json:"heapsys"`
+		NumGC      uint32 `json:"numgc"`
+		Goroutines int    `json:"goroutines"`
+	}
+)
+
+// 注册管理命令, 同名覆盖内置命令, 如业务的reload
+func (c *Cluster) RegisterAdmin(cmd string, fun AdminFunc) {
+	c.adminLocker.Lock()
+	c.adminMap[cmd] = fun
+	c.adminLocker.Unlock()
+}
+
+// 未配置token时节点仍订阅admin subject, 统一回复鉴权失败
+func (c *Cluster) initAdmin(clusterId uint32, conf *common.Admin) {
+	c.adminLocker = &sync.RWMutex{}
+	c.adminAllowMap = make(map[string]bool)
+	if conf != nil {
+		c.adminToken = conf.Token
+		for _, v := range conf.Allow {
+			c.adminAllowMap[v] = true
+		}
+	}
+	c.adminMap = map[string]AdminFunc{
+		"stubs":   c.adminStubs,
+		"mailbox": c.adminMailBox,
+		"actors":  c.adminActors,
+		"drain":   c.adminDrain,
+		"reload":  c.adminReload,
+		"gc":      c.adminGc,
+		"call":    c.adminCall,
+		"schema":  c.adminSchema,
+	}
+	c.transport.Subscribe(AdminChannel(clusterId), func(data []byte, reply string) {
+		if reply != "" {
+			go c.handleAdmin(data, reply)
+		}
+	})
+}
+
+func (c *Cluster) handleAdmin(data []byte, reply string) {
+	defer func() {
+		if err := recover(); err != nil {
+			base.TraceCode(err)
+			c.replyAdmin(reply, nil, fmt.Errorf("admin panic %v", err))
+		}
+	}()
+
+	req := &AdminRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		c.replyAdmin(reply, nil, err)
+		return
+	}
+	if c.adminToken == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(c.adminToken)) != 1 {
+		base.LOG.Printf("admin cmd [%s] rejected, token invalid", req.Cmd)
+		c.replyAdmin(reply, nil, ErrAdminAuth)
+		return
+	}
+	c.adminLocker.RLock()
+	fun, bEx := c.adminMap[req.Cmd]
+	c.adminLocker.RUnlock()
+	if !bEx {
+		c.replyAdmin(reply, nil, ErrAdminCmd)
+		return
+	}
+	base.LOG.Printf("admin cmd [%s] %v", req.Cmd, req.Args)
+	val, err := fun(req.Args)
+	c.replyAdmin(reply, val, err)
+}
+
+func (c *Cluster) replyAdmin(reply string, val interface{}, err error) {
+	resp := &AdminResponse{}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if val != nil {
+		data, err := json.Marshal(val)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Data = data
+		}
+	}
+	data, _ := json.Marshal(resp)
+	c.transport.Publish(reply, data)
+}
+
+// stubs: 各类型stub数量和持有者
+func (c *Cluster) adminStubs(args []string) (interface{}, error) {
+	stubMap := make(map[string]*AdminStub)
+	for stub := rpc.STUB(0); stub < rpc.STUB_END; stub++ {
+		stubMap[stub.String()] = &AdminStub{Count: c.StubCount(stub), Owners: c.StubOwners(stub)}
+	}
+	return stubMap, nil
+}
+
+// mailbox <type> [id]: mailbox持有者
+func (c *Cluster) adminMailBox(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, ErrAdminArgs
+	}
+	mailType, bEx := rpc.MAIL_value[args[0]]
+	if !bEx || c.MailBox.Discovery() == nil {
+		return nil, ErrAdminArgs
+	}
+	if len(args) < 2 {
+		return c.MailBox.Owners(rpc.MAIL(mailType)), nil
+	}
+	Id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return c.MailBox.GetMail(rpc.MAIL(mailType), Id), nil
+}
+
+// actors: 各actor消息统计
+func (c *Cluster) adminActors(args []string) (interface{}, error) {
+	return actor.MGR.Stats(), nil
+}
+
+func (c *Cluster) adminDrain(args []string) (interface{}, error) {
+	c.Drain()
+	return "ok", nil
+}
+
+func (c *Cluster) adminReload(args []string) (interface{}, error) {
+	return nil, ErrAdminReload
+}
+
+func (c *Cluster) adminGc(args []string) (interface{}, error) {
+	runtime.GC()
+	debug.FreeOSMemory()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return &AdminMem{HeapAlloc: mem.HeapAlloc, HeapSys: mem.HeapSys, NumGC: mem.NumGC, Goroutines: runtime.NumGoroutine()}, nil
+}
+
+// schema: 本进程注册的rpc签名, 部署前与新版本比较
+func (c *Cluster) adminSchema(args []string) (interface{}, error) {
+	data, err := rpc.DumpSchema()
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// call <Actor.Func> <id> [json参数...]: 按方法参数类型解码后投递, 返回方法的返回值
+// 只能调用Admin.Allow中配置的方法
+func (c *Cluster) adminCall(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrAdminArgs
+	}
+	funcArgs := strings.Split(args[0], ".")
+	if len(funcArgs) != 2 {
+		return nil, ErrAdminArgs
+	}
+	if !c.adminAllowMap["*"] && !c.adminAllowMap[funcArgs[0]] && !c.adminAllowMap[args[0]] {
+		return nil, ErrAdminAllow
+	}
+	Id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ac := actor.MGR.GetActor(funcArgs[0])
+	if ac == nil {
+		return nil, ErrAdminArgs
+	}
+	m, bEx := reflect.TypeOf(ac).MethodByName(funcArgs[1])
+	//0为接收者, 1为context
+	if !bEx || m.Type.NumIn() < 2 || m.Type.NumIn()-2 != len(args)-2 {
+		return nil, ErrAdminArgs
+	}
+	params := make([]interface{}, 0, len(args)-2)
+	for i, arg := range args[2:] {
+		val := reflect.New(m.Type.In(i + 2))
+		if err := json.Unmarshal([]byte(arg), val.Interface()); err != nil {
+			return nil, err
+		}
+		params = append(params, val.Elem().Interface())
+	}
+
+	head := rpc.RpcHead{Id: Id, SrcClusterId: c.Id(), ClusterId: c.Id()}
+	funcName := args[0]
+	packet := rpc.Marshal(&head, &funcName, params...)
+	data, err := c.localCall(head, packet, ADMIN_TIME_OUT)
+	if err != nil {
+		return nil, err
+	}
+
+	//回包按返回值类型解码, error单独返回
+	in := []reflect.Type{contextType}
+	for i := 0; i < m.Type.NumOut(); i++ {
+		if m.Type.Out(i) != errorType {
+			in = append(in, m.Type.Out(i))
+		}
+	}
+	rpcPacket, _, err := rpc.UnmarshalPacket(data)
+	if err != nil {
+		return nil, err
+	}
+	err, rets := rpc.UnmarshalBodyCall(rpcPacket, reflect.FuncOf(in, nil, false))
+	if err != nil {
+		return nil, err
+	}
+	return rets[1:], nil
+}
@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/transport"
+)
+
+func adminRequest(t *testing.T, tr transport.Transport, clusterId uint32, req *AdminRequest) *AdminResponse {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	buff, err := tr.Request(AdminChannel(clusterId), data, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &AdminResponse{}
+	if err := json.Unmarshal(buff, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		req  AdminRequest
+		err  error
+		data string
+	}{
+		{"no token", AdminRequest{Cmd: "gc"}, ErrAdminAuth, ""},
+		{"wrong token", AdminRequest{Token: "guess", Cmd: "gc"}, ErrAdminAuth, ""},
+		{"unknown cmd", AdminRequest{Token: testAdminToken, Cmd: "shutdown"}, ErrAdminCmd, ""},
+		{"call allowed", AdminRequest{Token: testAdminToken, Cmd: "call", Args: []string{"LocalActor.Double", "0", "21"}}, nil, "[42]"},
+		{"call func not allowed", AdminRequest{Token: testAdminToken, Cmd: "call", Args: []string{"LocalActor.Ping", "0", "1"}}, ErrAdminAllow, ""},
+		{"call actor not allowed", AdminRequest{Token: testAdminToken, Cmd: "call", Args: []string{"AsyncActor.Double", "0", "1"}}, ErrAdminAllow, ""},
+		{"call no token", AdminRequest{Cmd: "call", Args: []string{"LocalActor.Double", "0", "21"}}, ErrAdminAuth, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := adminRequest(t, testTransport, MGR.Id(), &tt.req)
+			if tt.err != nil {
+				if resp.Error != tt.err.Error() {
+					t.Fatalf("error %q, want %q", resp.Error, tt.err)
+				}
+				return
+			}
+			if resp.Error != "" || string(resp.Data) != tt.data {
+				t.Fatalf("resp %q %s, want %s", resp.Error, resp.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestAdminNoConf(t *testing.T) {
+	//未配置token时拒绝全部命令
+	c := &Cluster{transport: transport.NewLoopback()}
+	defer c.transport.Close()
+	c.initAdmin(1, nil)
+	resp := adminRequest(t, c.transport, 1, &AdminRequest{Cmd: "gc"})
+	if resp.Error != ErrAdminAuth.Error() {
+		t.Fatalf("error %q", resp.Error)
+	}
+}
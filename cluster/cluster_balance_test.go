@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/fengqk/mars-base/rpc"
+)
+
+func TestBalanceWeight(t *testing.T) {
+	light, heavy, draining := newPeerInfo(rpc.SERVICE_ZONE), newPeerInfo(rpc.SERVICE_ZONE), newPeerInfo(rpc.SERVICE_ZONE)
+	light.Weight, heavy.Weight = 1, 3
+	newPeer(t, light, rpc.NODE_READY)
+	newPeer(t, heavy, rpc.NODE_READY)
+	newPeer(t, draining, rpc.NODE_DRAINING)
+
+	tests := []struct {
+		name   string
+		headId func(i int) int64
+	}{
+		{"random", func(i int) int64 { return 0 }},
+		{"sticky", func(i int) int64 { return int64(i + 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countMap := map[uint32]int{}
+			for i := 0; i < 4000; i++ {
+				head := rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_BALANCE, Id: tt.headId(i)}
+				if !MGR.balance(&head) {
+					t.Fatal("no cluster to balance")
+				}
+				countMap[head.ClusterId]++
+			}
+			//非ready节点不分配, 其余按权重分配, TestMain开启了WithBalanceRing
+			if countMap[draining.Id()] != 0 {
+				t.Fatalf("draining node selected %d", countMap[draining.Id()])
+			}
+			if ratio := float64(countMap[heavy.Id()]) / 4000; ratio < 0.6 || ratio > 0.9 {
+				t.Fatalf("heavy ratio %.2f %v", ratio, countMap)
+			}
+		})
+	}
+}
+
+func TestBalanceSticky(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		newPeer(t, newPeerInfo(rpc.SERVICE_ZONE), rpc.NODE_READY)
+	}
+	//相同Id总是选中同一节点
+	for id := int64(1); id <= 100; id++ {
+		head := rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_BALANCE, Id: id}
+		if !MGR.balance(&head) {
+			t.Fatal("no cluster to balance")
+		}
+		for i := 0; i < 5; i++ {
+			again := rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_BALANCE, Id: id}
+			MGR.balance(&again)
+			if again.ClusterId != head.ClusterId {
+				t.Fatalf("id %d moved %d -> %d", id, head.ClusterId, again.ClusterId)
+			}
+		}
+	}
+
+	//没有ready节点时返回false
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_DB, SendType: rpc.SEND_BALANCE}
+	if MGR.balance(&head) {
+		t.Fatalf("balance to %d without ready node", head.ClusterId)
+	}
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+type (
+	callErrKey struct{}
+)
+
+// 异步call, 立即返回, 回包或超时后在caller的协程执行cb
+// 失败时cb的参数为零值, 通过CallError(ctx)获取错误
+func (c *Cluster) AsyncCallMsg(caller actor.IActor, cb interface{}, head rpc.RpcHead, timeout time.Duration, funcName string, params ...interface{}) {
+	head, packet := c.prepareCall(head, funcName, params...)
+	go func() {
+		data, err := c.request(head, packet, timeout)
+		caller.Post(func() {
+			var in []reflect.Value
+			if err == nil {
+				in, err = decodeCallBack(cb, data)
+			}
+			if err != nil {
+				in = errCallBack(cb, head, err)
+			}
+			if in == nil {
+				base.LOG.Printf("AsyncCallMsg [%s] params at least one context", funcName)
+				return
+			}
+			reflect.ValueOf(cb).Call(in)
+		})
+	}()
+}
+
+// 异步call失败的原因
+func CallError(ctx context.Context) error {
+	err, _ := ctx.Value(callErrKey{}).(error)
+	return err
+}
+
+// 解码回包为cb的参数
+func decodeCallBack(cb interface{}, data []byte) ([]reflect.Value, error) {
+	rpcPacket, _, err := rpc.UnmarshalPacket(data)
+	if err != nil {
+		return nil, err
+	}
+	err, params := rpc.UnmarshalBodyCall(rpcPacket, reflect.TypeOf(cb))
+	if err != nil {
+		return nil, err
+	}
+	if len(params) < 1 {
+		return nil, errors.New("callmsg params at least one context")
+	}
+	in := make([]reflect.Value, len(params))
+	for i, param := range params {
+		in[i] = reflect.ValueOf(param)
+	}
+	return in, nil
+}
+
+func errCallBack(cb interface{}, head rpc.RpcHead, err error) []reflect.Value {
+	k := reflect.TypeOf(cb)
+	if k.NumIn() < 1 {
+		return nil
+	}
+	in := make([]reflect.Value, k.NumIn())
+	in[0] = reflect.ValueOf(context.WithValue(rpc.NewContext(context.Background(), &head), callErrKey{}, err))
+	for i := 1; i < k.NumIn(); i++ {
+		in[i] = reflect.Zero(k.In(i))
+	}
+	return in
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+type (
+	AsyncActor struct {
+		actor.Actor
+	}
+
+	asyncResult struct {
+		n   int
+		err error
+	}
+)
+
+var (
+	asyncActor = &AsyncActor{}
+)
+
+func init() {
+	registerActor(asyncActor)
+}
+
+func (a *AsyncActor) Double(ctx context.Context, n int) (int, error) {
+	if n < 0 {
+		return 0, errors.New("negative")
+	}
+	return n * 2, nil
+}
+
+func asyncCall(t *testing.T, head rpc.RpcHead, timeout time.Duration, n int) asyncResult {
+	t.Helper()
+	resultChan := make(chan asyncResult, 1)
+	MGR.AsyncCallMsg(asyncActor, func(ctx context.Context, n int) {
+		resultChan <- asyncResult{n, CallError(ctx)}
+	}, head, timeout, "Double", n)
+	select {
+	case r := <-resultChan:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("callback not invoked")
+	}
+	return asyncResult{}
+}
+
+func TestAsyncCall(t *testing.T) {
+	silent := newCallPeer(t, rpc.SERVICE_ZONE, 1<<30, 0)
+	local := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: MGR.Id(), ActorName: "AsyncActor"}
+	tests := []struct {
+		name string
+		head rpc.RpcHead
+		n    int
+		want asyncResult
+	}{
+		{"ok", local, 21, asyncResult{42, nil}},
+		{"handler error", local, -1, asyncResult{0, errors.New("negative")}},
+		{"timeout", rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_POINT, ClusterId: silent.info.Id()}, 1, asyncResult{0, transport.ErrTimeout}},
+		{"no cluster", rpc.RpcHead{DestServerType: rpc.SERVICE_DB, SendType: rpc.SEND_BALANCE}, 1, asyncResult{0, ErrNoCluster}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//失败时cb参数为零值, 错误通过CallError获取
+			r := asyncCall(t, tt.head, 50*time.Millisecond, tt.n)
+			if r.n != tt.want.n || (r.err == nil) != (tt.want.err == nil) || (r.err != nil && r.err.Error() != tt.want.err.Error()) {
+				t.Fatalf("result %d err %v, want %d %v", r.n, r.err, tt.want.n, tt.want.err)
+			}
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"strings"
 
 	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/cluster/etcd"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/rpc"
-	"github.com/nats-io/nats.go"
 )
 
 type (
@@ -17,24 +17,43 @@ type (
 	Snowflake etcd.Snowflake
 )
 
-func NewMaster(info common.IClusterInfo, endpoints []string) *Master {
+func NewMaster(info common.IClusterInfo, d discovery.Discovery) *Master {
 	master := &etcd.Master{}
-	master.Init(info, endpoints)
+	master.Init(info, d)
 	return (*Master)(master)
 }
 
-func NewService(info *common.ClusterInfo, endpoints []string) *Service {
+func NewService(info *common.ClusterInfo, d discovery.Discovery) *Service {
 	service := &etcd.Service{}
-	service.Init(info, endpoints)
+	service.Init(info, d)
 	return (*Service)(service)
 }
 
 func NewSnowflake(endpoints []string) *Snowflake {
+	return NewSnowflakeDiscovery(discovery.NewEtcd(endpoints))
+}
+
+// 命名空间内分配机器id, 不同命名空间的id可能重复
+func NewSnowflakeNamespace(endpoints []string, conf *common.Namespace) *Snowflake {
+	return NewSnowflakeDiscovery(discovery.NewNamespace(discovery.NewEtcd(endpoints), conf.Name))
+}
+
+func NewSnowflakeDiscovery(d discovery.Discovery) *Snowflake {
 	uuid := &etcd.Snowflake{}
-	uuid.Init(endpoints)
+	if err := uuid.Init(d); err != nil {
+		base.LOG.Fatalln("snowflake init error", err)
+	}
 	return (*Snowflake)(uuid)
 }
 
+// 指定id位布局, 布局需与集群其他节点一致
+func NewSnowflakeLayout(d discovery.Discovery, layout base.SnowflakeLayout) *Snowflake {
+	if err := base.UUID.SetLayout(layout); err != nil {
+		base.LOG.Fatalln("snowflake layout error", err)
+	}
+	return NewSnowflakeDiscovery(d)
+}
+
 func getChannel(clusterInfo common.ClusterInfo) string {
 	return fmt.Sprintf("%s/%s/%d", etcd.ETCD_DIR, clusterInfo.String(), clusterInfo.Id())
 }
@@ -59,32 +78,21 @@ func getRpcCallChannel(head rpc.RpcHead) string {
 	return fmt.Sprintf("%s/%s/call/%d", etcd.ETCD_DIR, strings.ToLower(head.DestServerType.String()), head.ClusterId)
 }
 
-func setupNatsConn(connectString string, appDieChan chan bool, options ...nats.Option) (*nats.Conn, error) {
-	natsOptions := append(
-		options,
-		nats.DisconnectHandler(func(_ *nats.Conn) {
-			base.LOG.Println("disconnected from nats!")
-		}),
-		nats.ReconnectHandler(func(nc *nats.Conn) {
-			base.LOG.Printf("reconnected to nats server %s with address %s in cluster %s!", nc.ConnectedServerId(), nc.ConnectedAddr(), nc.ConnectedUrl())
-		}),
-		nats.ClosedHandler(func(nc *nats.Conn) {
-			err := nc.LastError()
-			if err == nil {
-				base.LOG.Println("nats connection closed with no error.")
-				return
-			}
-
-			base.LOG.Println("nats connection closed. reason: %q", nc.LastError())
-			if appDieChan != nil {
-				appDieChan <- true
-			}
-		}),
-	)
-
-	nc, err := nats.Connect(connectString, natsOptions...)
-	if err != nil {
-		return nil, err
-	}
-	return nc, nil
+func getStreamChannel(clusterId uint32) string {
+	return fmt.Sprintf("%s/stream/%d", etcd.ETCD_DIR, clusterId)
+}
+
+// 节点管理命令subject
+func AdminChannel(clusterId uint32) string {
+	return fmt.Sprintf("%s/admin/%d", etcd.ETCD_DIR, clusterId)
+}
+
+// 发往其他区服的消息, 由本区服网关转发
+func getFederationOutChannel() string {
+	return fmt.Sprintf("%s/federation/out", etcd.ETCD_DIR)
+}
+
+// 其他区服网关转入的消息
+func getFederationInChannel() string {
+	return fmt.Sprintf("%s/federation/in", etcd.ETCD_DIR)
 }
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"strconv"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/cluster/etcd"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	ELECTION_ELECTED ELECTION_EVENT = iota //本节点当选
+	ELECTION_DEMOTED ELECTION_EVENT = iota //本节点失去leader
+	ELECTION_LEADER  ELECTION_EVENT = iota //leader变化
+)
+
+type (
+	ELECTION_EVENT uint32
+
+	// 选主事件, 投递给创建选举的actor
+	//
+	//	func (b *BossMgr) OnElection(ctx context.Context, event *cluster.ElectionEvent)
+	ElectionEvent struct {
+		Name   string
+		Type   ELECTION_EVENT
+		Leader uint32 //leader的集群id, 0表示没有leader
+	}
+
+	// 集群单例服务的选主, 以本节点集群id参与竞选
+	Election struct {
+		*etcd.Election
+		clusterId uint32
+	}
+)
+
+// 创建选举, funcName为"Actor.Func", 为空时不投递事件
+func (c *Cluster) NewElection(name string, funcName string) *Election {
+	e := &Election{Election: etcd.NewElection(c.discovery, name), clusterId: c.Id()}
+	if funcName != "" {
+		notify := func(eventType ELECTION_EVENT, leader uint32) {
+			actor.MGR.SendMsg(rpc.RpcHead{}, funcName, &ElectionEvent{Name: name, Type: eventType, Leader: leader})
+		}
+		e.OnElected(func() {
+			notify(ELECTION_ELECTED, e.clusterId)
+		})
+		e.OnDemoted(func() {
+			leader, _ := e.LeaderId()
+			notify(ELECTION_DEMOTED, leader)
+		})
+		e.OnObserve(func(val string) {
+			notify(ELECTION_LEADER, parseLeader(val))
+		})
+	}
+	return e
+}
+
+// 参与竞选
+func (e *Election) Campaign() {
+	e.Election.Campaign(strconv.FormatUint(uint64(e.clusterId), 10))
+}
+
+// 当前leader的集群id
+func (e *Election) LeaderId() (uint32, bool) {
+	val, bOk := e.Leader()
+	if !bOk {
+		return 0, false
+	}
+	return parseLeader(val), true
+}
+
+func parseLeader(val string) uint32 {
+	id, _ := strconv.ParseUint(val, 10, 32)
+	return uint32(id)
+}
+
+func (e ELECTION_EVENT) String() string {
+	switch e {
+	case ELECTION_ELECTED:
+		return "elected"
+	case ELECTION_DEMOTED:
+		return "demoted"
+	case ELECTION_LEADER:
+		return "leader"
+	}
+	return "unknown"
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	CLUSTER_JOIN   CLUSTER_EVENT = iota //节点加入
+	CLUSTER_LEAVE  CLUSTER_EVENT = iota //节点离开
+	CLUSTER_UPDATE CLUSTER_EVENT = iota //节点信息变化
+)
+
+type (
+	CLUSTER_EVENT uint32
+
+	// 集群成员变化, 投递给订阅的actor
+	//
+	//	func (g *Gate) OnClusterEvent(ctx context.Context, event *cluster.ClusterEvent)
+	ClusterEvent struct {
+		Type CLUSTER_EVENT
+		Info *common.ClusterInfo
+	}
+
+	clusterWatcher struct {
+		funcName string
+		services []rpc.SERVICE
+	}
+)
+
+// 订阅集群成员变化, funcName为"Actor.Func", services为空时订阅所有服务类型
+// 订阅时会为已有节点补发CLUSTER_JOIN
+func (c *Cluster) WatchCluster(funcName string, services ...rpc.SERVICE) {
+	c.Post(func() {
+		w := &clusterWatcher{funcName: funcName, services: services}
+		c.watcherList = append(c.watcherList, w)
+		for _, info := range c.clusterInfoMap {
+			w.notify(CLUSTER_JOIN, info)
+		}
+	})
+}
+
+// 取消订阅
+func (c *Cluster) UnWatchCluster(funcName string) {
+	c.Post(func() {
+		for i, w := range c.watcherList {
+			if w.funcName == funcName {
+				c.watcherList = append(c.watcherList[:i], c.watcherList[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+// 在集群actor协程调用
+func (c *Cluster) notifyCluster(eventType CLUSTER_EVENT, info *common.ClusterInfo) {
+	for _, w := range c.watcherList {
+		w.notify(eventType, info)
+	}
+}
+
+func (w *clusterWatcher) notify(eventType CLUSTER_EVENT, info *common.ClusterInfo) {
+	if len(w.services) > 0 {
+		bEx := false
+		for _, service := range w.services {
+			if service == info.Type {
+				bEx = true
+				break
+			}
+		}
+		if !bEx {
+			return
+		}
+	}
+	actor.MGR.SendMsg(rpc.RpcHead{}, w.funcName, &ClusterEvent{Type: eventType, Info: info})
+}
+
+func (e CLUSTER_EVENT) String() string {
+	switch e {
+	case CLUSTER_JOIN:
+		return "join"
+	case CLUSTER_LEAVE:
+		return "leave"
+	case CLUSTER_UPDATE:
+		return "update"
+	}
+	return "unknown"
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+type (
+	EventActor struct {
+		actor.Actor
+		eventChan chan *ClusterEvent
+	}
+)
+
+var (
+	eventActor = &EventActor{eventChan: make(chan *ClusterEvent, 16)}
+)
+
+func init() {
+	registerActor(eventActor)
+}
+
+func (a *EventActor) OnClusterEvent(ctx context.Context, event *ClusterEvent) {
+	a.eventChan <- event
+}
+
+func recvEvent(t *testing.T, eventType CLUSTER_EVENT, info *common.ClusterInfo) *ClusterEvent {
+	t.Helper()
+	select {
+	case event := <-eventActor.eventChan:
+		if event.Type != eventType || event.Info.Id() != info.Id() {
+			t.Fatalf("event %s %d, want %s %d", event.Type, event.Info.Id(), eventType, info.Id())
+		}
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatalf("event %s not received", eventType)
+	}
+	return nil
+}
+
+func noEvent(t *testing.T) {
+	t.Helper()
+	select {
+	case event := <-eventActor.eventChan:
+		t.Fatalf("unexpected event %s %d", event.Type, event.Info.Id())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClusterEvent(t *testing.T) {
+	//订阅时为已有节点补发join
+	early := newPeerInfo(rpc.SERVICE_DB)
+	newPeer(t, early, rpc.NODE_READY)
+	MGR.WatchCluster("EventActor.OnClusterEvent", rpc.SERVICE_DB)
+	t.Cleanup(func() { MGR.UnWatchCluster("EventActor.OnClusterEvent") })
+	recvEvent(t, CLUSTER_JOIN, early)
+
+	info := newPeerInfo(rpc.SERVICE_DB)
+	s := newPeer(t, info, rpc.NODE_READY)
+	recvEvent(t, CLUSTER_JOIN, info)
+
+	//状态变化通知update, 只有负载变化不通知
+	s.SetNodeState(rpc.NODE_DRAINING)
+	if event := recvEvent(t, CLUSTER_UPDATE, info); event.Info.State != rpc.NODE_DRAINING {
+		t.Fatalf("update state %v", event.Info.State)
+	}
+	s.SetLoad(10, nil)
+	s.SET(s.Session().Lease())
+	noEvent(t)
+
+	//未订阅的服务类型不通知
+	newPeer(t, newPeerInfo(rpc.SERVICE_ZONE), rpc.NODE_READY)
+	noEvent(t)
+
+	//关闭时先通告stopping再注销
+	s.Close()
+	if event := recvEvent(t, CLUSTER_UPDATE, info); event.Info.State != rpc.NODE_STOPPING {
+		t.Fatalf("close state %v", event.Info.State)
+	}
+	recvEvent(t, CLUSTER_LEAVE, info)
+
+	//取消订阅后不再通知
+	MGR.UnWatchCluster("EventActor.OnClusterEvent")
+	newPeer(t, newPeerInfo(rpc.SERVICE_DB), rpc.NODE_READY)
+	noEvent(t)
+}
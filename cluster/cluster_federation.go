@@ -0,0 +1,288 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	FEDERATION_ELECTION    = "federation"    //每个区服选出一个网关转发
+	FEDERATION_TIME_OUT    = 3 * time.Second //网关转给其他区服网关的确认超时
+	FEDERATION_RETRY       = 3               //发往本区服网关的重试次数
+	FEDERATION_BACKOFF     = time.Second     //重试间隔
+	FEDERATION_PENDING_MAX = 4096            //每个区服排队的消息数, 超过丢弃
+)
+
+var (
+	ErrFederationExist = errors.New("cluster federation already exist")
+	ErrFederationRealm = errors.New("cluster federation realm not found")
+	ErrFederationAllow = errors.New("cluster federation call not allowed")
+	ErrFederationBusy  = errors.New("cluster federation realm busy")
+)
+
+type (
+	// 跨区服网关, 连接其他区服集群的传输层
+	// RpcHead.RealmId指向其他区服的消息先发到本区服网关, 再转给目标区服网关, 按白名单投递到目标区服
+	// 每一跳都用request, 只有leader回包确认, 没有leader时发送方超时重试, 消息至少投递一次
+	// 区服列表在创建时按配置连接, 不监听配置变化, 运行时增删区服用AddRealm/DelRealm
+	// 每个区服一个转发队列, 一个区服不可达不影响其他区服
+	Federation struct {
+		cluster  *Cluster
+		election *Election
+		realmMap map[uint32]transport.Transport
+		queueMap map[uint32]chan federationMsg
+		allowMap map[string]bool
+		locker   *sync.RWMutex
+		closed   bool
+	}
+
+	federationMsg struct {
+		data  []byte
+		reply string
+	}
+)
+
+// 本节点作为跨区服网关, 按配置连接其他区服
+func (c *Cluster) NewFederation(conf *common.Federation) (*Federation, error) {
+	f := &Federation{
+		cluster:  c,
+		realmMap: make(map[uint32]transport.Transport),
+		queueMap: make(map[uint32]chan federationMsg),
+		allowMap: make(map[string]bool),
+		locker:   &sync.RWMutex{},
+	}
+	for _, v := range conf.Allow {
+		f.allowMap[v] = true
+	}
+	for _, realm := range conf.Realms {
+		if realm.Id == c.realmId {
+			continue
+		}
+		t, err := transport.NewNats(realm.Endpoints, nil)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.AddRealm(realm.Id, transport.NewNamespace(t, realm.Namespace))
+	}
+
+	c.realmLocker.Lock()
+	if c.federation != nil {
+		c.realmLocker.Unlock()
+		f.Close()
+		return nil, ErrFederationExist
+	}
+	c.federation = f
+	c.realmLocker.Unlock()
+
+	f.election = c.NewElection(FEDERATION_ELECTION, "")
+	f.election.Campaign()
+	c.transport.Subscribe(getFederationOutChannel(), f.forward)
+	c.transport.Subscribe(getFederationInChannel(), f.receive)
+	return f, nil
+}
+
+// 添加区服, 可替换配置创建的传输层, 已排队的消息改用新传输层发送
+func (f *Federation) AddRealm(realmId uint32, t transport.Transport) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if f.closed {
+		t.Close()
+		return
+	}
+	if old, bEx := f.realmMap[realmId]; bEx {
+		old.Close()
+	}
+	f.realmMap[realmId] = t
+	if _, bEx := f.queueMap[realmId]; !bEx {
+		queue := make(chan federationMsg, FEDERATION_PENDING_MAX)
+		f.queueMap[realmId] = queue
+		go f.runRealm(realmId, queue)
+	}
+}
+
+func (f *Federation) DelRealm(realmId uint32) {
+	f.locker.Lock()
+	if t, bEx := f.realmMap[realmId]; bEx {
+		t.Close()
+		delete(f.realmMap, realmId)
+	}
+	if queue, bEx := f.queueMap[realmId]; bEx {
+		close(queue)
+		delete(f.queueMap, realmId)
+	}
+	f.locker.Unlock()
+}
+
+// 本节点是否为当前转发的网关
+func (f *Federation) IsLeader() bool {
+	return f.election != nil && f.election.IsLeader()
+}
+
+func (f *Federation) Close() {
+	f.locker.Lock()
+	f.closed = true
+	for _, t := range f.realmMap {
+		t.Close()
+	}
+	for _, queue := range f.queueMap {
+		close(queue)
+	}
+	f.realmMap = make(map[uint32]transport.Transport)
+	f.queueMap = make(map[uint32]chan federationMsg)
+	f.locker.Unlock()
+	if f.election != nil {
+		f.election.Close()
+	}
+	f.cluster.realmLocker.Lock()
+	if f.cluster.federation == f {
+		f.cluster.federation = nil
+	}
+	f.cluster.realmLocker.Unlock()
+}
+
+// 回包为空表示已转发, 否则为错误, 区服不存在和白名单外的不重试
+func (f *Federation) reply(reply string, err error) {
+	if reply == "" {
+		return
+	}
+	data := []byte{}
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	f.cluster.transport.Publish(reply, data)
+}
+
+// 本区服发往其他区服, 非leader不回包
+// 放入目标区服的队列后返回, 不在订阅回调里等待其他区服确认
+func (f *Federation) forward(data []byte, reply string) {
+	if !f.IsLeader() {
+		return
+	}
+	_, head := rpc.Unmarshal(data)
+	f.locker.RLock()
+	defer f.locker.RUnlock()
+	if f.closed {
+		return
+	}
+	queue, bEx := f.queueMap[head.RealmId]
+	if !bEx {
+		base.LOG.Printf("federation realm [%d] not found", head.RealmId)
+		f.reply(reply, ErrFederationRealm)
+		return
+	}
+	select {
+	case queue <- federationMsg{data: data, reply: reply}:
+	default: //发送方稍后重试
+		f.reply(reply, ErrFederationBusy)
+	}
+}
+
+// 按顺序转发到目标区服网关, 区服删除或网关关闭时退出
+func (f *Federation) runRealm(realmId uint32, queue chan federationMsg) {
+	for msg := range queue {
+		f.locker.RLock()
+		t, bEx := f.realmMap[realmId]
+		f.locker.RUnlock()
+		if !bEx {
+			continue
+		}
+		resp, err := t.Request(getFederationInChannel(), msg.data, FEDERATION_TIME_OUT)
+		if err == nil && len(resp) > 0 {
+			err = errors.New(string(resp))
+		}
+		if err != nil {
+			base.LOG.Printf("federation realm [%d] forward error %v", realmId, err)
+		}
+		f.reply(msg.reply, err)
+	}
+}
+
+// 其他区服发往本区服, 白名单外的丢弃, 非leader不回包
+func (f *Federation) receive(data []byte, reply string) {
+	if !f.IsLeader() {
+		return
+	}
+	rpcPacket, head, err := rpc.UnmarshalPacket(data)
+	if err != nil {
+		base.LOG.Printf("federation realm [%d] decompress error %v, dropped", head.SrcRealmId, err)
+		f.reply(reply, err)
+		return
+	}
+	if head.RealmId != f.cluster.realmId {
+		f.reply(reply, ErrFederationRealm)
+		return
+	}
+	if !f.isAllow(head.ActorName, rpcPacket.FuncName) {
+		base.LOG.Printf("federation realm [%d] call [%s.%s] not allowed", head.SrcRealmId, head.ActorName, rpcPacket.FuncName)
+		f.reply(reply, ErrFederationAllow)
+		return
+	}
+	//集群id属于来源区服, 在本区服重新路由, 包头一起重新编码
+	head.ClusterId = 0
+	f.cluster.Send(head, rpc.MarshalHead(rpcPacket, &head))
+	f.reply(reply, nil)
+}
+
+// 发往其他区服的消息按区服排队交给本区服网关, 同一区服保持发送顺序
+func (c *Cluster) sendFederation(realmId uint32, data []byte) {
+	c.realmLocker.Lock()
+	queue, bEx := c.federationMap[realmId]
+	if !bEx {
+		queue = make(chan []byte, FEDERATION_PENDING_MAX)
+		c.federationMap[realmId] = queue
+		go c.runFederation(queue)
+	}
+	c.realmLocker.Unlock()
+	select {
+	case queue <- data:
+	default:
+		base.LOG.Printf("federation realm [%d] pending full, message dropped", realmId)
+	}
+}
+
+func (c *Cluster) runFederation(queue chan []byte) {
+	for data := range queue {
+		c.requestFederation(data)
+	}
+}
+
+// 等本区服网关确认, 没有网关或目标区服网关未确认时按次数重试
+// 网关等待其他区服确认的时间不超过FEDERATION_TIME_OUT, 发送方多等一倍避免重复转发
+func (c *Cluster) requestFederation(data []byte) {
+	var err error
+	for i := 0; i < FEDERATION_RETRY; i++ {
+		if i > 0 {
+			time.Sleep(FEDERATION_BACKOFF)
+		}
+		var resp []byte
+		resp, err = c.transport.Request(getFederationOutChannel(), data, 2*FEDERATION_TIME_OUT)
+		if err != nil {
+			continue
+		}
+		if len(resp) == 0 {
+			return
+		}
+		err = errors.New(string(resp))
+		if err.Error() == ErrFederationRealm.Error() || err.Error() == ErrFederationAllow.Error() {
+			break
+		}
+	}
+	_, head := rpc.Unmarshal(data)
+	base.LOG.Printf("federation realm [%d] send [%s] error %v, dropped", head.RealmId, head.ActorName, err)
+}
+
+func (f *Federation) isAllow(actorName string, funcName string) bool {
+	f.locker.RLock()
+	defer f.locker.RUnlock()
+	if f.closed {
+		return false
+	}
+	return f.allowMap["*"] || f.allowMap[actorName] || f.allowMap[actorName+"."+funcName]
+}
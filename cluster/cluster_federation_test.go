@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	testRemoteRealmId = uint32(2)
+)
+
+// 本节点作为网关, 远端区服网关收到的包写入返回的channel并确认
+func newTestFederation(t *testing.T) (*Federation, chan []byte) {
+	t.Helper()
+	f, err := MGR.NewFederation(&common.Federation{Allow: []string{"LocalActor.Ping"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Close)
+	remote := transport.NewLoopback()
+	recvChan := make(chan []byte, 16)
+	remote.Subscribe(getFederationInChannel(), func(data []byte, reply string) {
+		recvChan <- data
+		remote.Publish(reply, []byte{})
+	})
+	f.AddRealm(testRemoteRealmId, remote)
+	waitFor(t, 3*time.Second, f.IsLeader)
+	return f, recvChan
+}
+
+// 请求网关, 返回确认结果, 空为成功
+func requestFederation(t *testing.T, subject string, head rpc.RpcHead, funcName string, params ...interface{}) string {
+	t.Helper()
+	packet := rpc.Marshal(&head, &funcName, params...)
+	resp, err := testTransport.Request(subject, packet.Buff, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp)
+}
+
+func TestFederationOut(t *testing.T) {
+	_, recvChan := newTestFederation(t)
+	head := rpc.RpcHead{RealmId: testRemoteRealmId, DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_BALANCE, ActorName: "LocalActor"}
+	MGR.SendMsg(head, "Ping", 5)
+	select {
+	case data := <-recvChan:
+		_, recvHead := rpc.Unmarshal(data)
+		if recvHead.RealmId != testRemoteRealmId || recvHead.SrcRealmId != testRealmId {
+			t.Fatalf("forward realm %d src %d", recvHead.RealmId, recvHead.SrcRealmId)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not forwarded")
+	}
+
+	//未配置的区服直接拒绝
+	head.RealmId = 9
+	if resp := requestFederation(t, getFederationOutChannel(), head, "Ping", 5); resp != ErrFederationRealm.Error() {
+		t.Fatalf("unknown realm resp %q", resp)
+	}
+}
+
+func TestFederationRealmQueue(t *testing.T) {
+	f, recvChan := newTestFederation(t)
+	//不回包的区服, 网关等待确认直到超时
+	slow := transport.NewLoopback()
+	slow.Subscribe(getFederationInChannel(), func(data []byte, reply string) {})
+	f.AddRealm(3, slow)
+
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_BALANCE, ActorName: "LocalActor"}
+	head.RealmId = 3
+	MGR.SendMsg(head, "Ping", 7)
+	head.RealmId = testRemoteRealmId
+	MGR.SendMsg(head, "Ping", 8)
+	//其他区服不受影响
+	select {
+	case <-recvChan:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by unreachable realm")
+	}
+}
+
+func TestFederationIn(t *testing.T) {
+	newTestFederation(t)
+	tests := []struct {
+		name      string
+		realmId   uint32
+		actorName string
+		funcName  string
+		resp      string
+	}{
+		{"allowed", testRealmId, "LocalActor", "Ping", ""},
+		{"func not allowed", testRealmId, "LocalActor", "Double", ErrFederationAllow.Error()},
+		{"actor not allowed", testRealmId, "AsyncActor", "Double", ErrFederationAllow.Error()},
+		{"other realm", 9, "LocalActor", "Ping", ErrFederationRealm.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head := rpc.RpcHead{RealmId: tt.realmId, SrcRealmId: testRemoteRealmId, DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_BALANCE, ActorName: tt.actorName}
+			if resp := requestFederation(t, getFederationInChannel(), head, tt.funcName, 6); resp != tt.resp {
+				t.Fatalf("resp %q, want %q", resp, tt.resp)
+			}
+			if tt.resp == "" {
+				if n := recvPing(t); n != 6 {
+					t.Fatalf("ping %d", n)
+				}
+			}
+		})
+	}
+}
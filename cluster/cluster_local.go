@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	LOCAL_REPLY = "local/" //本进程call的reply前缀
+)
+
+// 本进程投递, 不经过传输层
+func (c *Cluster) sendLocal(head rpc.RpcHead, packet rpc.Packet) bool {
+	if packet.RpcPacket == nil {
+		rpcPacket, _, err := rpc.UnmarshalPacket(packet.Buff)
+		if err != nil {
+			base.LOG.Printf("rpc [%s] decompress error %v, dropped", rpcPacket.FuncName, err)
+			return false
+		}
+		packet.RpcPacket = rpcPacket
+	}
+	head.SocketId = 0
+	if actor.MGR.SendActor(packet.RpcPacket.FuncName, head, packet) {
+		return true
+	}
+	packet.Reply = head.Reply
+	return c.HandlePacket(packet)
+}
+
+// 包是否由本节点发出, 广播时本地已投递过
+func (c *Cluster) isLocalSrc(packet rpc.Packet) bool {
+	if packet.RpcPacket == nil || packet.RpcPacket.RpcHead == nil {
+		return false
+	}
+	return packet.RpcPacket.RpcHead.SrcClusterId == c.Id()
+}
+
+func (c *Cluster) localCall(head rpc.RpcHead, packet rpc.Packet, timeout time.Duration) ([]byte, error) {
+	reply := fmt.Sprintf("%s%d", LOCAL_REPLY, atomic.AddUint32(&c.callSeed, 1))
+	replyChan := make(chan []byte, 1)
+	c.callLocker.Lock()
+	c.callMap[reply] = replyChan
+	c.callLocker.Unlock()
+	defer func() {
+		c.callLocker.Lock()
+		delete(c.callMap, reply)
+		c.callLocker.Unlock()
+	}()
+
+	head.Reply = reply
+	if !c.sendLocal(head, packet) {
+		return nil, transport.ErrNoResponders
+	}
+
+	select {
+	case data := <-replyChan:
+		return data, nil
+	case <-time.After(timeout):
+		return nil, transport.ErrTimeout
+	}
+}
+
+// 本进程call的回包
+func (c *Cluster) replyLocal(reply string, buff []byte) bool {
+	if !strings.HasPrefix(reply, LOCAL_REPLY) {
+		return false
+	}
+	c.callLocker.RLock()
+	replyChan, bEx := c.callMap[reply]
+	c.callLocker.RUnlock()
+	if bEx {
+		select {
+		case replyChan <- buff:
+		default:
+		}
+	}
+	return true
+}
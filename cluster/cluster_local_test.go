@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/rpc"
+	"github.com/golang/protobuf/proto"
+)
+
+type (
+	LocalActor struct {
+		actor.Actor
+		pingChan chan int
+	}
+)
+
+var (
+	localActor = &LocalActor{pingChan: make(chan int, 16)}
+)
+
+func init() {
+	registerActor(localActor)
+}
+
+func (a *LocalActor) Ping(ctx context.Context, n int) {
+	a.pingChan <- n
+}
+
+func (a *LocalActor) Double(ctx context.Context, n int) int {
+	return n * 2
+}
+
+// 统计经过传输层的消息
+func spySubject(subject string) *int32 {
+	count := new(int32)
+	testTransport.Subscribe(subject, func(data []byte, reply string) {
+		atomic.AddInt32(count, 1)
+	})
+	return count
+}
+
+func recvPing(t *testing.T) int {
+	t.Helper()
+	select {
+	case n := <-localActor.pingChan:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("ping not delivered")
+	}
+	return 0
+}
+
+func TestLocalPoint(t *testing.T) {
+	pointCount := spySubject(getChannel(*MGR.ClusterInfo))
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: MGR.Id(), ActorName: "LocalActor"}
+	MGR.SendMsg(head, "Ping", 1)
+	if n := recvPing(t); n != 1 {
+		t.Fatalf("ping %d", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(pointCount); n != 0 {
+		t.Fatalf("point message published %d", n)
+	}
+}
+
+func TestLocalBroadcast(t *testing.T) {
+	topicCount := spySubject(getTopicChannel(*MGR.ClusterInfo))
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_BOARD_CAST, ActorName: "LocalActor"}
+	MGR.SendMsg(head, "Ping", 2)
+	if n := recvPing(t); n != 2 {
+		t.Fatalf("ping %d", n)
+	}
+	//广播仍发给其他节点, 本节点订阅收到后跳过, 只投递一次
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt32(topicCount) == 1 })
+	select {
+	case n := <-localActor.pingChan:
+		t.Fatalf("broadcast delivered twice %d", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLocalCall(t *testing.T) {
+	callCount := spySubject(getCallChannel(*MGR.ClusterInfo))
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: MGR.Id(), ActorName: "LocalActor"}
+	result := 0
+	err := MGR.CallMsg(func(ctx context.Context, n int) {
+		result = n
+	}, head, "Double", 21)
+	if err != nil || result != 42 {
+		t.Fatalf("call result %d err %v", result, err)
+	}
+	if n := atomic.LoadInt32(callCount); n != 0 {
+		t.Fatalf("call published %d", n)
+	}
+}
+
+// 按传输配置压缩发往传输层的数据, 本地投递不受影响
+func TestSendCompress(t *testing.T) {
+	MGR.compress = 64
+	t.Cleanup(func() { MGR.compress = 0 })
+	peer := newPeerInfo(rpc.SERVICE_GAME)
+	dataChan := make(chan []byte, 1)
+	testTransport.Subscribe(getChannel(*peer), func(data []byte, reply string) {
+		dataChan <- data
+	})
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: peer.Id(), ActorName: "Player"}
+	body := strings.Repeat("a", 1024)
+	MGR.SendMsg(head, "Chat", body)
+	select {
+	case data := <-dataChan:
+		wire := &rpc.RpcPacket{}
+		if err := proto.Unmarshal(data, wire); err != nil || wire.Compress != rpc.COMPRESS_FLATE {
+			t.Fatalf("wire compress %v err %v", wire.Compress, err)
+		}
+		if _, _, err := rpc.UnmarshalPacket(data); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not published")
+	}
+
+	head = rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: MGR.Id(), ActorName: "LocalActor"}
+	MGR.SendMsg(head, "Ping", 3)
+	if n := recvPing(t); n != 3 {
+		t.Fatalf("ping %d", n)
+	}
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/etcd"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+// 以其他节点身份注册mailbox
+func putMailBox(t *testing.T, mailType rpc.MAIL, id int64, clusterId uint32) {
+	t.Helper()
+	key := fmt.Sprintf("%s%s/%d", etcd.MAILBOX_DIR, mailType.String(), id)
+	data, _ := json.Marshal(&rpc.MailBox{Id: id, MailType: mailType, ClusterId: clusterId})
+	if err := testDiscovery.Put(key, string(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testDiscovery.Delete(key) })
+	waitFor(t, time.Second, func() bool {
+		mail := MGR.MailBox.GetMail(mailType, id)
+		return mail != nil && mail.ClusterId == clusterId
+	})
+}
+
+func TestMailBoxRoute(t *testing.T) {
+	putMailBox(t, rpc.MAIL_Player, 7, 101)
+	putMailBox(t, rpc.MAIL_Guild, 7, 102)
+	putMailBox(t, rpc.MAIL_Room, 8, 103)
+	tests := []struct {
+		name      string
+		service   rpc.SERVICE
+		actorName string
+		id        int64
+		clusterId uint32
+	}{
+		{"player", rpc.SERVICE_GAME, "Player", 7, 101},
+		{"guild same id", rpc.SERVICE_GAME, "Guild", 7, 102},
+		{"room", rpc.SERVICE_ZONE, "Room", 8, 103},
+		{"game actor falls back to player", rpc.SERVICE_GAME, "PlayerMgr", 7, 101},
+		{"other service no fallback", rpc.SERVICE_ZONE, "PlayerMgr", 7, 0},
+		{"mailbox not found", rpc.SERVICE_GAME, "Guild", 8, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head := rpc.RpcHead{DestServerType: tt.service, SendType: rpc.SEND_POINT, ActorName: tt.actorName, Id: tt.id}
+			if !MGR.route(&head) || head.ClusterId != tt.clusterId {
+				t.Fatalf("route to %d, want %d", head.ClusterId, tt.clusterId)
+			}
+		})
+	}
+}
+
+func TestMailBoxSend(t *testing.T) {
+	owner := newPeerInfo(rpc.SERVICE_GAME)
+	putMailBox(t, rpc.MAIL_Guild, 9, owner.Id())
+	count := spySubject(getChannel(*owner))
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ActorName: "Guild", Id: 9}
+	MGR.SendMsg(head, "Chat", "hi")
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt32(count) == 1 })
+}
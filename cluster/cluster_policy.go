@@ -0,0 +1,277 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+var (
+	ErrCircuitOpen = errors.New("cluster circuit open")
+	ErrNoCluster   = errors.New("cluster no cluster to call")
+)
+
+type (
+	// 集群call策略, 按目标服务类型配置, 零值即不重试不熔断不对冲
+	CallPolicy struct {
+		Retry       int           //幂等调用的最大重试次数
+		Backoff     time.Duration //重试退避基数, 按次数指数增长
+		Idempotent  []string      //幂等的函数名, "*"表示全部
+		Breaker     int           //同一集群连续失败次数达到后熔断, 0不熔断
+		BreakerTime time.Duration //熔断持续时间, 到期后放行一个探测请求
+		Hedge       time.Duration //幂等调用超过该时间未回包, 向同类型另一个节点补发, 0不对冲
+	}
+
+	breaker struct {
+		failNum   int
+		openUntil time.Time
+		probing   bool
+		locker    sync.Mutex
+	}
+)
+
+func (p *CallPolicy) isIdempotent(funcName string) bool {
+	for _, v := range p.Idempotent {
+		if v == "*" || v == funcName {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CallPolicy) backoff(retry int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	return p.Backoff << uint(retry-1)
+}
+
+// 设置目标服务类型的call策略
+func (c *Cluster) SetCallPolicy(service rpc.SERVICE, policy CallPolicy) {
+	c.policyLocker.Lock()
+	c.policyMap[service] = &policy
+	c.policyLocker.Unlock()
+}
+
+// 按配置设置call策略
+func (c *Cluster) SetCallPolicyConf(confs []common.CallPolicy) error {
+	for _, conf := range confs {
+		service, bEx := rpc.SERVICE_value[strings.ToUpper(conf.Service)]
+		if !bEx {
+			return fmt.Errorf("call policy service [%s] not found", conf.Service)
+		}
+		c.SetCallPolicy(rpc.SERVICE(service), CallPolicy{
+			Retry:       conf.Retry,
+			Backoff:     time.Duration(conf.Backoff) * time.Millisecond,
+			Idempotent:  conf.Idempotent,
+			Breaker:     conf.Breaker,
+			BreakerTime: time.Duration(conf.BreakerTime) * time.Millisecond,
+			Hedge:       time.Duration(conf.Hedge) * time.Millisecond,
+		})
+	}
+	return nil
+}
+
+func (c *Cluster) getCallPolicy(service rpc.SERVICE) *CallPolicy {
+	c.policyLocker.RLock()
+	defer c.policyLocker.RUnlock()
+	policy, bEx := c.policyMap[service]
+	if bEx {
+		return policy
+	}
+	return &CallPolicy{}
+}
+
+func (c *Cluster) getBreaker(clusterId uint32) *breaker {
+	c.policyLocker.Lock()
+	defer c.policyLocker.Unlock()
+	b, bEx := c.breakerMap[clusterId]
+	if !bEx {
+		b = &breaker{}
+		c.breakerMap[clusterId] = b
+	}
+	return b
+}
+
+func (c *Cluster) delBreaker(clusterId uint32) {
+	c.policyLocker.Lock()
+	delete(c.breakerMap, clusterId)
+	c.policyLocker.Unlock()
+}
+
+// 返回是否放行, probe为true表示本次是半开的探测请求
+func (b *breaker) allow(policy *CallPolicy) (bOk bool, probe bool) {
+	if policy.Breaker <= 0 {
+		return true, false
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.failNum < policy.Breaker {
+		return true, false
+	}
+	//半开, 只放行一个探测
+	if time.Now().After(b.openUntil) && !b.probing {
+		b.probing = true
+		return true, true
+	}
+	return false, false
+}
+
+// 熔断中, 半开且探测未结束也视为熔断
+func (b *breaker) isOpen(policy *CallPolicy) bool {
+	if policy.Breaker <= 0 {
+		return false
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.failNum >= policy.Breaker && (time.Now().Before(b.openUntil) || b.probing)
+}
+
+func (b *breaker) done(policy *CallPolicy, err error, probe bool) {
+	if policy.Breaker <= 0 {
+		return
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	//熔断前发出的请求结束不影响探测
+	if probe {
+		b.probing = false
+	}
+	if !isRetryable(err) {
+		b.failNum = 0
+		return
+	}
+	b.failNum++
+	if b.failNum >= policy.Breaker {
+		b.openUntil = time.Now().Add(policy.BreakerTime)
+	}
+}
+
+// 超时和无响应可以重试, 业务错误不重试
+func isRetryable(err error) bool {
+	return err == transport.ErrTimeout || err == transport.ErrNoResponders
+}
+
+// 按策略发送call请求, 每次重试前重新查找目标集群
+// 只有SEND_BALANCE可以改投同类型的其他节点和对冲, SEND_POINT只重试当前持有者
+func (c *Cluster) request(head rpc.RpcHead, packet rpc.Packet, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = CALL_TIME_OUT
+	}
+	policy := c.getCallPolicy(head.DestServerType)
+	idempotent := packet.RpcPacket != nil && policy.isIdempotent(packet.RpcPacket.FuncName)
+	balance := head.SendType == rpc.SEND_BALANCE
+	attempts := 1
+	if idempotent {
+		attempts += policy.Retry
+	}
+
+	var data []byte
+	var err error
+	failId := uint32(0)
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(policy.backoff(i))
+		}
+		target := head
+		if err = c.callTarget(&target); err != nil {
+			break
+		}
+		//按Id粘滞的balance会再次选中失败或熔断的节点, 改投其他节点
+		if balance && (target.ClusterId == failId || c.getBreaker(target.ClusterId).isOpen(policy)) {
+			if clusterId := c.otherCluster(target, policy); clusterId != 0 {
+				target.ClusterId = clusterId
+			}
+		}
+		if balance && idempotent && policy.Hedge > 0 {
+			data, err = c.hedgeRequest(target, packet, timeout, policy)
+		} else {
+			data, err = c.requestOnce(target, packet, timeout, policy)
+		}
+		if err == nil || !isRetryable(err) {
+			break
+		}
+		failId = target.ClusterId
+	}
+	return data, err
+}
+
+func (c *Cluster) requestOnce(head rpc.RpcHead, packet rpc.Packet, timeout time.Duration, policy *CallPolicy) ([]byte, error) {
+	b := c.getBreaker(head.ClusterId)
+	bOk, probe := b.allow(policy)
+	if !bOk {
+		return nil, ErrCircuitOpen
+	}
+
+	var data []byte
+	var err error
+	if head.ClusterId == c.Id() {
+		data, err = c.localCall(head, packet, timeout)
+	} else {
+		data, err = c.transport.Request(getRpcCallChannel(head), c.wire(packet), timeout)
+	}
+	b.done(policy, err, probe)
+	return data, err
+}
+
+// 对冲请求, 先回包的结果生效
+func (c *Cluster) hedgeRequest(head rpc.RpcHead, packet rpc.Packet, timeout time.Duration, policy *CallPolicy) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	resultChan := make(chan result, 2)
+	do := func(head rpc.RpcHead) {
+		data, err := c.requestOnce(head, packet, timeout, policy)
+		resultChan <- result{data, err}
+	}
+
+	go do(head)
+	select {
+	case r := <-resultChan:
+		return r.data, r.err
+	case <-time.After(policy.Hedge):
+	}
+
+	pending := 1
+	if clusterId := c.otherCluster(head, policy); clusterId != 0 {
+		hedge := head
+		hedge.ClusterId = clusterId
+		go do(hedge)
+		pending++
+	}
+
+	var r result
+	for ; pending > 0; pending-- {
+		r = <-resultChan
+		if r.err == nil {
+			break
+		}
+	}
+	return r.data, r.err
+}
+
+// 同类型中另一个ready且未熔断的节点
+func (c *Cluster) otherCluster(head rpc.RpcHead, policy *CallPolicy) uint32 {
+	c.clusterLocker[head.DestServerType].RLock()
+	clusterIds := make([]uint32, 0, len(c.clusterMap[head.DestServerType]))
+	for clusterId, info := range c.clusterMap[head.DestServerType] {
+		if clusterId != head.ClusterId && info.State == rpc.NODE_READY {
+			clusterIds = append(clusterIds, clusterId)
+		}
+	}
+	c.clusterLocker[head.DestServerType].RUnlock()
+
+	for _, clusterId := range clusterIds {
+		if !c.getBreaker(clusterId).isOpen(policy) {
+			return clusterId
+		}
+	}
+	return 0
+}
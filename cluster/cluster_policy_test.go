@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+type (
+	// 直接订阅call subject的外部节点, 回包内容为集群id
+	callPeer struct {
+		info  *common.ClusterInfo
+		calls int32
+		fail  int32 //前fail次请求不回包
+		delay time.Duration
+	}
+)
+
+func newCallPeer(t *testing.T, service rpc.SERVICE, fail int32, delay time.Duration) *callPeer {
+	t.Helper()
+	p := &callPeer{info: newPeerInfo(service), fail: fail, delay: delay}
+	testTransport.Subscribe(getCallChannel(*p.info), func(data []byte, reply string) {
+		if n := atomic.AddInt32(&p.calls, 1); n <= atomic.LoadInt32(&p.fail) {
+			return
+		}
+		time.Sleep(p.delay)
+		testTransport.Publish(reply, []byte(strconv.Itoa(int(p.info.Id()))))
+	})
+	newPeer(t, p.info, rpc.NODE_READY)
+	return p
+}
+
+func (p *callPeer) Calls() int32 {
+	return atomic.LoadInt32(&p.calls)
+}
+
+func setCallPolicy(t *testing.T, service rpc.SERVICE, policy CallPolicy) {
+	MGR.SetCallPolicy(service, policy)
+	t.Cleanup(func() { MGR.SetCallPolicy(service, CallPolicy{}) })
+}
+
+func callPeerId(t *testing.T, head rpc.RpcHead, funcName string, timeout time.Duration) (uint32, error) {
+	t.Helper()
+	head, packet := MGR.prepareCall(head, funcName)
+	data, err := MGR.request(head, packet, timeout)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := strconv.Atoi(string(data))
+	return uint32(id), nil
+}
+
+// 按Id粘滞到指定节点的head
+func stickyHead(t *testing.T, service rpc.SERVICE, clusterId uint32) rpc.RpcHead {
+	t.Helper()
+	for id := int64(1); id < 10000; id++ {
+		head := rpc.RpcHead{DestServerType: service, SendType: rpc.SEND_BALANCE, Id: id}
+		target := head
+		if MGR.balance(&target) && target.ClusterId == clusterId {
+			return head
+		}
+	}
+	t.Fatal("no sticky id")
+	return rpc.RpcHead{}
+}
+
+func TestCallRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		idempotent []string
+		calls      int32
+		err        error
+	}{
+		{"idempotent", []string{"*"}, 3, nil},
+		{"not idempotent", []string{"Other"}, 1, transport.ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newCallPeer(t, rpc.SERVICE_ZONE, 2, 0)
+			setCallPolicy(t, rpc.SERVICE_ZONE, CallPolicy{Retry: 2, Backoff: time.Millisecond, Idempotent: tt.idempotent})
+			head := rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_POINT, ClusterId: p.info.Id()}
+			id, err := callPeerId(t, head, "Query", 50*time.Millisecond)
+			if err != tt.err || p.Calls() != tt.calls {
+				t.Fatalf("err %v calls %d, want %v %d", err, p.Calls(), tt.err, tt.calls)
+			}
+			if err == nil && id != p.info.Id() {
+				t.Fatalf("reply from %d", id)
+			}
+		})
+	}
+}
+
+func TestCallBreaker(t *testing.T) {
+	p := newCallPeer(t, rpc.SERVICE_ZONE, 1<<30, 0)
+	setCallPolicy(t, rpc.SERVICE_ZONE, CallPolicy{Breaker: 2, BreakerTime: 100 * time.Millisecond})
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_ZONE, SendType: rpc.SEND_POINT, ClusterId: p.info.Id()}
+	for i := 0; i < 2; i++ {
+		if _, err := callPeerId(t, head, "Query", 20*time.Millisecond); err != transport.ErrTimeout {
+			t.Fatalf("call %d err %v", i, err)
+		}
+	}
+	//熔断期间不发请求
+	if _, err := callPeerId(t, head, "Query", 20*time.Millisecond); err != ErrCircuitOpen || p.Calls() != 2 {
+		t.Fatalf("err %v calls %d", err, p.Calls())
+	}
+	//到期后放行一个探测, 探测成功后恢复
+	atomic.StoreInt32(&p.fail, 0)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := callPeerId(t, head, "Query", 20*time.Millisecond); err != nil || p.Calls() != 3 {
+		t.Fatalf("probe err %v calls %d", err, p.Calls())
+	}
+	if _, err := callPeerId(t, head, "Query", 20*time.Millisecond); err != nil {
+		t.Fatalf("after probe %v", err)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	policy := &CallPolicy{Breaker: 1, BreakerTime: 50 * time.Millisecond}
+	b := &breaker{failNum: 1, openUntil: time.Now().Add(-time.Millisecond)}
+	bOk, probe := b.allow(policy)
+	if !bOk || !probe {
+		t.Fatal("half open not probing")
+	}
+	if bOk, _ := b.allow(policy); bOk {
+		t.Fatal("second request allowed while probing")
+	}
+	//熔断前发出的请求结束不放行新请求
+	b.done(policy, transport.ErrTimeout, false)
+	if bOk, _ := b.allow(policy); bOk {
+		t.Fatal("straggler cleared probing")
+	}
+	//探测失败重新熔断
+	b.done(policy, transport.ErrTimeout, true)
+	if bOk, _ := b.allow(policy); bOk || !b.isOpen(policy) {
+		t.Fatal("failed probe not reopened")
+	}
+}
+
+func TestCallStickyBreaker(t *testing.T) {
+	a := newCallPeer(t, rpc.SERVICE_ZONE, 1<<30, 0)
+	b := newCallPeer(t, rpc.SERVICE_ZONE, 0, 0)
+	setCallPolicy(t, rpc.SERVICE_ZONE, CallPolicy{Breaker: 1, BreakerTime: time.Second})
+	head := stickyHead(t, rpc.SERVICE_ZONE, a.info.Id())
+	if _, err := callPeerId(t, head, "Query", 20*time.Millisecond); err != transport.ErrTimeout {
+		t.Fatalf("first call %v", err)
+	}
+	//粘滞节点熔断后改投其他ready节点
+	id, err := callPeerId(t, head, "Query", 20*time.Millisecond)
+	if err != nil || id != b.info.Id() || a.Calls() != 1 {
+		t.Fatalf("reply from %d err %v, calls %d", id, err, a.Calls())
+	}
+}
+
+func TestCallHedge(t *testing.T) {
+	a := newCallPeer(t, rpc.SERVICE_ZONE, 0, 300*time.Millisecond)
+	b := newCallPeer(t, rpc.SERVICE_ZONE, 0, 0)
+	setCallPolicy(t, rpc.SERVICE_ZONE, CallPolicy{Idempotent: []string{"*"}, Hedge: 20 * time.Millisecond})
+	head := stickyHead(t, rpc.SERVICE_ZONE, a.info.Id())
+	start := time.Now()
+	id, err := callPeerId(t, head, "Query", time.Second)
+	if err != nil || id != b.info.Id() {
+		t.Fatalf("reply from %d err %v", id, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("hedge waited %v", elapsed)
+	}
+}
+
+func TestCallPolicyConf(t *testing.T) {
+	if err := MGR.SetCallPolicyConf([]common.CallPolicy{{Service: "unknown"}}); err == nil {
+		t.Fatal("unknown service accepted")
+	}
+	err := MGR.SetCallPolicyConf([]common.CallPolicy{{Service: "db", Retry: 2, Backoff: 10, Breaker: 3, BreakerTime: 1000, Hedge: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { MGR.SetCallPolicy(rpc.SERVICE_DB, CallPolicy{}) })
+	policy := MGR.getCallPolicy(rpc.SERVICE_DB)
+	if policy.Retry != 2 || policy.Backoff != 10*time.Millisecond || policy.Breaker != 3 || policy.BreakerTime != time.Second || policy.Hedge != 50*time.Millisecond {
+		t.Fatalf("policy %+v", policy)
+	}
+}
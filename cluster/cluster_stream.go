@@ -0,0 +1,358 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	STREAM_WINDOW         = 64                        //流控窗口,对端未消费的最大帧数
+	STREAM_TIME_OUT       = 5 * time.Second           //等待额度或数据的默认超时
+	STREAM_HEARTBEAT_TIME = 10 * time.Second          //心跳间隔, 以0额度的credit帧发送
+	STREAM_IDLE_TIME      = 3 * STREAM_HEARTBEAT_TIME //超过未收到对端帧视为对端已断开, 回收流
+)
+
+var (
+	ErrStreamClosed  = errors.New("stream closed")
+	ErrStreamTimeout = errors.New("stream timeout")
+	ErrStreamNoRoute = errors.New("stream no cluster")
+)
+
+type (
+	streamKey struct {
+		id       int64
+		isServer bool
+	}
+
+	// 集群流, 客户端由OpenStream创建, 服务端在handler里由AcceptStream获取
+	// 服务端handler在actor协程执行, 不要在handler里阻塞收发
+	// 客户端用完需要Close, 服务端发送完需要CloseSend或CloseWithError
+	// 两端定时发送心跳, 对端进程退出或未关闭的流在STREAM_IDLE_TIME后回收
+	Stream struct {
+		key        streamKey
+		head       rpc.RpcHead //对端路由
+		cluster    *Cluster
+		recvChan   chan *rpc.RpcPacket
+		recvErr    error
+		recvCount  int32
+		credit     int32
+		creditChan chan bool
+		closeChan  chan bool
+		sendClosed int32
+		recvClosed int32
+		closeOnce  sync.Once
+		recvTime   int64 //最后收到对端帧的时间
+		timeout    int64
+	}
+)
+
+func newStream(c *Cluster, key streamKey, head rpc.RpcHead) *Stream {
+	return &Stream{
+		key:        key,
+		head:       head,
+		cluster:    c,
+		recvChan:   make(chan *rpc.RpcPacket, STREAM_WINDOW+2),
+		credit:     STREAM_WINDOW,
+		creditChan: make(chan bool, 1),
+		closeChan:  make(chan bool),
+		recvTime:   time.Now().UnixNano(),
+		timeout:    int64(STREAM_TIME_OUT),
+	}
+}
+
+func (c *Cluster) assignStreamId() int64 {
+	return int64(c.Id())<<32 | int64(atomic.AddUint32(&c.streamSeed, 1))
+}
+
+func (c *Cluster) addStream(s *Stream) {
+	c.streamLocker.Lock()
+	c.streamMap[s.key] = s
+	c.streamLocker.Unlock()
+}
+
+func (c *Cluster) delStream(key streamKey) {
+	c.streamLocker.Lock()
+	delete(c.streamMap, key)
+	c.streamLocker.Unlock()
+}
+
+func (c *Cluster) runStream() {
+	for range time.Tick(STREAM_HEARTBEAT_TIME) {
+		c.tickStream()
+	}
+}
+
+// 发送心跳并回收空闲的流
+func (c *Cluster) tickStream() {
+	c.streamLocker.RLock()
+	streams := make([]*Stream, 0, len(c.streamMap))
+	for _, s := range c.streamMap {
+		streams = append(streams, s)
+	}
+	c.streamLocker.RUnlock()
+
+	now := time.Now().UnixNano()
+	for _, s := range streams {
+		if now-atomic.LoadInt64(&s.recvTime) > int64(STREAM_IDLE_TIME) {
+			base.LOG.Printf("stream [%d] idle timeout, closed", s.key.id)
+			s.Close()
+			continue
+		}
+		s.publish(rpc.FRAME_CREDIT, int32(0))
+	}
+}
+
+func (c *Cluster) getStream(key streamKey) *Stream {
+	c.streamLocker.RLock()
+	s, bEx := c.streamMap[key]
+	c.streamLocker.RUnlock()
+	if bEx {
+		return s
+	}
+	return nil
+}
+
+// 建立流, 路由规则同CallMsg(SEND_POINT/SEND_BALANCE), funcName为服务端handler
+func (c *Cluster) OpenStream(head rpc.RpcHead, funcName string, params ...interface{}) (*Stream, error) {
+	head.SrcClusterId = c.Id()
+	if head.SendType == rpc.SEND_BALANCE {
+		c.balance(&head)
+	} else {
+		c.route(&head)
+	}
+	head.SendType = rpc.SEND_POINT
+	if head.ClusterId == 0 {
+		return nil, ErrStreamNoRoute
+	}
+
+	head.StreamId = c.assignStreamId()
+	head.Frame = rpc.FRAME_OPEN
+	head.ToServer = true
+	s := newStream(c, streamKey{id: head.StreamId}, head)
+	c.addStream(s)
+	packet := rpc.Marshal(&head, &funcName, params...)
+	if err := c.transport.Publish(getStreamChannel(head.ClusterId), c.wire(packet)); err != nil {
+		c.delStream(s.key)
+		return nil, err
+	}
+	return s, nil
+}
+
+// 服务端handler通过ctx获取流
+// handler运行在actor协程, Send/Recv阻塞期间该actor不处理其他消息, 持续收发的流应另起协程处理
+func (c *Cluster) AcceptStream(ctx context.Context) *Stream {
+	head, bOk := rpc.FromContext(ctx)
+	if !bOk || head.StreamId == 0 {
+		return nil
+	}
+	return c.getStream(streamKey{id: head.StreamId, isServer: true})
+}
+
+func (c *Cluster) handleStream(buff []byte) {
+	rpcPacket, head, err := rpc.UnmarshalPacket(buff)
+	if err != nil {
+		base.LOG.Printf("stream [%d] decompress error %v, dropped", head.StreamId, err)
+		return
+	}
+	key := streamKey{id: head.StreamId, isServer: head.ToServer}
+	if head.Frame == rpc.FRAME_OPEN {
+		if !head.ToServer || c.getStream(key) != nil {
+			return
+		}
+		peer := rpc.RpcHead{Id: head.Id, ClusterId: head.SrcClusterId, ActorName: head.ActorName, StreamId: head.StreamId, Metadata: head.Metadata}
+		s := newStream(c, key, peer)
+		c.addStream(s)
+		if !c.HandlePacket(rpc.Packet{Buff: buff, RpcPacket: rpcPacket}) {
+			s.CloseWithError(fmt.Errorf("stream [%s] has no method", rpcPacket.FuncName))
+		}
+		return
+	}
+
+	s := c.getStream(key)
+	if s == nil {
+		return
+	}
+	s.onFrame(rpcPacket)
+}
+
+func (s *Stream) Id() int64 {
+	return s.key.id
+}
+
+// 设置Send等待额度和Recv等待数据的超时, 0为一直等待到流关闭
+func (s *Stream) SetTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&s.timeout, int64(timeout))
+}
+
+func (s *Stream) timeoutChan() <-chan time.Time {
+	if timeout := time.Duration(atomic.LoadInt64(&s.timeout)); timeout > 0 {
+		return time.After(timeout)
+	}
+	return nil
+}
+
+func (s *Stream) onFrame(rpcPacket *rpc.RpcPacket) {
+	atomic.StoreInt64(&s.recvTime, time.Now().UnixNano())
+	switch rpcPacket.RpcHead.Frame {
+	case rpc.FRAME_CREDIT:
+		credit := int32(0)
+		rpc.UnmarshalStream(rpcPacket, &credit)
+		if credit == 0 { //心跳
+			return
+		}
+		atomic.AddInt32(&s.credit, credit)
+		select {
+		case s.creditChan <- true:
+		default:
+		}
+	default:
+		select {
+		case s.recvChan <- rpcPacket:
+		default: //对端没有遵守流控
+			base.LOG.Printf("stream [%d] recv overflow", s.key.id)
+			s.Close()
+			return
+		}
+		//对端结束后, 本端发送也结束即可释放, 缓存帧仍可Recv
+		if rpcPacket.RpcHead.Frame == rpc.FRAME_EOS || rpcPacket.RpcHead.Frame == rpc.FRAME_ERR {
+			atomic.StoreInt32(&s.recvClosed, 1)
+			s.tryRelease()
+		}
+	}
+}
+
+func (s *Stream) publish(frame rpc.FRAME, params ...interface{}) error {
+	head := s.head
+	head.SrcClusterId = s.cluster.Id()
+	head.SendType = rpc.SEND_POINT
+	head.StreamId = s.key.id
+	head.Frame = frame
+	head.ToServer = !s.key.isServer
+	funcName := ""
+	packet := rpc.Marshal(&head, &funcName, params...)
+	return s.cluster.transport.Publish(getStreamChannel(head.ClusterId), s.cluster.wire(packet))
+}
+
+// 发送一帧数据, 额度不足时阻塞等待
+func (s *Stream) Send(params ...interface{}) error {
+	if atomic.LoadInt32(&s.sendClosed) == 1 {
+		return ErrStreamClosed
+	}
+	timeoutChan := s.timeoutChan()
+	for atomic.LoadInt32(&s.credit) <= 0 {
+		select {
+		case <-s.creditChan:
+		case <-s.closeChan:
+			return ErrStreamClosed
+		case <-timeoutChan:
+			return ErrStreamTimeout
+		}
+	}
+	atomic.AddInt32(&s.credit, -1)
+	return s.publish(rpc.FRAME_DATA, params...)
+}
+
+// 接收一帧数据到params指针, 对端结束返回io.EOF
+func (s *Stream) Recv(params ...interface{}) error {
+	if s.recvErr != nil {
+		return s.recvErr
+	}
+
+	var rpcPacket *rpc.RpcPacket
+	select { //优先取完缓存帧
+	case rpcPacket = <-s.recvChan:
+	default:
+		select {
+		case rpcPacket = <-s.recvChan:
+		case <-s.closeChan:
+			s.recvErr = ErrStreamClosed
+			return s.recvErr
+		case <-s.timeoutChan():
+			return ErrStreamTimeout
+		}
+	}
+
+	switch rpcPacket.RpcHead.Frame {
+	case rpc.FRAME_EOS:
+		s.recvErr = io.EOF
+		return s.recvErr
+	case rpc.FRAME_ERR:
+		strErr := ""
+		rpc.UnmarshalStream(rpcPacket, &strErr)
+		s.recvErr = errors.New(strErr)
+		return s.recvErr
+	}
+
+	//消费过半窗口后归还额度
+	if n := atomic.AddInt32(&s.recvCount, 1); n >= STREAM_WINDOW/2 {
+		atomic.AddInt32(&s.recvCount, -n)
+		s.publish(rpc.FRAME_CREDIT, n)
+	}
+	return rpc.UnmarshalStream(rpcPacket, params...)
+}
+
+// 结束本端发送
+func (s *Stream) CloseSend() error {
+	if !atomic.CompareAndSwapInt32(&s.sendClosed, 0, 1) {
+		return nil
+	}
+	err := s.publish(rpc.FRAME_EOS)
+	s.tryRelease()
+	return err
+}
+
+// 以错误结束本端发送, 对端Recv返回该错误
+func (s *Stream) CloseWithError(err error) error {
+	if !atomic.CompareAndSwapInt32(&s.sendClosed, 0, 1) {
+		return nil
+	}
+	err = s.publish(rpc.FRAME_ERR, err.Error())
+	s.tryRelease()
+	return err
+}
+
+// 关闭流, 本端未结束发送时通知对端取消
+func (s *Stream) Close() {
+	s.CloseWithError(ErrStreamClosed)
+	atomic.StoreInt32(&s.recvClosed, 1)
+	s.release()
+}
+
+func (s *Stream) tryRelease() {
+	if atomic.LoadInt32(&s.sendClosed) == 1 && atomic.LoadInt32(&s.recvClosed) == 1 {
+		s.release()
+	}
+}
+
+func (s *Stream) release() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+		s.cluster.delStream(s.key)
+	})
+}
+
+// 把流转换成channel, 结束后关闭channel, 结束原因通过errChan返回(io.EOF为正常结束)
+func StreamChan[T any](s *Stream) (<-chan T, <-chan error) {
+	ch := make(chan T)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		for {
+			var val T
+			if err := s.Recv(&val); err != nil {
+				errChan <- err
+				return
+			}
+			ch <- val
+		}
+	}()
+	return ch, errChan
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+type (
+	StreamActor struct {
+		actor.Actor
+		acceptChan chan *Stream
+	}
+)
+
+var (
+	streamActor = &StreamActor{acceptChan: make(chan *Stream, 1)}
+)
+
+func init() {
+	registerActor(streamActor)
+}
+
+// 交给用例处理
+func (a *StreamActor) Accept(ctx context.Context) {
+	a.acceptChan <- MGR.AcceptStream(ctx)
+}
+
+// 发送n帧后结束, errStr不为空时以错误结束
+func (a *StreamActor) Produce(ctx context.Context, n int, errStr string) {
+	s := MGR.AcceptStream(ctx)
+	go func() {
+		for i := 0; i < n; i++ {
+			s.Send(i)
+		}
+		if errStr != "" {
+			s.CloseWithError(streamError(errStr))
+		} else {
+			s.CloseSend()
+		}
+	}()
+}
+
+type streamError string
+
+func (e streamError) Error() string {
+	return string(e)
+}
+
+func openStream(t *testing.T, funcName string, params ...interface{}) *Stream {
+	t.Helper()
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME, SendType: rpc.SEND_POINT, ClusterId: MGR.Id(), ActorName: "StreamActor"}
+	s, err := MGR.OpenStream(head, funcName, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func acceptStream(t *testing.T) *Stream {
+	t.Helper()
+	select {
+	case s := <-streamActor.acceptChan:
+		t.Cleanup(s.Close)
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("stream not accepted")
+	}
+	return nil
+}
+
+func streamClosed(s *Stream) bool {
+	select {
+	case <-s.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestStreamCredit(t *testing.T) {
+	client := openStream(t, "Accept")
+	server := acceptStream(t)
+	client.SetTimeout(50 * time.Millisecond)
+
+	//对端不消费时最多发送一个窗口
+	for i := 0; i < STREAM_WINDOW; i++ {
+		if err := client.Send(i); err != nil {
+			t.Fatalf("send %d %v", i, err)
+		}
+	}
+	if err := client.Send(STREAM_WINDOW); err != ErrStreamTimeout {
+		t.Fatalf("send without credit %v", err)
+	}
+
+	//消费过半窗口后归还额度
+	for i := 0; i < STREAM_WINDOW/2; i++ {
+		val := 0
+		if err := server.Recv(&val); err != nil || val != i {
+			t.Fatalf("recv %d %v", val, err)
+		}
+	}
+	client.SetTimeout(time.Second)
+	for i := 0; i < STREAM_WINDOW/2; i++ {
+		if err := client.Send(i); err != nil {
+			t.Fatalf("send after credit %v", err)
+		}
+	}
+}
+
+func TestStreamEnd(t *testing.T) {
+	tests := []struct {
+		name   string
+		errStr string
+		err    string
+	}{
+		{"eos", "", io.EOF.Error()},
+		{"err", "produce failed", "produce failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := openStream(t, "Produce", 3, tt.errStr)
+			client.SetTimeout(time.Second)
+			for i := 0; i < 3; i++ {
+				val := -1
+				if err := client.Recv(&val); err != nil || val != i {
+					t.Fatalf("recv %d %v", val, err)
+				}
+			}
+			err := client.Recv()
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("end %v, want %s", err, tt.err)
+			}
+			//再次Recv返回相同结果
+			if err2 := client.Recv(); err2 != err {
+				t.Fatalf("recv after end %v", err2)
+			}
+		})
+	}
+}
+
+func TestStreamRecvOverflow(t *testing.T) {
+	client := openStream(t, "Accept")
+	server := acceptStream(t)
+	client.SetTimeout(time.Second)
+
+	//客户端不遵守流控, 服务端缓存满后关闭流并通知客户端
+	atomic.StoreInt32(&client.credit, STREAM_WINDOW*2)
+	for i := 0; i < STREAM_WINDOW+3; i++ {
+		client.Send(i)
+	}
+	waitFor(t, time.Second, func() bool { return streamClosed(server) })
+	if MGR.getStream(server.key) != nil {
+		t.Fatal("overflow stream not released")
+	}
+	if err := client.Recv(); err == nil || err.Error() != ErrStreamClosed.Error() {
+		t.Fatalf("client recv %v", err)
+	}
+}
+
+func TestStreamIdle(t *testing.T) {
+	client := openStream(t, "Accept")
+	server := acceptStream(t)
+	client.SetTimeout(time.Second)
+
+	//心跳刷新对端接收时间
+	last := time.Now().Add(-time.Second).UnixNano()
+	atomic.StoreInt64(&client.recvTime, last)
+	MGR.tickStream()
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&client.recvTime) > last })
+	if streamClosed(client) || streamClosed(server) {
+		t.Fatal("heartbeat closed stream")
+	}
+
+	//超过STREAM_IDLE_TIME未收到对端帧时回收
+	atomic.StoreInt64(&server.recvTime, time.Now().Add(-STREAM_IDLE_TIME-time.Second).UnixNano())
+	MGR.tickStream()
+	if !streamClosed(server) || MGR.getStream(server.key) != nil {
+		t.Fatal("idle stream not reaped")
+	}
+	if err := client.Recv(); err == nil || err.Error() != ErrStreamClosed.Error() {
+		t.Fatalf("client recv %v", err)
+	}
+}
@@ -7,78 +7,264 @@ import (
 
 	"github.com/fengqk/mars-base/actor"
 	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/cluster/etcd"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/rpc"
 )
 
 const (
-	fsm_idle    fsm_type = iota //空闲
-	fsm_publish fsm_type = iota //注册
-	fsm_lease   fsm_type = iota //TTL
+	STUB_HANDOFF_TIME = time.Second     //计划迁移时注销通知到释放stub的间隔, 供actor落地数据
+	STUB_PENDING_MAX  = 4096            //stub无持有者时每种stub缓存的消息数
+	STUB_PENDING_TIME = 5 * time.Second //缓存消息等待持有者的最长时间
 )
 
 type (
-	fsm_type uint32
-
+	// stub归属由etcd事件驱动, 空闲stub按确定性规则分配给未持有stub的节点
+	// 持有者租约过期时其他节点立即接管
 	Stub struct {
-		fsm         fsm_type
 		StubMailBox common.StubMailBox
 		isRegister  int32
+		leaving     int32
+		seen        bool
+		joinLease   discovery.LeaseID
+		session     *discovery.Session
+		updateChan  chan bool
+	}
+
+	stubPacket struct {
+		head   rpc.RpcHead
+		packet rpc.Packet
+		expire time.Time
 	}
 )
 
 func (s *Stub) InitStub(stub rpc.STUB) {
 	s.StubMailBox.StubType = stub
 	s.StubMailBox.ClusterId = MGR.Id()
-	go s.updateFsm()
+	s.updateChan = make(chan bool, 1)
+	s.session = discovery.NewSession(MGR.StubMailBox.Discovery(), etcd.STUB_TTL_TIME)
+	s.session.OnGrant(func(discovery.LeaseID) {
+		s.wake()
+	})
+	s.session.OnLost(s.wake)
+	MGR.StubMailBox.OnChange(func(stubType rpc.STUB) {
+		if stubType == stub {
+			s.wake()
+		}
+	})
+	MGR.addStub(s)
+	go s.run()
 }
 
 func (s *Stub) IsRegister() bool {
 	return atomic.LoadInt32(&s.isRegister) == 1
 }
 
-func (s *Stub) updateFsm() {
-	for {
-		switch s.fsm {
-		case fsm_idle:
-			s.idle()
-		case fsm_publish:
-			s.publish()
-		case fsm_lease:
-			s.lease()
-		}
-		time.Sleep(100 * time.Millisecond)
+// 计划内迁移, 释放持有的stub并不再参与分配
+func (s *Stub) Handoff() {
+	atomic.StoreInt32(&s.leaving, 1)
+	s.wake()
+}
+
+// 重新参与分配
+func (s *Stub) Resume() {
+	atomic.StoreInt32(&s.leaving, 0)
+	s.wake()
+}
+
+func (s *Stub) wake() {
+	select {
+	case s.updateChan <- true:
+	default:
 	}
 }
 
-func (s *Stub) idle() {
-	if !MGR.IsEnoughStub(s.StubMailBox.StubType) {
-		s.fsm = fsm_publish
+func (s *Stub) run() {
+	for range s.updateChan {
+		s.update()
 	}
 }
 
-func (s *Stub) publish() {
-	s.StubMailBox.Id = (s.StubMailBox.Id + 1) % MGR.Stub.StubCount[s.StubMailBox.StubType.String()]
+func (s *Stub) update() {
+	leaseId := s.session.Lease()
+	if atomic.LoadInt32(&s.leaving) == 1 {
+		if s.joinLease != 0 {
+			MGR.StubMailBox.Leave(s.StubMailBox.StubType, s.StubMailBox.ClusterId)
+			s.joinLease = 0
+		}
+		if s.IsRegister() {
+			s.release()
+		}
+		return
+	}
+
+	stubCount := MGR.StubCount(s.StubMailBox.StubType)
+	if s.IsRegister() && s.StubMailBox.Id >= stubCount {
+		//stub数量减少, 超出的id不再有消息路由过来
+		s.release()
+	}
+
+	if s.IsRegister() {
+		pStub := MGR.StubMailBox.Get(s.StubMailBox.StubType, s.StubMailBox.Id)
+		bOk := pStub != nil && pStub.ClusterId == s.StubMailBox.ClusterId && pStub.LeaseId == s.StubMailBox.LeaseId
+		if bOk {
+			s.seen = true
+		}
+		if discovery.LeaseID(s.StubMailBox.LeaseId) == leaseId && (bOk || !s.seen) {
+			return
+		}
+		s.unregister()
+	}
+
+	if leaseId == 0 {
+		return
+	}
+	if s.joinLease != leaseId {
+		if err := MGR.StubMailBox.Join(s.StubMailBox.StubType, s.StubMailBox.ClusterId, leaseId); err != nil {
+			time.AfterFunc(discovery.SESSION_BACKOFF, s.wake)
+			return
+		}
+		s.joinLease = leaseId
+	}
+
+	id := MGR.StubMailBox.Assign(s.StubMailBox.StubType, stubCount, s.StubMailBox.ClusterId)
+	if id < 0 {
+		return
+	}
+	s.StubMailBox.Id = id
+	s.StubMailBox.LeaseId = int64(leaseId)
 	if MGR.StubMailBox.Create(&s.StubMailBox) {
-		s.fsm = fsm_lease
-		atomic.StoreInt32(&s.isRegister, 1)
-		actor.MGR.SendMsg(rpc.RpcHead{SendType: rpc.SEND_BOARD_CAST}, fmt.Sprintf("%s.OnStubRegister", s.StubMailBox.StubType.String()))
-		base.LOG.Printf("stub [%s]注册成功[%d]", s.StubMailBox.StubType.String(), s.StubMailBox.Id)
-		time.Sleep(etcd.STUB_TTL_TIME / 3)
-	} else if MGR.IsEnoughStub(s.StubMailBox.StubType) {
-		s.fsm = fsm_idle
-	}
-}
-
-func (s *Stub) lease() {
-	err := MGR.StubMailBox.Lease(&s.StubMailBox)
-	if err != nil {
-		s.fsm = fsm_idle
-		atomic.StoreInt32(&s.isRegister, 0)
-		actor.MGR.SendMsg(rpc.RpcHead{SendType: rpc.SEND_BOARD_CAST}, fmt.Sprintf("%s.OnStubUnRegister", s.StubMailBox.StubType.String()))
-		base.LOG.Printf("stub [%s]注销成功[%d]", s.StubMailBox.StubType.String(), s.StubMailBox.Id)
+		s.register()
 	} else {
-		time.Sleep(etcd.STUB_TTL_TIME / 3)
+		time.AfterFunc(discovery.SESSION_BACKOFF, s.wake)
+	}
+}
+
+// 注销后延迟释放stub, 供actor处理完已投递的消息, 不阻塞后续分配
+func (s *Stub) release() {
+	info := &common.StubMailBox{}
+	info.StubType, info.Id, info.ClusterId, info.LeaseId = s.StubMailBox.StubType, s.StubMailBox.Id, s.StubMailBox.ClusterId, s.StubMailBox.LeaseId
+	s.unregister()
+	time.AfterFunc(STUB_HANDOFF_TIME, func() {
+		if err := MGR.StubMailBox.Delete(info); err != nil {
+			base.LOG.Printf("stub [%s]释放失败[%d] %v", info.StubType.String(), info.Id, err)
+		}
+	})
+}
+
+func (s *Stub) register() {
+	s.seen = false
+	atomic.StoreInt32(&s.isRegister, 1)
+	actor.MGR.SendMsg(rpc.RpcHead{SendType: rpc.SEND_BOARD_CAST}, fmt.Sprintf("%s.OnStubRegister", s.StubMailBox.StubType.String()))
+	base.LOG.Printf("stub [%s]注册成功[%d]", s.StubMailBox.StubType.String(), s.StubMailBox.Id)
+}
+
+func (s *Stub) unregister() {
+	atomic.StoreInt32(&s.isRegister, 0)
+	actor.MGR.SendMsg(rpc.RpcHead{SendType: rpc.SEND_BOARD_CAST}, fmt.Sprintf("%s.OnStubUnRegister", s.StubMailBox.StubType.String()))
+	base.LOG.Printf("stub [%s]注销成功[%d]", s.StubMailBox.StubType.String(), s.StubMailBox.Id)
+}
+
+func (c *Cluster) addStub(s *Stub) {
+	c.stubLocker.Lock()
+	c.stubList = append(c.stubList, s)
+	c.stubLocker.Unlock()
+}
+
+// 本节点持有的stub全部迁出
+func (c *Cluster) handoffStub() {
+	c.stubLocker.Lock()
+	defer c.stubLocker.Unlock()
+	for _, s := range c.stubList {
+		s.Handoff()
+	}
+}
+
+// stub id到持有者集群id
+func (c *Cluster) StubOwners(stub rpc.STUB) map[int64]uint32 {
+	return c.StubMailBox.Owners(stub)
+}
+
+// stub数量, 运行时设置的优先于配置
+func (c *Cluster) StubCount(stub rpc.STUB) int64 {
+	if c.StubMailBox.Discovery() == nil {
+		return 0
+	}
+	if count, bOk := c.StubMailBox.GetCount(stub); bOk {
+		return count
+	}
+	return c.Stub.StubCount[stub.String()]
+}
+
+// 运行时修改stub数量, 开启JumpHash时只有部分id的消息迁移, 否则按Id取模大部分id都会迁移
+// 新增的stub被空闲节点认领前, 发往它的消息先缓存
+func (c *Cluster) SetStubCount(stub rpc.STUB, count int64) error {
+	return c.StubMailBox.SetCount(stub, count)
+}
+
+// 缓存发往无持有者stub的消息
+func (c *Cluster) pendStub(head rpc.RpcHead, packet rpc.Packet, expire time.Time) {
+	stubType := rpc.STUB(rpc.STUB_value[head.ActorName])
+	c.stubLocker.Lock()
+	defer c.stubLocker.Unlock()
+	if len(c.stubPendingMap[stubType]) >= STUB_PENDING_MAX {
+		base.LOG.Printf("stub [%s]缓存消息已满, 丢弃[%d]", stubType.String(), head.Id)
+		return
+	}
+	//队列从空开始时启动超时检查, 持有者一直不出现也能按时丢弃
+	if len(c.stubPendingMap[stubType]) == 0 {
+		time.AfterFunc(time.Until(expire), func() {
+			c.expireStub(stubType)
+		})
+	}
+	c.stubPendingMap[stubType] = append(c.stubPendingMap[stubType], &stubPacket{head: head, packet: packet, expire: expire})
+}
+
+// 丢弃超时的缓存消息, 还有未超时的按最早的超时时间继续检查
+func (c *Cluster) expireStub(stubType rpc.STUB) {
+	c.stubLocker.Lock()
+	defer c.stubLocker.Unlock()
+	now := time.Now()
+	pendingList := c.stubPendingMap[stubType][:0]
+	var expire time.Time
+	for _, p := range c.stubPendingMap[stubType] {
+		if !now.Before(p.expire) {
+			base.LOG.Printf("stub [%s]缓存消息超时, 丢弃[%d]", stubType.String(), p.head.Id)
+			continue
+		}
+		if expire.IsZero() || p.expire.Before(expire) {
+			expire = p.expire
+		}
+		pendingList = append(pendingList, p)
+	}
+	if len(pendingList) == 0 {
+		delete(c.stubPendingMap, stubType)
+		return
+	}
+	c.stubPendingMap[stubType] = pendingList
+	time.AfterFunc(time.Until(expire), func() {
+		c.expireStub(stubType)
+	})
+}
+
+// stub归属变化时重新路由缓存的消息
+func (c *Cluster) flushStub(stubType rpc.STUB) {
+	c.stubLocker.Lock()
+	pendingList := c.stubPendingMap[stubType]
+	delete(c.stubPendingMap, stubType)
+	c.stubLocker.Unlock()
+
+	now := time.Now()
+	for _, p := range pendingList {
+		if now.After(p.expire) {
+			base.LOG.Printf("stub [%s]缓存消息超时, 丢弃[%d]", stubType.String(), p.head.Id)
+			continue
+		}
+		if c.route(&p.head) {
+			c.sendPoint(p.head, p.packet)
+		} else {
+			c.pendStub(p.head, p.packet, p.expire)
+		}
 	}
 }
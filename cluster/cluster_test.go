@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/cluster/etcd"
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	testAdminToken = "secret"
+	testRealmId    = uint32(1)
+)
+
+var (
+	testTransport = transport.NewLoopback()
+	testDiscovery = discovery.NewMemory()
+	testPort      = int32(32000)
+)
+
+// 进程内只能注册一个Cluster actor, 所有用例共用MGR
+func TestMain(m *testing.M) {
+	MGR.InitCluster(&common.ClusterInfo{Type: rpc.SERVICE_GAME, Ip: "127.0.0.1", Port: 31000}, nil, "",
+		WithTransport(testTransport), WithDiscovery(testDiscovery), WithMailBox(), WithRealm(testRealmId), WithBalanceRing(),
+		WithAdminConf(&common.Admin{Token: testAdminToken, Allow: []string{"LocalActor.Double"}}))
+	MGR.BindPacketFunc(actor.MGR.PacketFunc)
+	//等本节点ready, 用例可以balance到本节点
+	for i := 0; i < 300 && (*etcd.Service)(MGR.Service).NodeState() != rpc.NODE_READY; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	code := m.Run()
+	os.RemoveAll("log")
+	os.Exit(code)
+}
+
+// 注册测试actor, 各用例的actor类型不能重复
+func registerActor(ac actor.IActor) {
+	ac.Init()
+	actor.MGR.RegisterActor(ac)
+	ac.Start()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 外部节点信息, 端口递增保证集群id和订阅不重复
+func newPeerInfo(service rpc.SERVICE) *common.ClusterInfo {
+	return &common.ClusterInfo{Type: service, Ip: "127.0.0.1", Port: atomic.AddInt32(&testPort, 1)}
+}
+
+// 注册一个外部节点, 等待MGR收到其注册信息, 用例结束时注销
+func newPeer(t *testing.T, info *common.ClusterInfo, state rpc.NODE) *etcd.Service {
+	t.Helper()
+	s := &etcd.Service{}
+	s.Init(info, testDiscovery)
+	s.SetNodeState(state)
+	head := rpc.RpcHead{DestServerType: info.Type, ClusterId: info.Id()}
+	t.Cleanup(func() {
+		s.Close()
+		waitFor(t, 3*time.Second, func() bool { return MGR.GetCluster(head) == nil })
+	})
+	waitFor(t, 3*time.Second, func() bool {
+		pInfo := MGR.GetCluster(head)
+		return pInfo != nil && pInfo.State == state
+	})
+	return s
+}
+
+func TestReadyOnSynced(t *testing.T) {
+	//无需额外调用, 缓存同步后本节点即可分配流量
+	head := rpc.RpcHead{DestServerType: rpc.SERVICE_GAME}
+	waitFor(t, 3*time.Second, func() bool {
+		return MGR.RandomCluster(head).ClusterId == MGR.Id()
+	})
+	if state := (*etcd.Service)(MGR.Service).NodeState(); state != rpc.NODE_READY {
+		t.Fatalf("state %v", state)
+	}
+}
@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	EVENT_PUT    EVENT_TYPE = iota //写入
+	EVENT_DELETE EVENT_TYPE = iota //删除
+)
+
+var (
+	ErrLeaseNotFound = errors.New("discovery lease not found")
+	ErrClosed        = errors.New("discovery closed")
+)
+
+type (
+	EVENT_TYPE uint32
+	LeaseID    int64
+
+	KeyValue struct {
+		Key            string
+		Value          []byte
+		Lease          LeaseID
+		CreateRevision int64
+		ModRevision    int64
+	}
+
+	Event struct {
+		Type   EVENT_TYPE
+		Kv     *KeyValue
+		PrevKv *KeyValue
+	}
+
+	// 服务发现后端, 注册/监听/租约/CAS创建
+	Discovery interface {
+		Grant(ttl int64) (LeaseID, error)
+		KeepAliveOnce(id LeaseID) error
+		KeepAlive(ctx context.Context, id LeaseID) (<-chan struct{}, error) //每次续约通知一次, 租约丢失或ctx结束时关闭
+		Revoke(id LeaseID) error
+		Put(key string, val string, lease LeaseID) error
+		Create(key string, val string, lease LeaseID) (bool, error) //key不存在时写入
+		Update(key string, val string, lease LeaseID) (bool, error) //key挂在lease上时写入, 用于持有者更新
+		Get(prefix string) ([]*KeyValue, int64, error)              //按前缀读取, 返回当前revision
+		Delete(key string) error
+		DeleteOwned(key string, lease LeaseID) (bool, error) //key挂在lease上时删除
+		DeletePrefix(prefix string) error
+		Watch(ctx context.Context, prefix string, rev int64) <-chan []*Event //rev为0从当前开始, 出错或压缩时关闭
+		Close() error
+	}
+)
@@ -0,0 +1,149 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// 前缀的本地缓存, 按revision加载后从revision+1开始监听
+	// 监听关闭(压缩/断线)时重新加载, 与缓存对比后补发差异事件
+	// handler在缓存协程顺序执行, 删除事件的PrevKv总是有值
+	Cache struct {
+		discovery Discovery
+		prefix    string
+		handler   func(*Event)
+		kvMap     map[string]*KeyValue
+		revision  int64
+		synced    int32
+		readyChan chan struct{}
+		readyOnce sync.Once
+		ctx       context.Context
+		cancel    context.CancelFunc
+	}
+)
+
+func NewCache(d Discovery, prefix string, handler func(*Event)) *Cache {
+	c := &Cache{
+		discovery: d,
+		prefix:    prefix,
+		handler:   handler,
+		kvMap:     make(map[string]*KeyValue),
+		readyChan: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run()
+	return c
+}
+
+// 缓存与后端一致, 监听中断到重新加载完成期间为false
+func (c *Cache) Synced() bool {
+	return atomic.LoadInt32(&c.synced) == 1
+}
+
+// 首次加载完成时关闭
+func (c *Cache) Ready() <-chan struct{} {
+	return c.readyChan
+}
+
+// 最近处理的revision
+func (c *Cache) Revision() int64 {
+	return atomic.LoadInt64(&c.revision)
+}
+
+func (c *Cache) Close() {
+	c.cancel()
+}
+
+func (c *Cache) run() {
+	backoff := SESSION_BACKOFF
+	for c.ctx.Err() == nil {
+		kvs, rev, err := c.discovery.Get(c.prefix)
+		if err != nil {
+			log.Printf("cache [%s] list error %v, retry in %v", c.prefix, err, backoff)
+			backoff = c.wait(backoff)
+			continue
+		}
+		c.reconcile(kvs, rev)
+		atomic.StoreInt32(&c.synced, 1)
+		c.readyOnce.Do(func() {
+			close(c.readyChan)
+		})
+
+		wch := c.discovery.Watch(c.ctx, c.prefix, rev+1)
+		bRecv := false
+		for v := range wch {
+			bRecv = true
+			for _, v1 := range v {
+				c.apply(v1, rev)
+			}
+		}
+		atomic.StoreInt32(&c.synced, 0)
+		if c.ctx.Err() != nil {
+			return
+		}
+		log.Printf("cache [%s] watch closed at revision %d, resync", c.prefix, c.Revision())
+		//监听没收到事件就关闭, 退避后再加载, 避免后端关闭或持续失败时空转
+		if bRecv {
+			backoff = SESSION_BACKOFF
+		} else {
+			backoff = c.wait(backoff)
+		}
+	}
+}
+
+// 等待退避时间, 返回下次的退避时间
+func (c *Cache) wait(backoff time.Duration) time.Duration {
+	select {
+	case <-time.After(backoff):
+	case <-c.ctx.Done():
+	}
+	if backoff *= 2; backoff > SESSION_MAX_BACKOFF {
+		backoff = SESSION_MAX_BACKOFF
+	}
+	return backoff
+}
+
+// 重新加载后与缓存对比, 补发期间错过的写入和删除
+func (c *Cache) reconcile(kvs []*KeyValue, rev int64) {
+	kvMap := make(map[string]*KeyValue, len(kvs))
+	for _, kv := range kvs {
+		kvMap[kv.Key] = kv
+		prev, bEx := c.kvMap[kv.Key]
+		if !bEx || prev.ModRevision != kv.ModRevision {
+			c.handler(&Event{Type: EVENT_PUT, Kv: kv, PrevKv: prev})
+		}
+	}
+	for key, prev := range c.kvMap {
+		if _, bEx := kvMap[key]; !bEx {
+			c.handler(&Event{Type: EVENT_DELETE, Kv: &KeyValue{Key: key, ModRevision: rev}, PrevKv: prev})
+		}
+	}
+	c.kvMap = kvMap
+	atomic.StoreInt64(&c.revision, rev)
+}
+
+// 早于加载revision的事件已包含在加载结果中
+func (c *Cache) apply(event *Event, rev int64) {
+	if event.Kv.ModRevision <= rev {
+		return
+	}
+	prev := c.kvMap[event.Kv.Key]
+	if event.Type == EVENT_PUT {
+		c.kvMap[event.Kv.Key] = event.Kv
+	} else {
+		if prev == nil {
+			return
+		}
+		delete(c.kvMap, event.Kv.Key)
+	}
+	//事件可能被多个watcher共享, 复制后再补PrevKv
+	if event.PrevKv == nil {
+		event = &Event{Type: event.Type, Kv: event.Kv, PrevKv: prev}
+	}
+	atomic.StoreInt64(&c.revision, event.Kv.ModRevision)
+	c.handler(event)
+}
@@ -0,0 +1,154 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// 可手动关闭监听和阻塞加载, 模拟压缩或断线
+	compactDiscovery struct {
+		*Memory
+		locker   sync.Mutex
+		cancel   context.CancelFunc
+		listChan chan bool
+	}
+
+	// handler收到的事件还原出的视图
+	cacheView struct {
+		locker sync.Mutex
+		kvMap  map[string]string
+		err    string
+	}
+)
+
+func (d *compactDiscovery) Watch(ctx context.Context, prefix string, rev int64) <-chan []*Event {
+	ctx, cancel := context.WithCancel(ctx)
+	d.locker.Lock()
+	d.cancel = cancel
+	d.locker.Unlock()
+	return d.Memory.Watch(ctx, prefix, rev)
+}
+
+func (d *compactDiscovery) watching() bool {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.cancel != nil
+}
+
+func (d *compactDiscovery) Get(prefix string) ([]*KeyValue, int64, error) {
+	d.locker.Lock()
+	listChan := d.listChan
+	d.locker.Unlock()
+	if listChan != nil {
+		<-listChan
+	}
+	return d.Memory.Get(prefix)
+}
+
+// 关闭当前监听, 重新加载阻塞到返回的函数被调用
+func (d *compactDiscovery) compact() func() {
+	listChan := make(chan bool)
+	d.locker.Lock()
+	d.listChan = listChan
+	d.cancel()
+	d.locker.Unlock()
+	return func() {
+		d.locker.Lock()
+		d.listChan = nil
+		d.locker.Unlock()
+		close(listChan)
+	}
+}
+
+func (v *cacheView) handle(event *Event) {
+	v.locker.Lock()
+	defer v.locker.Unlock()
+	if event.Type == EVENT_PUT {
+		v.kvMap[event.Kv.Key] = string(event.Kv.Value)
+		return
+	}
+	if event.PrevKv == nil {
+		v.err = "delete without PrevKv " + event.Kv.Key
+	}
+	delete(v.kvMap, event.Kv.Key)
+}
+
+func (v *cacheView) equal(m *Memory) bool {
+	kvs, _, _ := m.Get("server/")
+	v.locker.Lock()
+	defer v.locker.Unlock()
+	if len(kvs) != len(v.kvMap) {
+		return false
+	}
+	for _, kv := range kvs {
+		if v.kvMap[kv.Key] != string(kv.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCacheResync(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(m *Memory)
+	}{
+		{"put", func(m *Memory) { m.Put("server/game/3", "c", 0) }},
+		{"update", func(m *Memory) { m.Put("server/game/1", "a2", 0) }},
+		{"delete", func(m *Memory) { m.Delete("server/game/1") }},
+		{"delete and recreate", func(m *Memory) {
+			m.Delete("server/game/2")
+			m.Put("server/game/2", "b", 0)
+		}},
+		{"many", func(m *Memory) {
+			for i := 0; i < MEMORY_HISTORY+10; i++ {
+				m.Put("server/game/1", "a", 0)
+			}
+			m.DeletePrefix("server/")
+		}},
+	}
+	for _, test := range tests {
+		m := NewMemory()
+		d := &compactDiscovery{Memory: m}
+		m.Put("server/game/1", "a", 0)
+		m.Put("server/game/2", "b", 0)
+		view := &cacheView{kvMap: make(map[string]string)}
+		c := NewCache(d, "server/", view.handle)
+		<-c.Ready()
+		waitCache(t, d.watching) //Ready在开始监听之前关闭
+
+		resume := d.compact()
+		waitCache(t, func() bool { return !c.Synced() })
+		test.ops(m)
+		resume()
+		waitCache(t, func() bool { return c.Synced() && view.equal(m) })
+		view.locker.Lock()
+		if view.err != "" {
+			t.Errorf("%s: %s", test.name, view.err)
+		}
+		view.locker.Unlock()
+		if _, rev, _ := m.Get("server/"); c.Revision() != rev {
+			t.Errorf("%s: revision %d, want %d", test.name, c.Revision(), rev)
+		}
+
+		//重新同步后继续监听
+		m.Put("server/gate/1", "g", 0)
+		waitCache(t, func() bool { return view.equal(m) })
+		c.Close()
+		m.Close()
+	}
+}
+
+func waitCache(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
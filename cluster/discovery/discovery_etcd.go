@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type (
+	Etcd struct {
+		client *clientv3.Client
+		lease  clientv3.Lease
+		key    string
+		ref    int
+	}
+)
+
+var (
+	etcdMap    = make(map[string]*Etcd)
+	etcdLocker sync.Mutex
+)
+
+// 相同endpoints共享一个etcd客户端, 引用计数归零时Close才断开
+func NewEtcd(endpoints []string) *Etcd {
+	sorted := append([]string{}, endpoints...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+	etcdLocker.Lock()
+	defer etcdLocker.Unlock()
+	e, bEx := etcdMap[key]
+	if bEx {
+		e.ref++
+		return e
+	}
+
+	cfg := clientv3.Config{
+		Endpoints: endpoints,
+	}
+
+	etcdClient, err := clientv3.New(cfg)
+	if err != nil {
+		log.Fatal("cannot connect to etcd:", err)
+	}
+	e = &Etcd{client: etcdClient, lease: clientv3.NewLease(etcdClient), key: key, ref: 1}
+	etcdMap[key] = e
+	return e
+}
+
+func (e *Etcd) Client() *clientv3.Client {
+	return e.client
+}
+
+func (e *Etcd) Grant(ttl int64) (LeaseID, error) {
+	leaseResp, err := e.lease.Grant(context.Background(), ttl)
+	if err != nil {
+		return 0, err
+	}
+	return LeaseID(leaseResp.ID), nil
+}
+
+func (e *Etcd) KeepAliveOnce(id LeaseID) error {
+	_, err := e.lease.KeepAliveOnce(context.Background(), clientv3.LeaseID(id))
+	return err
+}
+
+func (e *Etcd) KeepAlive(ctx context.Context, id LeaseID) (<-chan struct{}, error) {
+	kch, err := e.lease.KeepAlive(ctx, clientv3.LeaseID(id))
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		for range kch {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (e *Etcd) Revoke(id LeaseID) error {
+	_, err := e.lease.Revoke(context.Background(), clientv3.LeaseID(id))
+	return err
+}
+
+func (e *Etcd) Put(key string, val string, lease LeaseID) error {
+	opts := []clientv3.OpOption{}
+	if lease != 0 {
+		opts = append(opts, clientv3.WithLease(clientv3.LeaseID(lease)))
+	}
+	_, err := e.client.Put(context.Background(), key, val, opts...)
+	return err
+}
+
+func (e *Etcd) Create(key string, val string, lease LeaseID) (bool, error) {
+	opts := []clientv3.OpOption{}
+	if lease != 0 {
+		opts = append(opts, clientv3.WithLease(clientv3.LeaseID(lease)))
+	}
+	tx := e.client.Txn(context.Background())
+	tx.If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, val, opts...)).
+		Else()
+	txnRes, err := tx.Commit()
+	return err == nil && txnRes.Succeeded, err
+}
+
+func (e *Etcd) Update(key string, val string, lease LeaseID) (bool, error) {
+	tx := e.client.Txn(context.Background())
+	tx.If(clientv3.Compare(clientv3.LeaseValue(key), "=", clientv3.LeaseID(lease))).
+		Then(clientv3.OpPut(key, val, clientv3.WithLease(clientv3.LeaseID(lease)))).
+		Else()
+	txnRes, err := tx.Commit()
+	return err == nil && txnRes.Succeeded, err
+}
+
+func (e *Etcd) Get(prefix string) ([]*KeyValue, int64, error) {
+	resp, err := e.client.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]*KeyValue, 0, len(resp.Kvs))
+	for _, v := range resp.Kvs {
+		kvs = append(kvs, toKeyValue(v))
+	}
+	return kvs, resp.Header.Revision, nil
+}
+
+func (e *Etcd) Delete(key string) error {
+	_, err := e.client.Delete(context.Background(), key)
+	return err
+}
+
+func (e *Etcd) DeleteOwned(key string, lease LeaseID) (bool, error) {
+	tx := e.client.Txn(context.Background())
+	tx.If(clientv3.Compare(clientv3.LeaseValue(key), "=", clientv3.LeaseID(lease))).
+		Then(clientv3.OpDelete(key)).
+		Else()
+	txnRes, err := tx.Commit()
+	return err == nil && txnRes.Succeeded, err
+}
+
+func (e *Etcd) DeletePrefix(prefix string) error {
+	_, err := e.client.Delete(context.Background(), prefix, clientv3.WithPrefix())
+	return err
+}
+
+func (e *Etcd) Watch(ctx context.Context, prefix string, rev int64) <-chan []*Event {
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+	wch := e.client.Watch(ctx, prefix, opts...)
+	ch := make(chan []*Event)
+	go func() {
+		defer close(ch)
+		for v := range wch {
+			if v.Err() != nil {
+				log.Printf("etcd watch %s error %v", prefix, v.Err())
+				return
+			}
+			events := make([]*Event, 0, len(v.Events))
+			for _, v1 := range v.Events {
+				event := &Event{Kv: toKeyValue(v1.Kv), PrevKv: toKeyValue(v1.PrevKv)}
+				if v1.Type == clientv3.EventTypeDelete {
+					event.Type = EVENT_DELETE
+				}
+				events = append(events, event)
+			}
+			select {
+			case ch <- events:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *Etcd) Close() error {
+	etcdLocker.Lock()
+	defer etcdLocker.Unlock()
+	if e.ref--; e.ref > 0 {
+		return nil
+	}
+	delete(etcdMap, e.key)
+	return e.client.Close()
+}
+
+func toKeyValue(kv *mvccpb.KeyValue) *KeyValue {
+	if kv == nil {
+		return nil
+	}
+	return &KeyValue{Key: string(kv.Key), Value: kv.Value, Lease: LeaseID(kv.Lease), CreateRevision: kv.CreateRevision, ModRevision: kv.ModRevision}
+}
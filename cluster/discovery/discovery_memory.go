@@ -0,0 +1,323 @@
+package discovery
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MEMORY_HISTORY = 4096 //保留的历史事件数, 更早的revision视为已压缩
+)
+
+type (
+	memoryLease struct {
+		ttl    int64
+		expire time.Time
+		keys   map[string]bool
+	}
+
+	memoryWatcher struct {
+		prefix string
+		ch     chan []*Event
+	}
+
+	// 进程内服务发现, 用于单机开发和测试, 不跨进程共享
+	Memory struct {
+		kvMap      map[string]*KeyValue
+		leaseMap   map[LeaseID]*memoryLease
+		watcherMap map[*memoryWatcher]bool
+		history    []*Event //最近的事件, 供Watch按revision补发
+		revision   int64
+		leaseSeed  LeaseID
+		closeChan  chan bool
+		locker     sync.Mutex
+	}
+)
+
+func NewMemory() *Memory {
+	m := &Memory{
+		kvMap:      make(map[string]*KeyValue),
+		leaseMap:   make(map[LeaseID]*memoryLease),
+		watcherMap: make(map[*memoryWatcher]bool),
+		closeChan:  make(chan bool),
+	}
+	go m.run()
+	return m
+}
+
+// 租约过期检查
+func (m *Memory) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.expire()
+		case <-m.closeChan:
+			return
+		}
+	}
+}
+
+func (m *Memory) expire() {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	now := time.Now()
+	for id, lease := range m.leaseMap {
+		if now.After(lease.expire) {
+			m.revoke(id)
+		}
+	}
+}
+
+func (m *Memory) Grant(ttl int64) (LeaseID, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.leaseSeed++
+	m.leaseMap[m.leaseSeed] = &memoryLease{ttl: ttl, expire: time.Now().Add(time.Duration(ttl) * time.Second), keys: make(map[string]bool)}
+	return m.leaseSeed, nil
+}
+
+func (m *Memory) KeepAliveOnce(id LeaseID) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	lease, bEx := m.leaseMap[id]
+	if !bEx {
+		return ErrLeaseNotFound
+	}
+	lease.expire = time.Now().Add(time.Duration(lease.ttl) * time.Second)
+	return nil
+}
+
+func (m *Memory) KeepAlive(ctx context.Context, id LeaseID) (<-chan struct{}, error) {
+	m.locker.Lock()
+	lease, bEx := m.leaseMap[id]
+	m.locker.Unlock()
+	if !bEx {
+		return nil, ErrLeaseNotFound
+	}
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		interval := time.Duration(lease.ttl) * time.Second / 3
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if m.KeepAliveOnce(id) != nil {
+					return
+				}
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			case <-m.closeChan:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (m *Memory) Revoke(id LeaseID) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, bEx := m.leaseMap[id]; !bEx {
+		return ErrLeaseNotFound
+	}
+	m.revoke(id)
+	return nil
+}
+
+// need m.locker before calling
+func (m *Memory) revoke(id LeaseID) {
+	lease := m.leaseMap[id]
+	delete(m.leaseMap, id)
+	for key := range lease.keys {
+		m.delete(key)
+	}
+}
+
+func (m *Memory) Put(key string, val string, lease LeaseID) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.put(key, val, lease)
+}
+
+func (m *Memory) Create(key string, val string, lease LeaseID) (bool, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, bEx := m.kvMap[key]; bEx {
+		return false, nil
+	}
+	err := m.put(key, val, lease)
+	return err == nil, err
+}
+
+func (m *Memory) Update(key string, val string, lease LeaseID) (bool, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if kv, bEx := m.kvMap[key]; !bEx || kv.Lease != lease {
+		return false, nil
+	}
+	err := m.put(key, val, lease)
+	return err == nil, err
+}
+
+// need m.locker before calling
+func (m *Memory) put(key string, val string, lease LeaseID) error {
+	if lease != 0 {
+		pLease, bEx := m.leaseMap[lease]
+		if !bEx {
+			return ErrLeaseNotFound
+		}
+		pLease.keys[key] = true
+	}
+
+	m.revision++
+	prev := m.kvMap[key]
+	kv := &KeyValue{Key: key, Value: []byte(val), Lease: lease, CreateRevision: m.revision, ModRevision: m.revision}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		if prev.Lease != 0 && prev.Lease != lease {
+			if pLease, bEx := m.leaseMap[prev.Lease]; bEx {
+				delete(pLease.keys, key)
+			}
+		}
+	}
+	m.kvMap[key] = kv
+	m.notify(&Event{Type: EVENT_PUT, Kv: kv, PrevKv: prev})
+	return nil
+}
+
+func (m *Memory) Get(prefix string) ([]*KeyValue, int64, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	kvs := []*KeyValue{}
+	for key, kv := range m.kvMap {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	return kvs, m.revision, nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.delete(key)
+	return nil
+}
+
+func (m *Memory) DeleteOwned(key string, lease LeaseID) (bool, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if kv, bEx := m.kvMap[key]; !bEx || kv.Lease != lease {
+		return false, nil
+	}
+	m.delete(key)
+	return true, nil
+}
+
+func (m *Memory) DeletePrefix(prefix string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for key := range m.kvMap {
+		if strings.HasPrefix(key, prefix) {
+			m.delete(key)
+		}
+	}
+	return nil
+}
+
+// need m.locker before calling
+func (m *Memory) delete(key string) {
+	prev, bEx := m.kvMap[key]
+	if !bEx {
+		return
+	}
+	if pLease, bEx := m.leaseMap[prev.Lease]; bEx {
+		delete(pLease.keys, key)
+	}
+	delete(m.kvMap, key)
+	m.revision++
+	m.notify(&Event{Type: EVENT_DELETE, Kv: &KeyValue{Key: key, ModRevision: m.revision}, PrevKv: prev})
+}
+
+// rev大于0时先补发历史事件, rev早于保留的历史时直接关闭
+func (m *Memory) Watch(ctx context.Context, prefix string, rev int64) <-chan []*Event {
+	w := &memoryWatcher{prefix: prefix, ch: make(chan []*Event, 128)}
+	m.locker.Lock()
+	if rev > 0 {
+		if len(m.history) > 0 && rev < m.history[0].Kv.ModRevision {
+			m.locker.Unlock()
+			close(w.ch)
+			return w.ch
+		}
+		events := []*Event{}
+		for _, event := range m.history {
+			if event.Kv.ModRevision >= rev && strings.HasPrefix(event.Kv.Key, prefix) {
+				events = append(events, event)
+			}
+		}
+		if len(events) > 0 {
+			w.ch <- events
+		}
+	}
+	m.watcherMap[w] = true
+	m.locker.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.closeChan:
+		}
+		m.locker.Lock()
+		if m.watcherMap[w] {
+			delete(m.watcherMap, w)
+			close(w.ch)
+		}
+		m.locker.Unlock()
+	}()
+	return w.ch
+}
+
+// need m.locker before calling
+func (m *Memory) notify(event *Event) {
+	m.history = append(m.history, event)
+	if len(m.history) > MEMORY_HISTORY {
+		m.history = m.history[len(m.history)-MEMORY_HISTORY:]
+	}
+	for w := range m.watcherMap {
+		if !strings.HasPrefix(event.Kv.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- []*Event{event}:
+		default: //消费太慢, 关闭让watcher重新同步
+			delete(m.watcherMap, w)
+			close(w.ch)
+		}
+	}
+}
+
+func (m *Memory) Close() error {
+	select {
+	case <-m.closeChan:
+		return ErrClosed
+	default:
+		close(m.closeChan)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 读取一批事件, 超时返回nil
+func recvEvents(ch <-chan []*Event) ([]*Event, bool) {
+	select {
+	case events, bOk := <-ch:
+		return events, bOk
+	case <-time.After(100 * time.Millisecond):
+		return nil, true
+	}
+}
+
+func TestMemoryWatchRevision(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	m.Put("server/game/1", "a", 0) //rev 1
+	m.Put("server/gate/1", "b", 0) //rev 2
+	m.Put("server/game/2", "c", 0) //rev 3
+	m.Delete("server/game/1")      //rev 4
+
+	tests := []struct {
+		name   string
+		prefix string
+		rev    int64
+		keys   []string
+	}{
+		{"from now", "server/", 0, nil},
+		{"all", "server/", 1, []string{"server/game/1", "server/gate/1", "server/game/2", "server/game/1"}},
+		{"prefix", "server/game/", 1, []string{"server/game/1", "server/game/2", "server/game/1"}},
+		{"after list", "server/game/", 4, []string{"server/game/1"}},
+		{"future", "server/", 5, nil},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, bOk := recvEvents(m.Watch(ctx, test.prefix, test.rev))
+		cancel()
+		if !bOk {
+			t.Fatalf("%s: watch closed", test.name)
+		}
+		if len(events) != len(test.keys) {
+			t.Fatalf("%s: %d events, want %d", test.name, len(events), len(test.keys))
+		}
+		for i, event := range events {
+			if event.Kv.Key != test.keys[i] {
+				t.Errorf("%s: event %d key %s, want %s", test.name, i, event.Kv.Key, test.keys[i])
+			}
+		}
+	}
+	//补发的删除事件带PrevKv
+	events, _ := recvEvents(m.Watch(context.Background(), "server/game/1", 4))
+	if events[0].Type != EVENT_DELETE || events[0].PrevKv == nil || string(events[0].PrevKv.Value) != "a" {
+		t.Fatalf("delete event %+v", events[0])
+	}
+}
+
+// 早于保留历史的revision视为已压缩, 直接关闭
+func TestMemoryWatchCompacted(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	for i := 0; i < MEMORY_HISTORY+10; i++ {
+		m.Put("server/game/1", "a", 0)
+	}
+	if _, bOk := recvEvents(m.Watch(context.Background(), "server/", 1)); bOk {
+		t.Fatal("watch from compacted revision not closed")
+	}
+	_, rev, _ := m.Get("server/")
+	ch := m.Watch(context.Background(), "server/", rev+1)
+	m.Put("server/game/1", "b", 0)
+	if events, bOk := recvEvents(ch); !bOk || len(events) != 1 || events[0].Kv.ModRevision != rev+1 {
+		t.Fatalf("watch after list %v %v", events, bOk)
+	}
+}
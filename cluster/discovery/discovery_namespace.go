@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+)
+
+type (
+	// 命名空间, 所有key加上前缀, 读出时去掉
+	// 共用一套etcd的多个环境或区服互相隔离
+	Namespace struct {
+		Discovery
+		prefix string
+	}
+)
+
+// namespace为空时直接返回d
+func NewNamespace(d Discovery, namespace string) Discovery {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return d
+	}
+	return &Namespace{Discovery: d, prefix: namespace + "/"}
+}
+
+func (n *Namespace) Prefix() string {
+	return n.prefix
+}
+
+func (n *Namespace) Put(key string, val string, lease LeaseID) error {
+	return n.Discovery.Put(n.prefix+key, val, lease)
+}
+
+func (n *Namespace) Create(key string, val string, lease LeaseID) (bool, error) {
+	return n.Discovery.Create(n.prefix+key, val, lease)
+}
+
+func (n *Namespace) Update(key string, val string, lease LeaseID) (bool, error) {
+	return n.Discovery.Update(n.prefix+key, val, lease)
+}
+
+func (n *Namespace) Get(prefix string) ([]*KeyValue, int64, error) {
+	kvs, rev, err := n.Discovery.Get(n.prefix + prefix)
+	for i, kv := range kvs {
+		kvs[i] = n.trim(kv)
+	}
+	return kvs, rev, err
+}
+
+func (n *Namespace) Delete(key string) error {
+	return n.Discovery.Delete(n.prefix + key)
+}
+
+func (n *Namespace) DeleteOwned(key string, lease LeaseID) (bool, error) {
+	return n.Discovery.DeleteOwned(n.prefix+key, lease)
+}
+
+func (n *Namespace) DeletePrefix(prefix string) error {
+	return n.Discovery.DeletePrefix(n.prefix + prefix)
+}
+
+func (n *Namespace) Watch(ctx context.Context, prefix string, rev int64) <-chan []*Event {
+	wch := n.Discovery.Watch(ctx, n.prefix+prefix, rev)
+	ch := make(chan []*Event)
+	go func() {
+		defer close(ch)
+		for events := range wch {
+			trimmed := make([]*Event, 0, len(events))
+			for _, event := range events {
+				trimmed = append(trimmed, &Event{Type: event.Type, Kv: n.trim(event.Kv), PrevKv: n.trim(event.PrevKv)})
+			}
+			select {
+			case ch <- trimmed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// 后端的KeyValue可能是共享的, 复制后再改key
+func (n *Namespace) trim(kv *KeyValue) *KeyValue {
+	if kv == nil {
+		return nil
+	}
+	trimmed := *kv
+	trimmed.Key = strings.TrimPrefix(kv.Key, n.prefix)
+	return &trimmed
+}
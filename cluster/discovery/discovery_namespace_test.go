@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNamespaceKey(t *testing.T) {
+	tests := []struct {
+		namespace string
+		backend   string //后端实际存储的key
+	}{
+		{"", "server/game/1"},
+		{"qa", "qa/server/game/1"},
+		{"/qa/", "qa/server/game/1"},
+		{"dev/realm1", "dev/realm1/server/game/1"},
+	}
+	for _, test := range tests {
+		m := NewMemory()
+		n := NewNamespace(m, test.namespace)
+		if test.namespace == "" && n != Discovery(m) {
+			t.Fatalf("empty namespace wrapped")
+		}
+		wch := n.Watch(context.Background(), "server/", 0)
+		n.Put("server/game/1", "a", 0)
+
+		if kvs, _, _ := m.Get(test.backend); len(kvs) != 1 || kvs[0].Key != test.backend {
+			t.Fatalf("[%s] backend key %v", test.namespace, kvs)
+		}
+		if kvs, _, _ := n.Get("server/"); len(kvs) != 1 || kvs[0].Key != "server/game/1" {
+			t.Fatalf("[%s] get key %v", test.namespace, kvs)
+		}
+		n.Delete("server/game/1")
+		for _, typ := range []EVENT_TYPE{EVENT_PUT, EVENT_DELETE} {
+			events := <-wch
+			if events[0].Type != typ || events[0].Kv.Key != "server/game/1" {
+				t.Fatalf("[%s] event %v %s", test.namespace, events[0].Type, events[0].Kv.Key)
+			}
+			if typ == EVENT_DELETE && events[0].PrevKv.Key != "server/game/1" {
+				t.Fatalf("[%s] prev key %s", test.namespace, events[0].PrevKv.Key)
+			}
+		}
+		//去前缀时不能改到后端共享的KeyValue
+		if events, _ := recvEvents(m.Watch(context.Background(), test.backend, 1)); events[0].Kv.Key != test.backend {
+			t.Fatalf("[%s] backend event key %s", test.namespace, events[0].Kv.Key)
+		}
+		m.Close()
+	}
+}
+
+// 前缀互为子串的命名空间互不可见
+func TestNamespaceIsolation(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	qa, qa2 := NewNamespace(m, "qa"), NewNamespace(m, "qa2")
+	qa.Put("server/game/1", "a", 0)
+	qa2.Put("server/game/2", "b", 0)
+	for _, test := range []struct {
+		d   Discovery
+		key string
+	}{
+		{qa, "server/game/1"},
+		{qa2, "server/game/2"},
+	} {
+		if kvs, _, _ := test.d.Get("server/"); len(kvs) != 1 || kvs[0].Key != test.key {
+			t.Fatalf("get %v, want %s", kvs, test.key)
+		}
+	}
+	qa.DeletePrefix("server/")
+	if kvs, _, _ := qa2.Get("server/"); len(kvs) != 1 {
+		t.Fatal("delete prefix crossed namespace")
+	}
+}
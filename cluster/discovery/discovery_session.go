@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_BACKOFF     = 500 * time.Millisecond //申请租约失败的退避基数
+	SESSION_MAX_BACKOFF = 30 * time.Second       //最大退避
+)
+
+type (
+	// 租约会话, 持续保活, 租约丢失后回调并按退避重新申请
+	Session struct {
+		discovery Discovery
+		ttl       int64
+		leaseId   LeaseID
+		grantList []func(LeaseID)
+		lostList  []func()
+		ctx       context.Context
+		cancel    context.CancelFunc
+		locker    sync.Mutex
+	}
+)
+
+func NewSession(d Discovery, ttl int64) *Session {
+	s := &Session{discovery: d, ttl: ttl}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s
+}
+
+// 当前租约, 未持有时为0
+func (s *Session) Lease() LeaseID {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.leaseId
+}
+
+// 获得新租约时回调, 用于写入挂在租约上的key; 已持有租约时立即回调
+func (s *Session) OnGrant(fun func(LeaseID)) {
+	s.locker.Lock()
+	s.grantList = append(s.grantList, fun)
+	leaseId := s.leaseId
+	s.locker.Unlock()
+	if leaseId != 0 {
+		fun(leaseId)
+	}
+}
+
+// 租约丢失时回调, 挂在租约上的key已被删除
+func (s *Session) OnLost(fun func()) {
+	s.locker.Lock()
+	s.lostList = append(s.lostList, fun)
+	s.locker.Unlock()
+}
+
+// 关闭会话并撤销租约
+func (s *Session) Close() {
+	s.cancel()
+	s.locker.Lock()
+	leaseId := s.leaseId
+	s.leaseId = 0
+	s.locker.Unlock()
+	if leaseId != 0 {
+		s.discovery.Revoke(leaseId)
+	}
+}
+
+func (s *Session) run() {
+	backoff := SESSION_BACKOFF
+	for s.ctx.Err() == nil {
+		leaseId, err := s.discovery.Grant(s.ttl)
+		var ch <-chan struct{}
+		if err == nil {
+			ch, err = s.discovery.KeepAlive(s.ctx, leaseId)
+		}
+		if err != nil {
+			log.Printf("session grant error %v, retry in %v", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-s.ctx.Done():
+			}
+			if backoff *= 2; backoff > SESSION_MAX_BACKOFF {
+				backoff = SESSION_MAX_BACKOFF
+			}
+			continue
+		}
+
+		backoff = SESSION_BACKOFF
+		s.locker.Lock()
+		s.leaseId = leaseId
+		grantList := append([]func(LeaseID){}, s.grantList...)
+		s.locker.Unlock()
+		for _, fun := range grantList {
+			fun(leaseId)
+		}
+
+		for range ch {
+		}
+
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.locker.Lock()
+		s.leaseId = 0
+		lostList := append([]func(){}, s.lostList...)
+		s.locker.Unlock()
+		log.Printf("session lease %d lost", leaseId)
+		for _, fun := range lostList {
+			fun()
+		}
+	}
+}
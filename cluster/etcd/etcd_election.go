@@ -0,0 +1,211 @@
+package etcd
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+)
+
+const (
+	ELECTION_DIR      = "election/"
+	ELECTION_TTL_TIME = 10
+)
+
+type (
+	// 选主, 同一name下create revision最小的候选者为leader
+	// 回调在选举协程顺序执行, 不能阻塞
+	Election struct {
+		name        string
+		prefix      string
+		discovery   discovery.Discovery
+		session     *discovery.Session
+		val         string
+		key         string //本节点候选key
+		leaderKey   string
+		leaderVal   string
+		isLeader    bool
+		kvMap       map[string]*discovery.KeyValue
+		electedList []func()
+		demotedList []func()
+		observeList []func(string)
+		cache       *discovery.Cache
+		locker      sync.Mutex
+		notifyLock  sync.Mutex //update串行执行, 保证回调顺序
+	}
+)
+
+func NewElection(d discovery.Discovery, name string) *Election {
+	e := &Election{
+		name:      name,
+		prefix:    ELECTION_DIR + name + "/",
+		discovery: d,
+		kvMap:     make(map[string]*discovery.KeyValue),
+	}
+	e.cache = discovery.NewCache(d, e.prefix, e.handle)
+	return e
+}
+
+func (e *Election) Name() string {
+	return e.name
+}
+
+// 参与竞选, val为leader对外的值
+func (e *Election) Campaign(val string) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if e.session != nil {
+		return
+	}
+	e.val = val
+	e.session = discovery.NewSession(e.discovery, ELECTION_TTL_TIME)
+	session := e.session
+	session.OnGrant(func(leaseId discovery.LeaseID) {
+		e.put(session, leaseId)
+	})
+	//租约丢失时候选key已被删除, 立即降级, 不等watch事件
+	session.OnLost(func() {
+		e.locker.Lock()
+		if e.session != session {
+			e.locker.Unlock()
+			return
+		}
+		e.clearKey()
+		e.locker.Unlock()
+		e.update()
+	})
+}
+
+func (e *Election) put(session *discovery.Session, leaseId discovery.LeaseID) {
+	key := fmt.Sprintf("%s%x", e.prefix, leaseId)
+	e.locker.Lock()
+	if e.session != session {
+		e.locker.Unlock()
+		return
+	}
+	e.key = key
+	val := e.val
+	e.locker.Unlock()
+	if _, err := e.discovery.Create(key, val, leaseId); err != nil {
+		log.Printf("election [%s] campaign error %v", e.name, err)
+		time.AfterFunc(discovery.SESSION_BACKOFF, func() {
+			if session.Lease() == leaseId {
+				e.put(session, leaseId)
+			}
+		})
+	}
+}
+
+// 退出竞选, 是leader时让位
+func (e *Election) Resign() {
+	e.locker.Lock()
+	session := e.session
+	e.session = nil
+	e.clearKey()
+	e.locker.Unlock()
+	if session != nil {
+		session.Close()
+	}
+	e.update()
+}
+
+// need e.locker before calling
+func (e *Election) clearKey() {
+	if e.key != "" {
+		delete(e.kvMap, e.key)
+	}
+	e.key = ""
+}
+
+func (e *Election) IsLeader() bool {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	return e.isLeader
+}
+
+// 当前leader的值, 没有leader时返回false
+func (e *Election) Leader() (string, bool) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	return e.leaderVal, e.leaderKey != ""
+}
+
+// 本节点当选
+func (e *Election) OnElected(fun func()) {
+	e.locker.Lock()
+	e.electedList = append(e.electedList, fun)
+	e.locker.Unlock()
+}
+
+// 本节点失去leader
+func (e *Election) OnDemoted(fun func()) {
+	e.locker.Lock()
+	e.demotedList = append(e.demotedList, fun)
+	e.locker.Unlock()
+}
+
+// leader变化, 参数为新leader的值, 没有leader时为空
+func (e *Election) OnObserve(fun func(string)) {
+	e.locker.Lock()
+	e.observeList = append(e.observeList, fun)
+	e.locker.Unlock()
+}
+
+func (e *Election) Close() {
+	e.Resign()
+	e.cache.Close()
+}
+
+func (e *Election) handle(event *discovery.Event) {
+	e.locker.Lock()
+	if event.Type == discovery.EVENT_PUT {
+		e.kvMap[event.Kv.Key] = event.Kv
+	} else {
+		delete(e.kvMap, event.Kv.Key)
+	}
+	e.locker.Unlock()
+	e.update()
+}
+
+func (e *Election) update() {
+	e.notifyLock.Lock()
+	defer e.notifyLock.Unlock()
+
+	e.locker.Lock()
+	var leader *discovery.KeyValue
+	for _, v := range e.kvMap {
+		if leader == nil || v.CreateRevision < leader.CreateRevision {
+			leader = v
+		}
+	}
+	leaderKey, leaderVal := "", ""
+	if leader != nil {
+		leaderKey, leaderVal = leader.Key, string(leader.Value)
+	}
+	changed := leaderKey != e.leaderKey
+	wasLeader := e.isLeader
+	e.leaderKey, e.leaderVal = leaderKey, leaderVal
+	e.isLeader = leaderKey != "" && leaderKey == e.key
+	isLeader := e.isLeader
+	electedList, demotedList, observeList := e.electedList, e.demotedList, e.observeList
+	e.locker.Unlock()
+
+	if changed {
+		for _, fun := range observeList {
+			fun(leaderVal)
+		}
+	}
+	if !wasLeader && isLeader {
+		log.Printf("election [%s] elected", e.name)
+		for _, fun := range electedList {
+			fun()
+		}
+	} else if wasLeader && !isLeader {
+		log.Printf("election [%s] demoted", e.name)
+		for _, fun := range demotedList {
+			fun()
+		}
+	}
+}
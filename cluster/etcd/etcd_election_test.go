@@ -0,0 +1,63 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+)
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElectionLeaseLost(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	a, b := NewElection(d, "test"), NewElection(d, "test")
+	defer a.Close()
+	defer b.Close()
+
+	demoted := make(chan bool, 1)
+	a.OnDemoted(func() { demoted <- true })
+	a.Campaign("a")
+	waitFor(t, 2*time.Second, a.IsLeader)
+	b.Campaign("b")
+	waitFor(t, 2*time.Second, func() bool {
+		b.locker.Lock()
+		defer b.locker.Unlock()
+		return len(b.kvMap) == 2
+	})
+
+	//a的租约过期, 立即降级, b当选
+	d.Revoke(a.session.Lease())
+	select {
+	case <-demoted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not demoted")
+	}
+	if a.IsLeader() {
+		t.Fatal("a still leader")
+	}
+	waitFor(t, 2*time.Second, b.IsLeader)
+}
+
+func TestElectionResign(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	a := NewElection(d, "test")
+	defer a.Close()
+	a.Campaign("a")
+	waitFor(t, 2*time.Second, a.IsLeader)
+	a.Resign()
+	if a.IsLeader() {
+		t.Fatal("leader after resign")
+	}
+}
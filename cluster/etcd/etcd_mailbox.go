@@ -1,108 +1,269 @@
 package etcd
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/rpc"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const (
-	MAILBOX_DIR     = "mailbox/"
-	MAILBOX_TL_TIME = 1800
+	MAILBOX_DIR       = "mailbox/"
+	MAILBOX_KICK_DIR  = "mailboxkick/" //顶号请求
+	MAILBOX_TTL_TIME  = 10
+	MAILBOX_KICK_TIME = 5 * time.Second //持有者收到顶号后未主动释放, 超时强制释放
+)
+
+var (
+	ErrMailBoxNotOwner = errors.New("mailbox not owner")
 )
 
 type (
+	MailBoxMap map[int64]*rpc.MailBox
+
+	mailBoxKey struct {
+		mailType rpc.MAIL
+		id       int64
+	}
+
+	// 实体mailbox, 按(MailType, Id)区分, key为mailbox/<MailType>/<Id>
+	// mailbox挂在本节点的会话租约上, 自动续约, 节点宕机时随租约过期
+	// 会话丢失时持有的mailbox向actor投递"<MailType>.OnKick", 参数为0
 	MailBox struct {
 		*common.ClusterInfo
-		client        *clientv3.Client
-		lease         clientv3.Lease
+		discovery     discovery.Discovery
+		session       *discovery.Session
+		conf          common.MailBox
+		leaseId       discovery.LeaseID
 		mailBoxLocker *sync.RWMutex
-		mailBoxMap    map[int64]*rpc.MailBox
+		mailBoxMap    map[rpc.MAIL]MailBoxMap
+		waitMap       map[mailBoxKey][]chan bool
+		cache         *discovery.Cache
+		kickCache     *discovery.Cache
 	}
 )
 
-func (m *MailBox) Init(info *common.ClusterInfo, endpoints []string) {
-	cfg := clientv3.Config{
-		Endpoints: endpoints,
-	}
-
-	etcdClient, err := clientv3.New(cfg)
-	if err != nil {
-		log.Fatal("cannot connect to etcd:", err)
-	}
+// Deprecated: 使用InitConf, 旧接口只有player mailbox, 沿用mailbox/<Id>的key
+func (m *MailBox) Init(info *common.ClusterInfo, d discovery.Discovery) {
+	m.InitConf(info, d, &common.MailBox{LegacyKey: true})
+}
 
+// conf为nil时使用默认配置
+func (m *MailBox) InitConf(info *common.ClusterInfo, d discovery.Discovery, conf *common.MailBox) {
 	m.ClusterInfo = info
-	m.client = etcdClient
-	m.lease = clientv3.NewLease(etcdClient)
+	m.discovery = d
+	if conf != nil {
+		m.conf = *conf
+	}
+	if m.conf.TTL <= 0 {
+		m.conf.TTL = MAILBOX_TTL_TIME
+	}
 	m.mailBoxLocker = &sync.RWMutex{}
-	m.mailBoxMap = make(map[int64]*rpc.MailBox)
+	m.mailBoxMap = make(map[rpc.MAIL]MailBoxMap)
+	m.waitMap = make(map[mailBoxKey][]chan bool)
+	m.session = discovery.NewSession(d, m.conf.TTL)
+	m.session.OnGrant(func(leaseId discovery.LeaseID) {
+		m.mailBoxLocker.Lock()
+		m.leaseId = leaseId
+		m.mailBoxLocker.Unlock()
+	})
+	m.session.OnLost(m.lost)
 	m.Start()
 }
 
 func (m *MailBox) Start() {
-	go m.Run()
-}
-
-func (m *MailBox) Run() {
-	wch := m.client.Watch(context.Background(), MAILBOX_DIR, clientv3.WithPrefix(), clientv3.WithPrevKV())
-	m.getAll()
-	for v := range wch {
-		for _, v1 := range v.Events {
-			if v1.Type.String() == "PUT" {
-				info := nodeToMailBox(v1.Kv.Value)
-				m.add(info)
-			} else {
-				info := nodeToMailBox(v1.PrevKv.Value)
-				m.del(info)
+	m.cache = discovery.NewCache(m.discovery, MAILBOX_DIR, m.handle)
+	m.kickCache = discovery.NewCache(m.discovery, MAILBOX_KICK_DIR, m.handleKick)
+}
+
+func (m *MailBox) Session() *discovery.Session {
+	return m.session
+}
+
+func (m *MailBox) Discovery() discovery.Discovery {
+	return m.discovery
+}
+
+// mailbox缓存已与注册中心同步
+func (m *MailBox) Synced() bool {
+	return m.cache.Synced()
+}
+
+func (m *MailBox) handle(event *discovery.Event) {
+	if event.Type == discovery.EVENT_PUT {
+		info := nodeToMailBox(event.Kv.Value)
+		m.add(info)
+	} else {
+		info := nodeToMailBox(event.PrevKv.Value)
+		m.del(info)
+	}
+}
+
+// 处理发给本节点的顶号请求
+func (m *MailBox) handleKick(event *discovery.Event) {
+	if event.Type == discovery.EVENT_PUT {
+		m.kick(nodeToMailBox(event.Kv.Value))
+	}
+}
+
+func (m *MailBox) kick(req *rpc.MailBox) {
+	mail := m.GetMail(req.MailType, req.Id)
+	if mail == nil || !m.isOwner(mail) {
+		return
+	}
+	actor.MGR.SendMsg(rpc.RpcHead{Id: req.Id}, fmt.Sprintf("%s.OnKick", mail.MailType.String()), req.ClusterId)
+	leaseId := discovery.LeaseID(mail.LeaseId)
+	time.AfterFunc(MAILBOX_KICK_TIME, func() {
+		if bOk, _ := m.discovery.DeleteOwned(m.mailKey(MAILBOX_DIR, req.MailType, req.Id), leaseId); bOk {
+			log.Printf("mailbox [%s/%d] kick timeout, released", req.MailType.String(), req.Id)
+		}
+	})
+}
+
+// 会话丢失, 挂在旧租约上的mailbox已被删除, 通知持有的actor不再处理并落地数据
+// 不等watch的删除事件, 网络分区时watch可能长时间收不到
+func (m *MailBox) lost() {
+	m.mailBoxLocker.Lock()
+	leaseId := m.leaseId
+	m.leaseId = 0
+	mailList := []*rpc.MailBox{}
+	for _, mailMap := range m.mailBoxMap {
+		for _, mail := range mailMap {
+			if mail.ClusterId == m.Id() && discovery.LeaseID(mail.LeaseId) == leaseId {
+				mailList = append(mailList, mail)
 			}
 		}
 	}
+	m.mailBoxLocker.Unlock()
+	log.Printf("mailbox session lost, %d owned mailbox released", len(mailList))
+	for _, mail := range mailList {
+		actor.MGR.SendMsg(rpc.RpcHead{Id: mail.Id}, fmt.Sprintf("%s.OnKick", mail.MailType.String()), uint32(0))
+	}
 }
 
+// 创建mailbox, 挂在本节点会话租约上
 func (m *MailBox) Create(info *rpc.MailBox) bool {
-	leaseResp, err := m.lease.Grant(context.Background(), MAILBOX_TL_TIME)
-	if err == nil {
-		leaseId := leaseResp.ID
-		info.LeaseId = int64(leaseId)
-		key := MAILBOX_DIR + fmt.Sprintf("%d", info.Id)
-		data, _ := json.Marshal(info)
-		//设置key
-		tx := m.client.Txn(context.Background())
-		tx.If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
-			Then(clientv3.OpPut(key, string(data), clientv3.WithLease(leaseId))).
-			Else()
-		txnRes, err := tx.Commit()
-		return err == nil && txnRes.Succeeded
-	}
-	return false
+	leaseId := m.session.Lease()
+	if leaseId == 0 {
+		return false
+	}
+	info.LeaseId = int64(leaseId)
+	if info.ClusterId == 0 {
+		info.ClusterId = m.Id()
+	}
+	data, _ := json.Marshal(info)
+	//设置key
+	bOk, err := m.discovery.Create(m.mailKey(MAILBOX_DIR, info.MailType, info.Id), string(data), leaseId)
+	return err == nil && bOk
+}
+
+// 登录, mailbox被其他节点持有时发送顶号请求, 等旧节点释放后创建, 超时返回false
+// 旧节点的actor收到"<MailType>.OnKick"后落地数据并调用Delete释放
+// 本节点会话租约未授予时等待, 不发送无租约的顶号请求
+func (m *MailBox) Login(info *rpc.MailBox, timeout time.Duration) bool {
+	kickKey := m.mailKey(MAILBOX_KICK_DIR, info.MailType, info.Id)
+	key := mailBoxKey{info.MailType, info.Id}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var kickLease discovery.LeaseID
+	defer func() {
+		if kickLease != 0 {
+			m.discovery.DeleteOwned(kickKey, kickLease)
+		}
+	}()
+	for {
+		wait := m.wait(key)
+		if m.Create(info) {
+			m.unWait(key, wait)
+			return true
+		}
+		if mail := m.GetMail(info.MailType, info.Id); mail != nil && m.isOwner(mail) {
+			m.unWait(key, wait)
+			return true
+		}
+
+		var retry <-chan time.Time
+		if leaseId := m.session.Lease(); leaseId == 0 {
+			retry = time.After(discovery.SESSION_BACKOFF)
+		} else {
+			kickLease = leaseId
+			req := &rpc.MailBox{Id: info.Id, MailType: info.MailType, ClusterId: m.Id()}
+			data, _ := json.Marshal(req)
+			if err := m.discovery.Put(kickKey, string(data), kickLease); err != nil {
+				kickLease = 0
+				retry = time.After(discovery.SESSION_BACKOFF)
+			}
+		}
+		select {
+		case <-wait:
+		case <-retry:
+			m.unWait(key, wait)
+		case <-timer.C:
+			m.unWait(key, wait)
+			return false
+		}
+	}
 }
 
+// 持有者更新mailbox
+func (m *MailBox) Update(info *rpc.MailBox) error {
+	leaseId := m.session.Lease()
+	info.LeaseId = int64(leaseId)
+	if info.ClusterId == 0 {
+		info.ClusterId = m.Id()
+	}
+	data, _ := json.Marshal(info)
+	bOk, err := m.discovery.Update(m.mailKey(MAILBOX_DIR, info.MailType, info.Id), string(data), leaseId)
+	if err != nil {
+		return err
+	}
+	if !bOk {
+		return ErrMailBoxNotOwner
+	}
+	return nil
+}
+
+// 续约, 会话已自动续约, 保留兼容
 func (m *MailBox) Lease(leaseId int64) error {
-	_, err := m.lease.KeepAliveOnce(context.Background(), clientv3.LeaseID(leaseId))
-	return err
+	return m.discovery.KeepAliveOnce(discovery.LeaseID(leaseId))
 }
 
+// Deprecated: 使用DeleteMail, 默认为player mailbox
 func (m *MailBox) Delete(Id int64) error {
-	key := MAILBOX_DIR + fmt.Sprintf("%d", Id)
-	_, err := m.client.Delete(context.Background(), key)
-	return err
+	return m.DeleteMail(rpc.MAIL_Player, Id)
+}
+
+// 持有者删除mailbox
+func (m *MailBox) DeleteMail(mailType rpc.MAIL, Id int64) error {
+	bOk, err := m.discovery.DeleteOwned(m.mailKey(MAILBOX_DIR, mailType, Id), m.session.Lease())
+	if err != nil {
+		return err
+	}
+	if !bOk {
+		return ErrMailBoxNotOwner
+	}
+	return nil
 }
 
 func (m *MailBox) DeleteAll() error {
-	_, err := m.client.Delete(context.Background(), MAILBOX_DIR, clientv3.WithPrefix())
-	return err
+	return m.discovery.DeletePrefix(MAILBOX_DIR)
 }
 
+// Deprecated: 使用GetMail, 默认为player mailbox
 func (m *MailBox) Get(Id int64) *rpc.MailBox {
+	return m.GetMail(rpc.MAIL_Player, Id)
+}
+
+func (m *MailBox) GetMail(mailType rpc.MAIL, Id int64) *rpc.MailBox {
 	m.mailBoxLocker.RLock()
-	mail, bEx := m.mailBoxMap[Id]
+	mail, bEx := m.mailBoxMap[mailType][Id]
 	m.mailBoxLocker.RUnlock()
 	if bEx {
 		return mail
@@ -110,11 +271,52 @@ func (m *MailBox) Get(Id int64) *rpc.MailBox {
 	return nil
 }
 
+// mailbox id到持有者集群id
+func (m *MailBox) Owners(mailType rpc.MAIL) map[int64]uint32 {
+	m.mailBoxLocker.RLock()
+	defer m.mailBoxLocker.RUnlock()
+	owners := make(map[int64]uint32, len(m.mailBoxMap[mailType]))
+	for id, mail := range m.mailBoxMap[mailType] {
+		owners[id] = mail.ClusterId
+	}
+	return owners
+}
+
+func (m *MailBox) isOwner(mail *rpc.MailBox) bool {
+	return mail.ClusterId == m.Id() && discovery.LeaseID(mail.LeaseId) == m.session.Lease()
+}
+
+// 等待mailbox删除
+func (m *MailBox) wait(key mailBoxKey) chan bool {
+	ch := make(chan bool)
+	m.mailBoxLocker.Lock()
+	m.waitMap[key] = append(m.waitMap[key], ch)
+	m.mailBoxLocker.Unlock()
+	return ch
+}
+
+func (m *MailBox) unWait(key mailBoxKey, ch chan bool) {
+	m.mailBoxLocker.Lock()
+	defer m.mailBoxLocker.Unlock()
+	for i, v := range m.waitMap[key] {
+		if v == ch {
+			m.waitMap[key] = append(m.waitMap[key][:i], m.waitMap[key][i+1:]...)
+			break
+		}
+	}
+	if len(m.waitMap[key]) == 0 {
+		delete(m.waitMap, key)
+	}
+}
+
 func (m *MailBox) add(info *rpc.MailBox) {
 	m.mailBoxLocker.Lock()
-	mail, bOk := m.mailBoxMap[info.Id]
+	if m.mailBoxMap[info.MailType] == nil {
+		m.mailBoxMap[info.MailType] = make(MailBoxMap)
+	}
+	mail, bOk := m.mailBoxMap[info.MailType][info.Id]
 	if !bOk {
-		m.mailBoxMap[info.Id] = info
+		m.mailBoxMap[info.MailType][info.Id] = info
 	} else {
 		*mail = *info
 	}
@@ -123,19 +325,22 @@ func (m *MailBox) add(info *rpc.MailBox) {
 
 func (m *MailBox) del(info *rpc.MailBox) {
 	m.mailBoxLocker.Lock()
-	delete(m.mailBoxMap, int64(info.Id))
+	delete(m.mailBoxMap[info.MailType], info.Id)
+	key := mailBoxKey{info.MailType, info.Id}
+	for _, ch := range m.waitMap[key] {
+		close(ch)
+	}
+	delete(m.waitMap, key)
 	m.mailBoxLocker.Unlock()
 	actor.MGR.SendMsg(rpc.RpcHead{Id: info.Id}, fmt.Sprintf("%s.OnUnRegister", info.MailType.String()))
 }
 
-func (m *MailBox) getAll() {
-	resp, err := m.client.Get(context.Background(), MAILBOX_DIR, clientv3.WithPrefix())
-	if err == nil && (resp != nil && resp.Kvs != nil) {
-		for _, v := range resp.Kvs {
-			info := nodeToMailBox(v.Value)
-			m.add(info)
-		}
+// 旧版本节点只有player mailbox, key为mailbox/<Id>
+func (m *MailBox) mailKey(dir string, mailType rpc.MAIL, Id int64) string {
+	if m.conf.LegacyKey && mailType == rpc.MAIL_Player {
+		return fmt.Sprintf("%s%d", dir, Id)
 	}
+	return fmt.Sprintf("%s%s/%d", dir, mailType.String(), Id)
 }
 
 func nodeToMailBox(val []byte) *rpc.MailBox {
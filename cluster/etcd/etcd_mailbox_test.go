@@ -0,0 +1,85 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+func TestMailBoxKey(t *testing.T) {
+	tests := []struct {
+		conf     *common.MailBox
+		mailType rpc.MAIL
+		key      string
+	}{
+		{nil, rpc.MAIL_Player, "mailbox/Player/7"},
+		{nil, rpc.MAIL_Guild, "mailbox/Guild/7"},
+		{&common.MailBox{LegacyKey: true}, rpc.MAIL_Player, "mailbox/7"},
+		{&common.MailBox{LegacyKey: true}, rpc.MAIL_Guild, "mailbox/Guild/7"},
+	}
+	for _, tt := range tests {
+		d := discovery.NewMemory()
+		m := &MailBox{}
+		m.InitConf(&common.ClusterInfo{Ip: "127.0.0.1", Port: 1}, d, tt.conf)
+		waitFor(t, time.Second, func() bool { return m.Session().Lease() != 0 })
+		if !m.Create(&rpc.MailBox{MailType: tt.mailType, Id: 7}) {
+			t.Fatalf("%v create %s failed", tt.conf, tt.mailType)
+		}
+		if kvs, _, _ := d.Get(tt.key); len(kvs) != 1 {
+			t.Fatalf("%v key %s not found", tt.conf, tt.key)
+		}
+		//缓存按value解析, 旧版key同样可查
+		waitFor(t, time.Second, func() bool { return m.GetMail(tt.mailType, 7) != nil })
+		if err := m.DeleteMail(tt.mailType, 7); err != nil {
+			t.Fatal(err)
+		}
+		m.Session().Close()
+		d.Close()
+	}
+}
+
+// 旧接口默认player mailbox和旧版key
+func TestMailBoxDeprecated(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	m := &MailBox{}
+	m.Init(&common.ClusterInfo{Ip: "127.0.0.1", Port: 1}, d)
+	defer m.Session().Close()
+	waitFor(t, time.Second, func() bool { return m.Session().Lease() != 0 })
+	if !m.Create(&rpc.MailBox{Id: 7}) {
+		t.Fatal("create failed")
+	}
+	if kvs, _, _ := d.Get("mailbox/7"); len(kvs) != 1 {
+		t.Fatal("legacy key not found")
+	}
+	waitFor(t, time.Second, func() bool { return m.Get(7) != nil })
+	if err := m.Delete(7); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return m.Get(7) == nil })
+}
+
+func TestMailBoxLost(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	m := &MailBox{}
+	m.InitConf(&common.ClusterInfo{Ip: "127.0.0.1", Port: 1}, d, &common.MailBox{TTL: 3})
+	waitFor(t, time.Second, func() bool { return m.Session().Lease() != 0 })
+	if !m.Create(&rpc.MailBox{MailType: rpc.MAIL_Player, Id: 1}) {
+		t.Fatal("create failed")
+	}
+	lease := m.Session().Lease()
+	d.Revoke(lease)
+	//重新授予租约后, 旧租约上的mailbox不再属于本节点
+	waitFor(t, 2*time.Second, func() bool {
+		l := m.Session().Lease()
+		return l != 0 && l != lease
+	})
+	waitFor(t, time.Second, func() bool { return m.GetMail(rpc.MAIL_Player, 1) == nil })
+	if err := m.DeleteMail(rpc.MAIL_Player, 1); err != ErrMailBoxNotOwner {
+		t.Fatalf("delete after lost %v, want %v", err, ErrMailBoxNotOwner)
+	}
+}
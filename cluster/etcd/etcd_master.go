@@ -1,14 +1,13 @@
 package etcd
 
 import (
-	"context"
 	"encoding/json"
 	"log"
 
 	"github.com/fengqk/mars-base/actor"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/rpc"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const (
@@ -18,52 +17,33 @@ const (
 type (
 	Master struct {
 		common.IClusterInfo
-		client *clientv3.Client
+		discovery discovery.Discovery
+		cache     *discovery.Cache
 	}
 )
 
-func (m *Master) Init(info common.IClusterInfo, endpoints []string) {
-	cfg := clientv3.Config{
-		Endpoints: endpoints,
-	}
-
-	etcdClient, err := clientv3.New(cfg)
-	if err != nil {
-		log.Fatal("cannot connect to etcd:", err)
-	}
-
-	m.client = etcdClient
+func (m *Master) Init(info common.IClusterInfo, d discovery.Discovery) {
+	m.discovery = d
 	m.IClusterInfo = info
 	m.Start()
-	m.InitServices()
 }
 
 func (m *Master) Start() {
-	go m.Run()
+	m.cache = discovery.NewCache(m.discovery, ETCD_DIR+m.String(), m.handle)
 }
 
-func (m *Master) Run() {
-	wch := m.client.Watch(context.Background(), ETCD_DIR+m.String(), clientv3.WithPrefix(), clientv3.WithPrevKV())
-	for v := range wch {
-		for _, v1 := range v.Events {
-			if v1.Type.String() == "PUT" {
-				info := nodeToService(v1.Kv.Value)
-				m.addService(info)
-			} else {
-				info := nodeToService(v1.PrevKv.Value)
-				m.delService(info)
-			}
-		}
-	}
+// 服务列表已与注册中心同步
+func (m *Master) Synced() bool {
+	return m.cache.Synced()
 }
 
-func (m *Master) InitServices() {
-	resp, err := m.client.Get(context.Background(), ETCD_DIR, clientv3.WithPrefix())
-	if err == nil && (resp != nil && resp.Kvs != nil) {
-		for _, v := range resp.Kvs {
-			info := nodeToService(v.Value)
-			m.addService(info)
-		}
+func (m *Master) handle(event *discovery.Event) {
+	if event.Type == discovery.EVENT_PUT {
+		info := nodeToService(event.Kv.Value)
+		m.addService(info)
+	} else {
+		info := nodeToService(event.PrevKv.Value)
+		m.delService(info)
 	}
 }
 
@@ -1,72 +1,151 @@
 package etcd
 
 import (
-	"context"
 	"encoding/json"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/common"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	SERVICE_TTL_TIME    = 10
+	SERVICE_REPORT_TIME = 5 * time.Second //负载上报间隔
 )
 
 type (
 	Service struct {
 		*common.ClusterInfo
-		client  *clientv3.Client
-		lease   clientv3.Lease
-		leaseId clientv3.LeaseID
-		status  STATUS
+		discovery discovery.Discovery
+		session   *discovery.Session
+		dirty     bool
+		locker    *sync.Mutex
+		closeChan chan bool
 	}
 )
 
-func (s *Service) Init(info *common.ClusterInfo, endpoints []string) {
-	cfg := clientv3.Config{
-		Endpoints: endpoints,
-	}
-
-	etcdClient, err := clientv3.New(cfg)
-	if err != nil {
-		log.Fatal("cannot connec to etcd:", err)
-	}
-	lease := clientv3.NewLease(etcdClient)
-	s.client = etcdClient
-	s.lease = lease
+// 以starting注册, 切换为ready后才会分配流量
+func (s *Service) Init(info *common.ClusterInfo, d discovery.Discovery) {
+	s.discovery = d
 	s.ClusterInfo = info
+	s.State = rpc.NODE_STARTING
+	s.locker = &sync.Mutex{}
+	s.closeChan = make(chan bool)
 	s.Start()
 }
 
 func (s *Service) Start() {
-	go s.Run()
+	s.session = discovery.NewSession(s.discovery, SERVICE_TTL_TIME)
+	s.session.OnGrant(s.SET)
+	s.session.OnLost(func() {
+		log.Printf("service [%s] session lost, register again", s.Key())
+	})
+	go s.report()
 }
 
-func (s *Service) Run() {
-	for {
-		switch s.status {
-		case SET:
-			s.SET()
-		case TTL:
-			s.TTL()
-		}
-	}
+func (s *Service) Key() string {
+	return ETCD_DIR + s.String() + "/" + s.IpString()
 }
 
-func (s *Service) SET() {
-	leaseResp, _ := s.lease.Grant(context.Background(), 10)
-	s.leaseId = leaseResp.ID
-	key := ETCD_DIR + s.String() + "/" + s.IpString()
+// 注册会话, 服务信息挂在会话租约上
+func (s *Service) Session() *discovery.Session {
+	return s.session
+}
+
+func (s *Service) SET(leaseId discovery.LeaseID) {
+	s.locker.Lock()
 	data, _ := json.Marshal(s.ClusterInfo)
-	s.client.Put(context.Background(), key, string(data), clientv3.WithLease(s.leaseId))
-	s.status = TTL
-	time.Sleep(time.Second * 3)
+	s.dirty = false
+	s.locker.Unlock()
+	if err := s.discovery.Put(s.Key(), string(data), leaseId); err != nil {
+		log.Printf("service [%s] register error %v", s.Key(), err)
+		time.AfterFunc(discovery.SESSION_BACKOFF, func() {
+			if s.session.Lease() == leaseId {
+				s.SET(leaseId)
+			}
+		})
+	}
+}
+
+// 节点状态
+func (s *Service) NodeState() rpc.NODE {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.State
+}
+
+// 修改节点状态, 立即更新注册信息
+func (s *Service) SetNodeState(state rpc.NODE) {
+	s.locker.Lock()
+	s.State = state
+	s.locker.Unlock()
+	if leaseId := s.session.Lease(); leaseId != 0 {
+		s.SET(leaseId)
+	}
+}
+
+// 当前状态为old时修改为state, 返回是否修改
+func (s *Service) SwapNodeState(old, state rpc.NODE) bool {
+	s.locker.Lock()
+	if s.State != old {
+		s.locker.Unlock()
+		return false
+	}
+	s.State = state
+	s.locker.Unlock()
+	if leaseId := s.session.Lease(); leaseId != 0 {
+		s.SET(leaseId)
+	}
+	return true
+}
+
+// 排空节点, 滚动发布前调用, 不再分配新流量, 已有的点对点消息照常投递
+func (s *Service) Drain() {
+	s.SetNodeState(rpc.NODE_DRAINING)
 }
 
-func (s *Service) TTL() {
-	//保持ttl
-	_, err := s.lease.KeepAliveOnce(context.Background(), s.leaseId)
-	if err != nil {
-		s.status = SET
-	} else {
-		time.Sleep(time.Second * 3)
+// 上报负载, 定时写入注册信息, metrics复制后保存, 调用方可继续修改
+func (s *Service) SetLoad(load int64, metrics map[string]int64) {
+	metricMap := make(map[string]int64, len(metrics))
+	for k, v := range metrics {
+		metricMap[k] = v
+	}
+	s.locker.Lock()
+	s.Load = load
+	s.Metrics = metricMap
+	s.dirty = true
+	s.locker.Unlock()
+}
+
+// 停止节点, 先通告stopping再撤销注册
+func (s *Service) Close() {
+	select {
+	case <-s.closeChan:
+		return
+	default:
+		close(s.closeChan)
+	}
+	s.SetNodeState(rpc.NODE_STOPPING)
+	s.session.Close()
+}
+
+func (s *Service) report() {
+	ticker := time.NewTicker(SERVICE_REPORT_TIME)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.locker.Lock()
+			dirty := s.dirty
+			s.locker.Unlock()
+			if leaseId := s.session.Lease(); dirty && leaseId != 0 {
+				s.SET(leaseId)
+			}
+		case <-s.closeChan:
+			return
+		}
 	}
 }
@@ -0,0 +1,74 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+func registered(d discovery.Discovery, key string) *common.ClusterInfo {
+	kvs, _, _ := d.Get(key)
+	if len(kvs) == 0 {
+		return nil
+	}
+	info := &common.ClusterInfo{}
+	json.Unmarshal([]byte(kvs[0].Value), info)
+	return info
+}
+
+func TestServiceState(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	s := &Service{}
+	s.Init(&common.ClusterInfo{Type: rpc.SERVICE_GAME, Ip: "127.0.0.1", Port: 1}, d)
+	waitFor(t, time.Second, func() bool { return registered(d, s.Key()) != nil })
+
+	//注册时为starting, 仅starting状态可swap为ready
+	if info := registered(d, s.Key()); info.State != rpc.NODE_STARTING {
+		t.Fatalf("register state %v", info.State)
+	}
+	if !s.SwapNodeState(rpc.NODE_STARTING, rpc.NODE_READY) || s.SwapNodeState(rpc.NODE_STARTING, rpc.NODE_READY) {
+		t.Fatal("swap from starting")
+	}
+	if info := registered(d, s.Key()); info.State != rpc.NODE_READY {
+		t.Fatalf("swap state %v", info.State)
+	}
+	for _, state := range []rpc.NODE{rpc.NODE_READY, rpc.NODE_DRAINING} {
+		s.SetNodeState(state)
+		if info := registered(d, s.Key()); info.State != state {
+			t.Fatalf("state %v, want %v", info.State, state)
+		}
+	}
+
+	//SetLoad保存副本, 调用方修改不影响注册信息
+	metrics := map[string]int64{"online": 10}
+	s.SetLoad(1, metrics)
+	metrics["online"] = 20
+	if s.Metrics["online"] != 10 {
+		t.Fatalf("metrics shared with caller %v", s.Metrics)
+	}
+
+	//关闭时先通告stopping再注销
+	wch := d.Watch(context.Background(), s.Key(), 0)
+	s.Close()
+	var states []rpc.NODE
+	for events := range wch {
+		for _, event := range events {
+			if event.Type == discovery.EVENT_DELETE {
+				if len(states) == 0 || states[len(states)-1] != rpc.NODE_STOPPING {
+					t.Fatalf("deleted without stopping %v", states)
+				}
+				return
+			}
+			info := &common.ClusterInfo{}
+			json.Unmarshal([]byte(event.Kv.Value), info)
+			states = append(states, info.State)
+		}
+	}
+	t.Fatal("watch closed before delete")
+}
@@ -1,103 +1,201 @@
 package etcd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fengqk/mars-base/base"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"github.com/fengqk/mars-base/cluster/discovery"
 )
 
 const (
-	UUID_DIR = "uuid/"
-	TTL_TIME = 1800
+	UUID_DIR               = "uuid/"
+	UUID_TS_DIR            = "uuidts/" //各机器id预留的时间戳上限, 不挂租约
+	TTL_TIME               = 1800      //宕机后机器id保留时间, 期间不会被其他节点复用
+	SNOWFLAKE_PERSIST_TIME = 3 * time.Second
+	SNOWFLAKE_RESERVE_TIME = 10 * time.Second //每次预留的时间戳长度, 需大于持久化间隔
+	SNOWFLAKE_INIT_TIME    = 30 * time.Second
 )
 
-const (
-	SET STATUS = iota
-	TTL STATUS = iota
+var (
+	ErrWorkeridExhausted = errors.New("snowflake workerid exhausted")
+	ErrSnowflakeTimeout  = errors.New("snowflake init timeout")
 )
 
 type (
-	STATUS uint32
-
+	// 从etcd顺序扫描租用机器id, 并提前持久化时间戳上限, 宕机重启或时钟回拨后从上限继续, 不会重复
+	// 租约丢失后停止分配, 重新租到机器id后恢复
 	Snowflake struct {
-		id      int64
-		client  *clientv3.Client
-		lease   clientv3.Lease
-		leaseId clientv3.LeaseID
-		status  STATUS
+		id        int64
+		discovery discovery.Discovery
+		session   *discovery.Session
+		readyChan chan bool
+		readyOnce sync.Once
+		closeChan chan bool
+		locker    sync.Mutex
 	}
 )
 
-func (s *Snowflake) Init(endpoints []string) {
-	cfg := clientv3.Config{
-		Endpoints: endpoints,
+// 租到机器id后返回, 超时返回ErrSnowflakeTimeout
+func (s *Snowflake) Init(d discovery.Discovery) error {
+	s.id = -1
+	s.discovery = d
+	s.readyChan = make(chan bool)
+	s.closeChan = make(chan bool)
+	s.Start()
+	select {
+	case <-s.readyChan:
+		return nil
+	case <-time.After(SNOWFLAKE_INIT_TIME):
+		return ErrSnowflakeTimeout
+	}
+}
+
+func (s *Snowflake) Start() {
+	s.session = discovery.NewSession(s.discovery, TTL_TIME)
+	s.session.OnGrant(s.grant)
+	s.session.OnLost(func() {
+		//机器id可能已被其他节点租用
+		base.UUID.Revoke()
+		s.locker.Lock()
+		id := s.id
+		s.id = -1
+		s.locker.Unlock()
+		log.Printf("snowflake workerid %d session lost, stop generating", id)
+	})
+	go s.persist()
+}
+
+func (s *Snowflake) Id() int64 {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.id
+}
+
+func (s *Snowflake) Key() string {
+	return s.key(UUID_DIR, s.Id())
+}
+
+func (s *Snowflake) prefix(dir string) string {
+	return fmt.Sprintf("%s%d/", dir, base.UUID.Layout().Datacenter)
+}
+
+func (s *Snowflake) key(dir string, id int64) string {
+	return fmt.Sprintf("%s%d", s.prefix(dir), id)
+}
+
+func (s *Snowflake) grant(leaseId discovery.LeaseID) {
+	for s.session.Lease() == leaseId {
+		id, err := s.acquire(leaseId)
+		if err == nil {
+			s.restore(id)
+			err = s.reserve(id)
+		}
+		if err == nil {
+			base.UUID.Init(id) //设置uuid
+			s.locker.Lock()
+			s.id = id
+			s.locker.Unlock()
+			s.readyOnce.Do(func() {
+				close(s.readyChan)
+			})
+			return
+		}
+		log.Printf("snowflake acquire workerid error %v", err)
+		time.Sleep(discovery.SESSION_BACKOFF)
 	}
+}
 
-	etcdClient, err := clientv3.New(cfg)
+// 从小到大扫描第一个空闲的机器id
+func (s *Snowflake) acquire(leaseId discovery.LeaseID) (int64, error) {
+	kvs, _, err := s.discovery.Get(s.prefix(UUID_DIR))
 	if err != nil {
-		log.Fatal("cannot connec to etcd:", err)
+		return -1, err
 	}
-	lease := clientv3.NewLease(etcdClient)
-	s.id = int64(base.RAND.RandI(1, int(base.WorkeridMax)))
-	s.client = etcdClient
-	s.lease = lease
-	for !s.SET() {
+	usedMap := make(map[int64]bool, len(kvs))
+	for _, kv := range kvs {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(kv.Key, s.prefix(UUID_DIR)), 10, 64); err == nil {
+			usedMap[id] = true
+		}
 	}
-	s.Start()
+
+	max := base.UUID.WorkeridMax()
+	for id := int64(0); id <= max; id++ {
+		if usedMap[id] {
+			continue
+		}
+		//key no exist
+		bOk, err := s.discovery.Create(s.key(UUID_DIR, id), "", leaseId)
+		if err != nil {
+			return -1, err
+		}
+		if bOk {
+			return id, nil
+		}
+	}
+	return -1, ErrWorkeridExhausted
 }
 
-func (s *Snowflake) Start() {
-	go s.Run()
+// 恢复该机器id上次预留的时间戳上限
+func (s *Snowflake) restore(id int64) {
+	kvs, _, err := s.discovery.Get(s.key(UUID_TS_DIR, id))
+	if err != nil || len(kvs) == 0 {
+		return
+	}
+	ts, _ := strconv.ParseInt(string(kvs[0].Value), 10, 64)
+	if now := time.Now().UnixNano() / 1000000; ts > now {
+		log.Printf("snowflake workerid %d clock behind last timestamp %dms", id, ts-now)
+	}
+	base.UUID.Restore(ts)
 }
 
-func (s *Snowflake) Run() {
+func (s *Snowflake) persist() {
+	ticker := time.NewTicker(SNOWFLAKE_PERSIST_TIME)
+	defer ticker.Stop()
 	for {
-		switch s.status {
-		case SET:
-			s.SET()
-		case TTL:
-			s.TTL()
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-s.closeChan:
+			return
 		}
 	}
 }
 
-func (s *Snowflake) Key() string {
-	return UUID_DIR + fmt.Sprintf("%d", s.id)
+// 持有锁写入, 租约丢失后不再写旧机器id的上限
+func (s *Snowflake) save() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.id < 0 {
+		return
+	}
+	if err := s.reserve(s.id); err != nil {
+		log.Printf("snowflake persist timestamp error %v", err)
+	}
 }
 
-func (s *Snowflake) SET() bool {
-	key := s.Key()
-	tx := s.client.Txn(context.Background())
-	//key no exist
-	leaseResp, err := s.lease.Grant(context.Background(), TTL_TIME)
-	if err != nil {
-		return false
+// 持久化时间戳上限成功后才允许使用
+func (s *Snowflake) reserve(id int64) error {
+	ts := time.Now().UnixNano() / 1000000
+	if last := base.UUID.Timestamp(); last > ts {
+		ts = last
 	}
-	s.leaseId = leaseResp.ID
-	tx.If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
-		Then(clientv3.OpPut(key, "", clientv3.WithLease(s.leaseId))).
-		Else()
-	txnRes, err := tx.Commit()
-	if err != nil || !txnRes.Succeeded { //抢锁失败
-		s.id = int64(base.RAND.RandI(1, int(base.WorkeridMax)))
-		return false
+	ts += int64(SNOWFLAKE_RESERVE_TIME / time.Millisecond)
+	if err := s.discovery.Put(s.key(UUID_TS_DIR, id), strconv.FormatInt(ts, 10), 0); err != nil {
+		return err
 	}
-
-	base.UUID.Init(s.id) //设置uuid
-	s.status = TTL
-	return true
+	base.UUID.Reserve(ts)
+	return nil
 }
 
-func (s *Snowflake) TTL() {
-	//保持ttl
-	_, err := s.lease.KeepAliveOnce(context.Background(), s.leaseId)
-	if err != nil {
-		s.status = SET
-	} else {
-		time.Sleep(TTL_TIME / 3)
-	}
+// 停止分配并释放机器id, 已持久化的上限不早于用过的时间戳
+func (s *Snowflake) Close() {
+	close(s.closeChan)
+	base.UUID.Revoke()
+	s.session.Close()
 }
@@ -0,0 +1,46 @@
+package etcd
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/discovery"
+)
+
+func TestSnowflakeReserve(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	//0号已被占用, 顺序租到1号, 并从1号上次预留的上限恢复
+	d.Put("uuid/0/0", "", 0)
+	last := time.Now().UnixNano()/1000000 + 2000
+	d.Put("uuidts/0/1", strconv.FormatInt(last, 10), 0)
+
+	s := &Snowflake{}
+	if err := s.Init(d); err != nil {
+		t.Fatal(err)
+	}
+	if s.Id() != 1 {
+		t.Fatalf("workerid %d, want 1", s.Id())
+	}
+	id, err := base.UUID.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, _, _ := base.UUID.Parse(id); ts < last {
+		t.Fatalf("id timestamp %d before restored %d", ts, last)
+	}
+
+	//持久化的是预留上限, 领先已用的时间戳
+	kvs, _, _ := d.Get("uuidts/0/1")
+	reserved, _ := strconv.ParseInt(string(kvs[0].Value), 10, 64)
+	if reserved <= base.UUID.Timestamp() {
+		t.Fatalf("reserved %d not ahead of used %d", reserved, base.UUID.Timestamp())
+	}
+
+	s.Close()
+	if _, err := base.UUID.NextId(); err != base.ErrWorkeridRevoked {
+		t.Fatalf("NextId after close error %v, want %v", err, base.ErrWorkeridRevoked)
+	}
+}
@@ -0,0 +1,46 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+	"gopkg.in/yaml.v2"
+)
+
+// 从yaml文件加载静态集群, 注册到内存服务发现, 用于单机开发无需etcd
+//
+//	namespace: dev #与集群的命名空间一致
+//	services:
+//	  - {type: gate, ip: 127.0.0.1, port: 31000}
+//	  - {type: game, ip: 127.0.0.1, port: 32000, weight: 2}
+func NewStatic(path string) (*discovery.Memory, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := common.Static{}
+	err = yaml.Unmarshal(content, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	d := discovery.NewMemory()
+	nd := discovery.NewNamespace(d, conf.Namespace)
+	for _, v := range conf.Services {
+		serviceType, bEx := rpc.SERVICE_value[strings.ToUpper(v.Type)]
+		if !bEx {
+			d.Close()
+			return nil, fmt.Errorf("static service type [%s] not found", v.Type)
+		}
+		info := &common.ClusterInfo{Type: rpc.SERVICE(serviceType), Ip: v.Ip, Port: v.Port, Weight: v.Weight}
+		data, _ := json.Marshal(info)
+		nd.Put(ETCD_DIR+info.String()+"/"+info.IpString(), string(data), 0)
+	}
+	return d, nil
+}
@@ -1,95 +1,185 @@
 package etcd
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster/discovery"
 	"github.com/fengqk/mars-base/common"
 	"github.com/fengqk/mars-base/rpc"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const (
-	STUB_DIR      = "stub/"
-	STUB_TTL_TIME = 30
+	STUB_DIR       = "stub/"
+	STUB_NODE_DIR  = "stubnode/"  //参与stub分配的节点
+	STUB_COUNT_DIR = "stubcount/" //运行时修改的stub数量, 覆盖配置
+	STUB_TTL_TIME  = 10
+)
+
+var (
+	ErrStubNotOwner = errors.New("stub not owner")
 )
 
 type (
 	StubMailBoxMap map[int64]*common.StubMailBox
+	StubNodeMap    map[uint32]bool
 
 	StubMailBox struct {
 		*common.ClusterInfo
-		client            *clientv3.Client
-		lease             clientv3.Lease
+		discovery         discovery.Discovery
 		stubMailBoxMap    [rpc.STUB_END]StubMailBoxMap
+		stubNodeMap       [rpc.STUB_END]StubNodeMap
+		stubCount         [rpc.STUB_END]int64
 		stubMailBoxLocker [rpc.STUB_END]*sync.RWMutex
+		cache             *discovery.Cache
+		nodeCache         *discovery.Cache
+		countCache        *discovery.Cache
+		changeList        []func(rpc.STUB)
+		changeLocker      sync.RWMutex
 	}
 )
 
+// 创建stub, info.LeaseId为0时申请新租约
 func (s *StubMailBox) Create(info *common.StubMailBox) bool {
-	leaseResp, err := s.lease.Grant(context.Background(), STUB_TTL_TIME)
-	if err == nil {
-		leaseId := leaseResp.ID
+	leaseId := discovery.LeaseID(info.LeaseId)
+	if leaseId == 0 {
+		var err error
+		leaseId, err = s.discovery.Grant(STUB_TTL_TIME)
+		if err != nil {
+			return false
+		}
 		info.LeaseId = int64(leaseId)
-		key := fmt.Sprintf("%s%s", STUB_DIR, info.Key())
-		data, _ := json.Marshal(info)
-		//设置key
-		tx := s.client.Txn(context.Background())
-		tx.If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
-			Then(clientv3.OpPut(key, string(data), clientv3.WithLease(leaseId))).
-			Else()
-		txnRes, err := tx.Commit()
-		return err == nil && txnRes.Succeeded
 	}
-	return false
+	key := fmt.Sprintf("%s%s", STUB_DIR, info.Key())
+	data, _ := json.Marshal(info)
+	//设置key
+	bOk, err := s.discovery.Create(key, string(data), leaseId)
+	return err == nil && bOk
 }
 
-func (s *StubMailBox) Init(info *common.ClusterInfo, endpoints []string) {
-	cfg := clientv3.Config{
-		Endpoints: endpoints,
+// 释放stub, 只删除挂在info.LeaseId上的key, 已被其他节点接管时返回ErrStubNotOwner
+func (s *StubMailBox) Delete(info *common.StubMailBox) error {
+	bOk, err := s.discovery.DeleteOwned(fmt.Sprintf("%s%s", STUB_DIR, info.Key()), discovery.LeaseID(info.LeaseId))
+	if err != nil {
+		return err
+	}
+	if !bOk {
+		return ErrStubNotOwner
 	}
+	return nil
+}
 
-	etcdClient, err := clientv3.New(cfg)
-	if err != nil {
-		log.Fatal("cannot connec to etcd:", err)
+// 登记参与stub分配
+func (s *StubMailBox) Join(stubType rpc.STUB, clusterId uint32, leaseId discovery.LeaseID) error {
+	return s.discovery.Put(stubNodeKey(stubType, clusterId), strconv.FormatUint(uint64(clusterId), 10), leaseId)
+}
+
+// 修改stub数量, 所有节点按新数量路由并重新分配, count不大于0时恢复配置
+func (s *StubMailBox) SetCount(stubType rpc.STUB, count int64) error {
+	if count <= 0 {
+		return s.discovery.Delete(STUB_COUNT_DIR + stubType.String())
 	}
+	return s.discovery.Put(STUB_COUNT_DIR+stubType.String(), strconv.FormatInt(count, 10), 0)
+}
+
+// 运行时设置的stub数量, 未设置返回false
+func (s *StubMailBox) GetCount(stubType rpc.STUB) (int64, bool) {
+	s.stubMailBoxLocker[stubType].RLock()
+	defer s.stubMailBoxLocker[stubType].RUnlock()
+	return s.stubCount[stubType], s.stubCount[stubType] > 0
+}
+
+// 退出stub分配
+func (s *StubMailBox) Leave(stubType rpc.STUB, clusterId uint32) error {
+	return s.discovery.Delete(stubNodeKey(stubType, clusterId))
+}
+
+func (s *StubMailBox) Init(info *common.ClusterInfo, d discovery.Discovery) {
 	s.ClusterInfo = info
-	lease := clientv3.NewLease(etcdClient)
-	s.client = etcdClient
-	s.lease = lease
+	s.discovery = d
 	for i := 0; i < int(rpc.STUB_END); i++ {
 		s.stubMailBoxLocker[i] = &sync.RWMutex{}
 		s.stubMailBoxMap[i] = make(StubMailBoxMap)
+		s.stubNodeMap[i] = make(StubNodeMap)
 	}
 	s.Start()
 }
 
 func (s *StubMailBox) Start() {
-	go s.Run()
-}
-
-func (s *StubMailBox) Run() {
-	wch := s.client.Watch(context.Background(), STUB_DIR, clientv3.WithPrefix(), clientv3.WithPrevKV())
-	s.getAll()
-	for v := range wch {
-		for _, v1 := range v.Events {
-			if v1.Type.String() == "PUT" {
-				info := nodeToStubMailBox(v1.Kv.Value)
-				s.add(info)
-			} else {
-				info := nodeToStubMailBox(v1.PrevKv.Value)
-				s.del(info)
-			}
-		}
+	s.cache = discovery.NewCache(s.discovery, STUB_DIR, s.handle)
+	s.nodeCache = discovery.NewCache(s.discovery, STUB_NODE_DIR, s.handleNode)
+	s.countCache = discovery.NewCache(s.discovery, STUB_COUNT_DIR, s.handleCount)
+}
+
+func (s *StubMailBox) Discovery() discovery.Discovery {
+	return s.discovery
+}
+
+// stub, 参与节点和数量均已与注册中心同步
+func (s *StubMailBox) Synced() bool {
+	return s.cache.Synced() && s.nodeCache.Synced() && s.countCache.Synced()
+}
+
+func (s *StubMailBox) handle(event *discovery.Event) {
+	var info *common.StubMailBox
+	if event.Type == discovery.EVENT_PUT {
+		info = nodeToStubMailBox(event.Kv.Value)
+		s.add(info)
+	} else {
+		info = nodeToStubMailBox(event.PrevKv.Value)
+		s.del(info)
 	}
+	s.notify(info.StubType)
 }
 
+func (s *StubMailBox) handleNode(event *discovery.Event) {
+	stubType, clusterId, bOk := parseStubNodeKey(event.Kv.Key)
+	if !bOk {
+		return
+	}
+	s.stubMailBoxLocker[stubType].Lock()
+	if event.Type == discovery.EVENT_PUT {
+		s.stubNodeMap[stubType][clusterId] = true
+	} else {
+		delete(s.stubNodeMap[stubType], clusterId)
+	}
+	s.stubMailBoxLocker[stubType].Unlock()
+	s.notify(stubType)
+}
+
+func (s *StubMailBox) handleCount(event *discovery.Event) {
+	val := ""
+	if event.Type == discovery.EVENT_PUT {
+		val = string(event.Kv.Value)
+	}
+	if stubType, bOk := s.setCount(event.Kv.Key, val); bOk {
+		s.notify(stubType)
+	}
+}
+
+func (s *StubMailBox) setCount(key string, val string) (rpc.STUB, bool) {
+	stubType, bEx := rpc.STUB_value[strings.TrimPrefix(key, STUB_COUNT_DIR)]
+	if !bEx || stubType >= int32(rpc.STUB_END) {
+		return 0, false
+	}
+	count, _ := strconv.ParseInt(val, 10, 64)
+	s.stubMailBoxLocker[stubType].Lock()
+	s.stubCount[stubType] = count
+	s.stubMailBoxLocker[stubType].Unlock()
+	log.Printf("stub [%s]数量变为[%d]", rpc.STUB(stubType).String(), count)
+	return rpc.STUB(stubType), true
+}
+
+// 续约, 兼容自行申请租约的stub
 func (s *StubMailBox) Lease(info *common.StubMailBox) error {
-	_, err := s.lease.KeepAliveOnce(context.Background(), clientv3.LeaseID(info.LeaseId))
-	return err
+	return s.discovery.KeepAliveOnce(discovery.LeaseID(info.LeaseId))
 }
 
 func (s *StubMailBox) Get(stubType rpc.STUB, Id int64) *common.StubMailBox {
@@ -109,6 +199,71 @@ func (s *StubMailBox) Count(stubType rpc.STUB) int64 {
 	return int64(nLen)
 }
 
+// stub id到集群id的归属
+func (s *StubMailBox) Owners(stubType rpc.STUB) map[int64]uint32 {
+	s.stubMailBoxLocker[stubType].RLock()
+	defer s.stubMailBoxLocker[stubType].RUnlock()
+	owners := make(map[int64]uint32, len(s.stubMailBoxMap[stubType]))
+	for id, stub := range s.stubMailBoxMap[stubType] {
+		owners[id] = stub.ClusterId
+	}
+	return owners
+}
+
+// 计算clusterId应认领的空闲stub id, 没有返回-1
+// 空闲id从小到大依次分给得分最高的未持有stub的节点, 各节点视图一致时结果一致
+func (s *StubMailBox) Assign(stubType rpc.STUB, stubCount int64, clusterId uint32) int64 {
+	s.stubMailBoxLocker[stubType].RLock()
+	defer s.stubMailBoxLocker[stubType].RUnlock()
+	ownerMap := make(map[uint32]bool)
+	for _, stub := range s.stubMailBoxMap[stubType] {
+		ownerMap[stub.ClusterId] = true
+	}
+	if ownerMap[clusterId] {
+		return -1
+	}
+	standby := []uint32{}
+	for id := range s.stubNodeMap[stubType] {
+		if !ownerMap[id] {
+			standby = append(standby, id)
+		}
+	}
+	sort.Slice(standby, func(i, j int) bool {
+		return standby[i] < standby[j]
+	})
+	for id := int64(0); id < stubCount && len(standby) > 0; id++ {
+		if _, bEx := s.stubMailBoxMap[stubType][id]; bEx {
+			continue
+		}
+		best := 0
+		for i := 1; i < len(standby); i++ {
+			if stubScore(id, standby[i]) > stubScore(id, standby[best]) {
+				best = i
+			}
+		}
+		if standby[best] == clusterId {
+			return id
+		}
+		standby = append(standby[:best], standby[best+1:]...)
+	}
+	return -1
+}
+
+// stub或参与节点变化时回调, 在watch协程执行, 不能阻塞
+func (s *StubMailBox) OnChange(fun func(rpc.STUB)) {
+	s.changeLocker.Lock()
+	s.changeList = append(s.changeList, fun)
+	s.changeLocker.Unlock()
+}
+
+func (s *StubMailBox) notify(stubType rpc.STUB) {
+	s.changeLocker.RLock()
+	defer s.changeLocker.RUnlock()
+	for _, fun := range s.changeList {
+		fun(stubType)
+	}
+}
+
 func (s *StubMailBox) add(info *common.StubMailBox) {
 	s.stubMailBoxLocker[info.StubType].Lock()
 	stub, bOk := s.stubMailBoxMap[info.StubType][info.Id]
@@ -126,16 +281,6 @@ func (s *StubMailBox) del(info *common.StubMailBox) {
 	s.stubMailBoxLocker[info.StubType].Unlock()
 }
 
-func (s *StubMailBox) getAll() {
-	resp, err := s.client.Get(context.Background(), STUB_DIR, clientv3.WithPrefix())
-	if err == nil && (resp != nil && resp.Kvs != nil) {
-		for _, v := range resp.Kvs {
-			info := nodeToStubMailBox(v.Value)
-			s.add(info)
-		}
-	}
-}
-
 func nodeToStubMailBox(val []byte) *common.StubMailBox {
 	info := &common.StubMailBox{}
 	err := json.Unmarshal([]byte(val), info)
@@ -144,3 +289,27 @@ func nodeToStubMailBox(val []byte) *common.StubMailBox {
 	}
 	return info
 }
+
+func stubNodeKey(stubType rpc.STUB, clusterId uint32) string {
+	return fmt.Sprintf("%s%s/%d", STUB_NODE_DIR, stubType.String(), clusterId)
+}
+
+func parseStubNodeKey(key string) (rpc.STUB, uint32, bool) {
+	strs := strings.Split(strings.TrimPrefix(key, STUB_NODE_DIR), "/")
+	if len(strs) != 2 {
+		return 0, 0, false
+	}
+	stubType, bEx := rpc.STUB_value[strs[0]]
+	if !bEx || stubType >= int32(rpc.STUB_END) {
+		return 0, 0, false
+	}
+	clusterId, err := strconv.ParseUint(strs[1], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return rpc.STUB(stubType), uint32(clusterId), true
+}
+
+func stubScore(id int64, clusterId uint32) uint32 {
+	return base.ToHash(fmt.Sprintf("%d/%d", id, clusterId))
+}
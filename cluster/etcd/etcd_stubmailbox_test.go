@@ -0,0 +1,34 @@
+package etcd
+
+import (
+	"testing"
+
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+func TestStubMailBoxDeleteOwned(t *testing.T) {
+	d := discovery.NewMemory()
+	defer d.Close()
+	s := &StubMailBox{}
+	s.Init(&common.ClusterInfo{Ip: "127.0.0.1", Port: 1}, d)
+
+	info := &common.StubMailBox{}
+	info.StubType, info.Id = rpc.STUB(0), 1
+	if !s.Create(info) {
+		t.Fatal("create stub failed")
+	}
+	//旧持有者用过期的租约释放, 不能删掉新持有者的stub
+	stale := &common.StubMailBox{}
+	stale.StubType, stale.Id, stale.LeaseId = info.StubType, info.Id, info.LeaseId+1
+	if err := s.Delete(stale); err != ErrStubNotOwner {
+		t.Fatalf("stale delete %v, want %v", err, ErrStubNotOwner)
+	}
+	if err := s.Delete(info); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _, _ := d.Get(STUB_DIR + info.Key()); len(kvs) != 0 {
+		t.Fatal("stub not released")
+	}
+}
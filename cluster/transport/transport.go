@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fengqk/mars-base/common"
+)
+
+const (
+	TRANSPORT_NATS  = "nats"  //nats, 默认
+	TRANSPORT_LOCAL = "local" //进程内回环
+	TRANSPORT_TCP   = "tcp"   //tcp直连网格
+)
+
+var (
+	ErrTimeout      = errors.New("transport timeout")
+	ErrNoResponders = errors.New("transport no responders")
+	ErrClosed       = errors.New("transport closed")
+)
+
+type (
+	// reply不为空时表示请求, 处理方需向reply回包
+	MsgHandler func(data []byte, reply string)
+
+	// 集群传输层, 按subject发布订阅
+	Transport interface {
+		Subscribe(subject string, handler MsgHandler) error
+		Publish(subject string, data []byte) error
+		Request(subject string, data []byte, timeout time.Duration) ([]byte, error)
+		Close() error
+	}
+
+	// 需要感知集群成员的传输层, 如tcp网格
+	PeerTransport interface {
+		Transport
+		AddPeer(info *common.ClusterInfo)
+		DelPeer(info *common.ClusterInfo)
+	}
+)
+
+// 按配置创建传输层
+func New(conf *common.Transport, info *common.ClusterInfo) (Transport, error) {
+	switch conf.Type {
+	case "", TRANSPORT_NATS:
+		return NewNats(conf.Endpoints, nil)
+	case TRANSPORT_LOCAL:
+		return NewLoopback(), nil
+	case TRANSPORT_TCP:
+		return NewMesh(info, conf.PortOffset, conf.Secret), nil
+	}
+	return nil, fmt.Errorf("transport type [%s] not found", conf.Type)
+}
@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+)
+
+const (
+	INBOX_PREFIX    = "_INBOX/" //request回包subject前缀
+	MAX_PENDING_MSG = 8192      //订阅未处理消息上限, 超过丢弃
+)
+
+type (
+	message struct {
+		data  []byte
+		reply string
+	}
+
+	subscriber struct {
+		subject string
+		handler MsgHandler
+		msgChan chan message
+	}
+
+	// 本进程订阅表, 每个订阅一个协程顺序处理
+	subMap struct {
+		subMap    map[string][]*subscriber
+		inboxMap  map[string]chan []byte
+		locker    *sync.RWMutex
+		closeChan chan bool
+	}
+
+	// 进程内回环, 用于测试和单进程部署
+	Loopback struct {
+		subs      *subMap
+		inboxSeed uint32
+	}
+)
+
+func newSubMap() *subMap {
+	return &subMap{
+		subMap:    make(map[string][]*subscriber),
+		inboxMap:  make(map[string]chan []byte),
+		locker:    &sync.RWMutex{},
+		closeChan: make(chan bool),
+	}
+}
+
+func (s *subMap) add(subject string, handler MsgHandler) {
+	sub := &subscriber{subject: subject, handler: handler, msgChan: make(chan message, MAX_PENDING_MSG)}
+	s.locker.Lock()
+	s.subMap[subject] = append(s.subMap[subject], sub)
+	s.locker.Unlock()
+	go sub.run(s.closeChan)
+}
+
+func (s *subMap) subjects() []string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	subjects := make([]string, 0, len(s.subMap))
+	for subject := range s.subMap {
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// 投递到本进程订阅, 返回接收者数量
+func (s *subMap) publish(subject string, data []byte, reply string) int {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	if strings.HasPrefix(subject, INBOX_PREFIX) {
+		replyChan, bEx := s.inboxMap[subject]
+		if !bEx {
+			return 0
+		}
+		select {
+		case replyChan <- data:
+		default:
+		}
+		return 1
+	}
+
+	subs := s.subMap[subject]
+	for _, sub := range subs {
+		select {
+		case sub.msgChan <- message{data: data, reply: reply}:
+		default:
+			base.LOG.Printf("transport subject [%s] slow consumer, message dropped", subject)
+		}
+	}
+	return len(subs)
+}
+
+func (s *subMap) addInbox(inbox string) chan []byte {
+	replyChan := make(chan []byte, 1)
+	s.locker.Lock()
+	s.inboxMap[inbox] = replyChan
+	s.locker.Unlock()
+	return replyChan
+}
+
+func (s *subMap) delInbox(inbox string) {
+	s.locker.Lock()
+	delete(s.inboxMap, inbox)
+	s.locker.Unlock()
+}
+
+func (s *subMap) close() error {
+	select {
+	case <-s.closeChan:
+		return ErrClosed
+	default:
+		close(s.closeChan)
+	}
+	return nil
+}
+
+// 发起请求并等待回包, send返回接收者数量
+func (s *subMap) request(inbox string, send func(reply string) int, timeout time.Duration) ([]byte, error) {
+	replyChan := s.addInbox(inbox)
+	defer s.delInbox(inbox)
+	if send(inbox) == 0 {
+		return nil, ErrNoResponders
+	}
+
+	select {
+	case data := <-replyChan:
+		return data, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	case <-s.closeChan:
+		return nil, ErrClosed
+	}
+}
+
+func (s *subscriber) run(closeChan chan bool) {
+	for {
+		select {
+		case msg := <-s.msgChan:
+			s.handle(msg)
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+func (s *subscriber) handle(msg message) {
+	defer func() {
+		if err := recover(); err != nil {
+			base.TraceCode(err)
+		}
+	}()
+	s.handler(msg.data, msg.reply)
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{subs: newSubMap()}
+}
+
+func (l *Loopback) Subscribe(subject string, handler MsgHandler) error {
+	l.subs.add(subject, handler)
+	return nil
+}
+
+func (l *Loopback) Publish(subject string, data []byte) error {
+	l.subs.publish(subject, append([]byte(nil), data...), "")
+	return nil
+}
+
+func (l *Loopback) Request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	inbox := fmt.Sprintf("%s%d", INBOX_PREFIX, atomic.AddUint32(&l.inboxSeed, 1))
+	return l.subs.request(inbox, func(reply string) int {
+		return l.subs.publish(subject, append([]byte(nil), data...), reply)
+	}, timeout)
+}
+
+func (l *Loopback) Close() error {
+	return l.subs.close()
+}
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoopbackSubscribe(t *testing.T) {
+	l := NewLoopback()
+	defer l.Close()
+	recvChan := make(chan []byte, 2)
+	for i := 0; i < 2; i++ {
+		l.Subscribe("game", func(data []byte, reply string) {
+			recvChan <- data
+		})
+	}
+	data := []byte("hello")
+	l.Publish("game", data)
+	data[0] = 'x' //发布后修改不影响订阅方
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-recvChan:
+			if string(v) != "hello" {
+				t.Fatalf("recv %s", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("subscriber not called")
+		}
+	}
+	l.Publish("gate", data)
+	select {
+	case v := <-recvChan:
+		t.Fatalf("unexpected %s", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLoopbackRequest(t *testing.T) {
+	l := NewLoopback()
+	defer l.Close()
+	l.Subscribe("echo", func(data []byte, reply string) {
+		l.Publish(reply, append([]byte("re:"), data...))
+	})
+	l.Subscribe("mute", func(data []byte, reply string) {})
+
+	tests := []struct {
+		subject string
+		reply   string
+		err     error
+	}{
+		{"echo", "re:ping", nil},
+		{"mute", "", ErrTimeout},
+		{"none", "", ErrNoResponders},
+	}
+	for _, test := range tests {
+		reply, err := l.Request(test.subject, []byte("ping"), 100*time.Millisecond)
+		if err != test.err || string(reply) != test.reply {
+			t.Errorf("request [%s] = %q, %v, want %q, %v", test.subject, reply, err, test.reply, test.err)
+		}
+	}
+	//回包后inbox已注销
+	if n := len(l.subs.inboxMap); n != 0 {
+		t.Fatalf("inbox leak %d", n)
+	}
+}
+
+func TestLoopbackClose(t *testing.T) {
+	l := NewLoopback()
+	l.Subscribe("mute", func(data []byte, reply string) {})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Close()
+	}()
+	if _, err := l.Request("mute", nil, time.Second); err != ErrClosed {
+		t.Fatalf("request after close %v", err)
+	}
+	if err := l.Close(); err != ErrClosed {
+		t.Fatalf("close twice %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/network"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+const (
+	MESH_MAGIC          = 0xfe        //网格帧标识, 区分socket内部消息
+	MESH_RECONNECT_TIME = time.Second //断线重连间隔
+)
+
+const (
+	MESH_HELLO = iota //建立连接, 携带本节点ClusterInfo和签名
+	MESH_SUB   = iota //通告订阅
+	MESH_MSG   = iota //消息
+)
+
+type (
+	meshPeer struct {
+		info       *common.ClusterInfo
+		client     *network.ClientSocket
+		socketId   uint32 //对端连入本节点的socket
+		connecting int32
+	}
+
+	// 握手包, Sign为共享密钥对Info的hmac-sha256
+	meshHello struct {
+		Info json.RawMessage `json:"info"`
+		Sign string          `json:"sign"`
+	}
+
+	// tcp直连网格, 每个节点监听服务端口+偏移
+	// 节点只通过自己发起的连接发送, 通过连入的连接接收对端的订阅和消息
+	// 连入的连接握手校验通过后才接受订阅和消息
+	Mesh struct {
+		info        *common.ClusterInfo
+		portOffset  int32
+		secret      []byte
+		server      *network.ServerSocket
+		subs        *subMap
+		peerMap     map[uint32]*meshPeer
+		socketMap   map[uint32]uint32          //socketId->peerId
+		interestMap map[string]map[uint32]bool //subject->peerId
+		locker      *sync.RWMutex
+		inboxSeed   uint32
+	}
+)
+
+// secret为空时能连上端口的节点都可加入, 只应在可信网络中使用
+func NewMesh(info *common.ClusterInfo, portOffset int32, secret string) *Mesh {
+	if secret == "" {
+		base.LOG.Printf("mesh secret not set, any node reaching port %d can join", info.Port+portOffset)
+	}
+	m := &Mesh{
+		info:        info,
+		portOffset:  portOffset,
+		secret:      []byte(secret),
+		server:      &network.ServerSocket{},
+		subs:        newSubMap(),
+		peerMap:     make(map[uint32]*meshPeer),
+		socketMap:   make(map[uint32]uint32),
+		interestMap: make(map[string]map[uint32]bool),
+		locker:      &sync.RWMutex{},
+	}
+	m.server.Init(info.Ip, info.Port+portOffset)
+	m.server.BindPacketFunc(m.handlePacket)
+	m.server.Start()
+	go m.run()
+	return m
+}
+
+func (m *Mesh) Subscribe(subject string, handler MsgHandler) error {
+	m.subs.add(subject, handler)
+	frame := encodeMeshFrame(MESH_SUB, subject, "", nil)
+	m.locker.RLock()
+	for _, peer := range m.peerMap {
+		m.send(peer, frame)
+	}
+	m.locker.RUnlock()
+	return nil
+}
+
+func (m *Mesh) Publish(subject string, data []byte) error {
+	m.publish(subject, data, "")
+	return nil
+}
+
+func (m *Mesh) Request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	inbox := fmt.Sprintf("%s%d/%d", INBOX_PREFIX, m.info.Id(), atomic.AddUint32(&m.inboxSeed, 1))
+	return m.subs.request(inbox, func(reply string) int {
+		return m.publish(subject, data, reply)
+	}, timeout)
+}
+
+func (m *Mesh) Close() error {
+	m.locker.Lock()
+	for _, peer := range m.peerMap {
+		peer.client.Stop()
+	}
+	m.locker.Unlock()
+	m.server.Close()
+	return m.subs.close()
+}
+
+// 集群新加节点, 建立到对端的连接
+func (m *Mesh) AddPeer(info *common.ClusterInfo) {
+	if info.Id() == m.info.Id() {
+		return
+	}
+	m.locker.Lock()
+	_, bEx := m.peerMap[info.Id()]
+	if !bEx {
+		peer := &meshPeer{info: info, client: &network.ClientSocket{}}
+		peer.client.Init(info.Ip, info.Port+m.portOffset)
+		m.peerMap[info.Id()] = peer
+		go m.connect(peer)
+	}
+	m.locker.Unlock()
+}
+
+func (m *Mesh) DelPeer(info *common.ClusterInfo) {
+	m.locker.Lock()
+	peer, bEx := m.peerMap[info.Id()]
+	if bEx {
+		delete(m.peerMap, info.Id())
+		m.delInterest(info.Id())
+		peer.client.Stop()
+	}
+	m.locker.Unlock()
+}
+
+func (m *Mesh) run() {
+	ticker := time.NewTicker(MESH_RECONNECT_TIME)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.locker.RLock()
+			peers := make([]*meshPeer, 0, len(m.peerMap))
+			for _, peer := range m.peerMap {
+				if peer.client.GetState() != network.SSF_RUN {
+					peers = append(peers, peer)
+				}
+			}
+			m.locker.RUnlock()
+			for _, peer := range peers {
+				m.connect(peer)
+			}
+		case <-m.subs.closeChan:
+			return
+		}
+	}
+}
+
+// 连接对端并通告本节点订阅
+func (m *Mesh) connect(peer *meshPeer) {
+	if !atomic.CompareAndSwapInt32(&peer.connecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&peer.connecting, 0)
+	if peer.client.GetState() == network.SSF_RUN || !peer.client.Connect() {
+		return
+	}
+	peer.client.SetState(network.SSF_RUN)
+	go peer.client.Run()
+
+	info, _ := json.Marshal(m.info)
+	data, _ := json.Marshal(&meshHello{Info: info, Sign: m.sign(info)})
+	m.send(peer, encodeMeshFrame(MESH_HELLO, "", "", data))
+	for _, subject := range m.subs.subjects() {
+		m.send(peer, encodeMeshFrame(MESH_SUB, subject, "", nil))
+	}
+}
+
+func (m *Mesh) send(peer *meshPeer, frame []byte) bool {
+	if peer.client.GetState() != network.SSF_RUN {
+		return false
+	}
+	return peer.client.Send(rpc.RpcHead{}, rpc.Packet{Buff: frame}) > 0
+}
+
+// 投递到本进程和订阅了subject的对端, 返回接收者数量
+func (m *Mesh) publish(subject string, data []byte, reply string) int {
+	if strings.HasPrefix(subject, INBOX_PREFIX) {
+		peerId := inboxPeerId(subject)
+		if peerId == m.info.Id() {
+			return m.subs.publish(subject, data, "")
+		}
+		m.locker.RLock()
+		defer m.locker.RUnlock()
+		peer, bEx := m.peerMap[peerId]
+		if bEx && m.send(peer, encodeMeshFrame(MESH_MSG, subject, "", data)) {
+			return 1
+		}
+		return 0
+	}
+
+	nCount := m.subs.publish(subject, append([]byte(nil), data...), reply)
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	var frame []byte
+	for peerId := range m.interestMap[subject] {
+		peer, bEx := m.peerMap[peerId]
+		if !bEx {
+			continue
+		}
+		if frame == nil {
+			frame = encodeMeshFrame(MESH_MSG, subject, reply, data)
+		}
+		if m.send(peer, frame) {
+			nCount++
+		}
+	}
+	return nCount
+}
+
+// 连入socket的消息
+func (m *Mesh) handlePacket(packet rpc.Packet) bool {
+	if len(packet.Buff) == 0 || packet.Buff[0] != MESH_MAGIC {
+		//socket断开
+		rpcPacket, _ := rpc.UnmarshalHead(packet.Buff)
+		if rpcPacket != nil && rpcPacket.FuncName == "DISCONNECT" {
+			m.disconnect(packet.Id)
+		}
+		return true
+	}
+
+	kind, subject, reply, data, err := decodeMeshFrame(packet.Buff)
+	if err != nil {
+		base.LOG.Printf("mesh frame error %v", err)
+		return true
+	}
+
+	switch kind {
+	case MESH_HELLO:
+		hello := &meshHello{}
+		info := &common.ClusterInfo{}
+		if json.Unmarshal(data, hello) != nil || !hmac.Equal([]byte(hello.Sign), []byte(m.sign(hello.Info))) {
+			base.LOG.Printf("mesh hello from socket %d rejected", packet.Id)
+			return true
+		}
+		if json.Unmarshal(hello.Info, info) != nil {
+			return true
+		}
+		m.AddPeer(info)
+		m.locker.Lock()
+		m.socketMap[packet.Id] = info.Id()
+		if peer, bEx := m.peerMap[info.Id()]; bEx {
+			peer.socketId = packet.Id
+		}
+		m.locker.Unlock()
+	case MESH_SUB:
+		m.locker.Lock()
+		peerId, bEx := m.socketMap[packet.Id]
+		if bEx {
+			if m.interestMap[subject] == nil {
+				m.interestMap[subject] = make(map[uint32]bool)
+			}
+			m.interestMap[subject][peerId] = true
+		}
+		m.locker.Unlock()
+	case MESH_MSG:
+		m.locker.RLock()
+		_, bEx := m.socketMap[packet.Id]
+		m.locker.RUnlock()
+		if bEx {
+			m.subs.publish(subject, append([]byte(nil), data...), reply)
+		}
+	}
+	return true
+}
+
+func (m *Mesh) sign(data []byte) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Mesh) disconnect(socketId uint32) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	peerId, bEx := m.socketMap[socketId]
+	if !bEx {
+		return
+	}
+	delete(m.socketMap, socketId)
+	//对端已用新连接重新通告
+	if peer, bEx := m.peerMap[peerId]; bEx && peer.socketId != socketId {
+		return
+	}
+	m.delInterest(peerId)
+}
+
+// need m.locker before calling
+func (m *Mesh) delInterest(peerId uint32) {
+	for subject, peerIds := range m.interestMap {
+		delete(peerIds, peerId)
+		if len(peerIds) == 0 {
+			delete(m.interestMap, subject)
+		}
+	}
+}
+
+func inboxPeerId(inbox string) uint32 {
+	str := strings.TrimPrefix(inbox, INBOX_PREFIX)
+	if i := strings.Index(str, "/"); i >= 0 {
+		str = str[:i]
+	}
+	peerId, _ := strconv.ParseUint(str, 10, 32)
+	return uint32(peerId)
+}
+
+// magic|kind|subject len|subject|reply len|reply|data
+func encodeMeshFrame(kind byte, subject string, reply string, data []byte) []byte {
+	buff := make([]byte, 0, 6+len(subject)+len(reply)+len(data))
+	buff = append(buff, MESH_MAGIC, kind)
+	buff = binary.LittleEndian.AppendUint16(buff, uint16(len(subject)))
+	buff = append(buff, subject...)
+	buff = binary.LittleEndian.AppendUint16(buff, uint16(len(reply)))
+	buff = append(buff, reply...)
+	return append(buff, data...)
+}
+
+func decodeMeshFrame(buff []byte) (kind byte, subject string, reply string, data []byte, err error) {
+	err = fmt.Errorf("mesh frame too short")
+	if len(buff) < 4 {
+		return
+	}
+	kind = buff[1]
+	buff = buff[2:]
+	n := int(binary.LittleEndian.Uint16(buff))
+	if len(buff) < 2+n+2 {
+		return
+	}
+	subject = string(buff[2 : 2+n])
+	buff = buff[2+n:]
+	n = int(binary.LittleEndian.Uint16(buff))
+	if len(buff) < 2+n {
+		return
+	}
+	reply = string(buff[2 : 2+n])
+	return kind, subject, reply, buff[2+n:], nil
+}
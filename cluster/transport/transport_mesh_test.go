@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fengqk/mars-base/common"
+)
+
+const (
+	MESH_TEST_OFFSET = 1000
+)
+
+// 等待对端的订阅通告到达
+func waitInterest(t *testing.T, m *Mesh, subject string, want int) {
+	for i := 0; i < 200; i++ {
+		m.locker.RLock()
+		n := len(m.interestMap[subject])
+		m.locker.RUnlock()
+		if n == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("subject [%s] interest not %d", subject, want)
+}
+
+func newTestMesh(port int32) *Mesh {
+	return NewMesh(&common.ClusterInfo{Ip: "127.0.0.1", Port: port}, MESH_TEST_OFFSET, "secret")
+}
+
+func TestMesh(t *testing.T) {
+	m1, m2 := newTestMesh(37101), newTestMesh(37102)
+	defer m1.Close()
+	m2.AddPeer(m1.info)
+	m1.AddPeer(m2.info)
+
+	recvChan := make(chan []byte, 1)
+	m2.Subscribe("game", func(data []byte, reply string) {
+		if reply != "" {
+			m2.Publish(reply, append([]byte("re:"), data...))
+			return
+		}
+		recvChan <- data
+	})
+	waitInterest(t, m1, "game", 1)
+
+	//订阅
+	m1.Publish("game", []byte("hello"))
+	select {
+	case v := <-recvChan:
+		if string(v) != "hello" {
+			t.Fatalf("recv %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish not delivered")
+	}
+
+	//请求, 回包经inbox回到发起节点
+	reply, err := m1.Request("game", []byte("ping"), time.Second)
+	if err != nil || string(reply) != "re:ping" {
+		t.Fatalf("request %q, %v", reply, err)
+	}
+
+	//对端断开后订阅失效
+	m2.Close()
+	waitInterest(t, m1, "game", 0)
+	if _, err := m1.Request("game", nil, time.Second); err != ErrNoResponders {
+		t.Fatalf("request after disconnect %v", err)
+	}
+}
+
+// 密钥不一致的节点握手被拒绝, 其订阅和消息都不接受
+func TestMeshSecret(t *testing.T) {
+	m1 := newTestMesh(37103)
+	defer m1.Close()
+	m2 := NewMesh(&common.ClusterInfo{Ip: "127.0.0.1", Port: 37104}, MESH_TEST_OFFSET, "guess")
+	defer m2.Close()
+	recvChan := make(chan []byte, 1)
+	m1.Subscribe("game", func(data []byte, reply string) {
+		recvChan <- data
+	})
+	m2.Subscribe("game", func(data []byte, reply string) {})
+	m2.AddPeer(m1.info)
+
+	m2.locker.RLock()
+	peer := m2.peerMap[m1.info.Id()]
+	m2.locker.RUnlock()
+	for i := 0; i < 200 && !m2.send(peer, encodeMeshFrame(MESH_MSG, "game", "", []byte("forged"))); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case v := <-recvChan:
+		t.Fatalf("forged message delivered %s", v)
+	case <-time.After(200 * time.Millisecond):
+	}
+	m1.locker.RLock()
+	defer m1.locker.RUnlock()
+	if len(m1.socketMap) != 0 || len(m1.interestMap["game"]) != 0 || m1.peerMap[m2.info.Id()] != nil {
+		t.Fatalf("peer registered sockets %v interest %v", m1.socketMap, m1.interestMap)
+	}
+}
+
+func TestInboxPeerId(t *testing.T) {
+	tests := []struct {
+		inbox string
+		id    uint32
+	}{
+		{INBOX_PREFIX + "12/3", 12},
+		{INBOX_PREFIX + "7", 7},
+		{INBOX_PREFIX + "x/1", 0},
+	}
+	for _, test := range tests {
+		if id := inboxPeerId(test.inbox); id != test.id {
+			t.Errorf("inboxPeerId(%s) = %d, want %d", test.inbox, id, test.id)
+		}
+	}
+}
+
+func TestMeshFrame(t *testing.T) {
+	frame := encodeMeshFrame(MESH_MSG, "game", INBOX_PREFIX+"1/2", []byte("data"))
+	kind, subject, reply, data, err := decodeMeshFrame(frame)
+	if err != nil || kind != MESH_MSG || subject != "game" || reply != INBOX_PREFIX+"1/2" || string(data) != "data" {
+		t.Fatalf("decode %d %s %s %s %v", kind, subject, reply, data, err)
+	}
+	for i := 0; i < len(frame)-len("data"); i++ {
+		if _, _, _, _, err := decodeMeshFrame(frame[:i]); err == nil {
+			t.Fatalf("truncated frame %d decoded", i)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fengqk/mars-base/common"
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	// 命名空间, 所有subject加上前缀, 共用一套nats的多个环境或区服互相隔离
+	// request的回包subject由底层生成且唯一, 不加前缀
+	Namespace struct {
+		Transport
+		prefix string
+	}
+)
+
+// namespace为空时直接返回t
+func NewNamespace(t Transport, namespace string) Transport {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return t
+	}
+	return &Namespace{Transport: t, prefix: namespace + "/"}
+}
+
+func (n *Namespace) Prefix() string {
+	return n.prefix
+}
+
+func (n *Namespace) Subscribe(subject string, handler MsgHandler) error {
+	return n.Transport.Subscribe(n.prefix+subject, handler)
+}
+
+func (n *Namespace) Publish(subject string, data []byte) error {
+	if isInbox(subject) {
+		return n.Transport.Publish(subject, data)
+	}
+	return n.Transport.Publish(n.prefix+subject, data)
+}
+
+func (n *Namespace) Request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	return n.Transport.Request(n.prefix+subject, data, timeout)
+}
+
+// 底层为网格时转发成员变化
+func (n *Namespace) AddPeer(info *common.ClusterInfo) {
+	if t, bOk := n.Transport.(PeerTransport); bOk {
+		t.AddPeer(info)
+	}
+}
+
+func (n *Namespace) DelPeer(info *common.ClusterInfo) {
+	if t, bOk := n.Transport.(PeerTransport); bOk {
+		t.DelPeer(info)
+	}
+}
+
+func isInbox(subject string) bool {
+	return strings.HasPrefix(subject, INBOX_PREFIX) || strings.HasPrefix(subject, nats.InboxPrefix)
+}
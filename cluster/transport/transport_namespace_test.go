@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceSubject(t *testing.T) {
+	l := NewLoopback()
+	defer l.Close()
+	if NewNamespace(l, "/") != Transport(l) {
+		t.Fatal("empty namespace wrapped")
+	}
+
+	tests := []struct {
+		namespace string
+		subject   string //底层实际使用的subject
+	}{
+		{"qa", "qa/game"},
+		{"/qa2/", "qa2/game"},
+		{"dev/realm1", "dev/realm1/game"},
+	}
+	recvChan := make(chan string, len(tests))
+	for _, test := range tests {
+		n, subject := NewNamespace(l, test.namespace), test.subject
+		n.Subscribe("game", func(data []byte, reply string) {
+			if reply != "" {
+				n.Publish(reply, data)
+				return
+			}
+			recvChan <- subject
+		})
+	}
+	for _, test := range tests {
+		n := NewNamespace(l, test.namespace)
+		n.Publish("game", nil)
+		select {
+		case subject := <-recvChan:
+			if subject != test.subject {
+				t.Fatalf("[%s] published to %s", test.namespace, subject)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] not delivered", test.namespace)
+		}
+		//回包subject由底层生成, 不加前缀
+		if reply, err := n.Request("game", []byte(test.namespace), time.Second); err != nil || string(reply) != test.namespace {
+			t.Fatalf("[%s] request %q %v", test.namespace, reply, err)
+		}
+		if _, err := l.Request(test.subject, nil, 100*time.Millisecond); err != nil {
+			t.Fatalf("[%s] backend subject %s %v", test.namespace, test.subject, err)
+		}
+	}
+	if _, err := l.Request("game", nil, 100*time.Millisecond); err != ErrNoResponders {
+		t.Fatalf("subject without namespace %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	Nats struct {
+		conn *nats.Conn
+	}
+)
+
+func NewNats(natsUrl string, appDieChan chan bool) (*Nats, error) {
+	conn, err := setupNatsConn(natsUrl, appDieChan)
+	if err != nil {
+		return nil, err
+	}
+	return &Nats{conn: conn}, nil
+}
+
+func (n *Nats) Conn() *nats.Conn {
+	return n.conn
+}
+
+func (n *Nats) Subscribe(subject string, handler MsgHandler) error {
+	_, err := n.conn.Subscribe(subject, func(msg *nats.Msg) {
+		handler(msg.Data, msg.Reply)
+	})
+	return err
+}
+
+func (n *Nats) Publish(subject string, data []byte) error {
+	return n.conn.Publish(subject, data)
+}
+
+func (n *Nats) Request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	reply, err := n.conn.Request(subject, data, timeout)
+	switch err {
+	case nil:
+		return reply.Data, nil
+	case nats.ErrTimeout:
+		return nil, ErrTimeout
+	case nats.ErrNoResponders:
+		return nil, ErrNoResponders
+	}
+	return nil, err
+}
+
+func (n *Nats) Close() error {
+	n.conn.Close()
+	return nil
+}
+
+func setupNatsConn(connectString string, appDieChan chan bool, options ...nats.Option) (*nats.Conn, error) {
+	natsOptions := append(
+		options,
+		nats.DisconnectHandler(func(_ *nats.Conn) {
+			base.LOG.Println("disconnected from nats!")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			base.LOG.Printf("reconnected to nats server %s with address %s in cluster %s!", nc.ConnectedServerId(), nc.ConnectedAddr(), nc.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			err := nc.LastError()
+			if err == nil {
+				base.LOG.Println("nats connection closed with no error.")
+				return
+			}
+
+			base.LOG.Printf("nats connection closed. reason: %q", nc.LastError())
+			if appDieChan != nil {
+				appDieChan <- true
+			}
+		}),
+	)
+
+	nc, err := nats.Connect(connectString, natsOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return nc, nil
+}
@@ -0,0 +1,195 @@
+// marsctl 查看和控制运行中的集群, 节点列表读注册中心, 其余命令通过节点的admin subject
+//
+//	marsctl [-etcd 127.0.0.1:2379] [-nats nats://127.0.0.1:4222] [-namespace qa] [-token xxx] <cmd> [args...]
+//
+//	nodes [service]                         按服务类型列出节点
+//	stubs <node>                            stub数量和持有者
+//	mailbox <node> <type> [id]              mailbox持有者
+//	actors <node>                           actor消息统计
+//	drain|reload|gc <node>                  排空/重载/gc
+//	call <node> <Actor.Func> <id> [json...] 调用actor方法
+//	schema <node>                           导出rpc签名
+//	schemadiff <old.json> <new.json>        比较两份签名, 有不兼容的修改时返回1
+//
+// node为集群id或ip:port, admin命令需携带节点配置的token, 未指定-token时读取环境变量MARSCTL_TOKEN
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/fengqk/mars-base/cluster"
+	"github.com/fengqk/mars-base/cluster/discovery"
+	"github.com/fengqk/mars-base/cluster/etcd"
+	"github.com/fengqk/mars-base/cluster/transport"
+	"github.com/fengqk/mars-base/common"
+	"github.com/fengqk/mars-base/rpc"
+)
+
+var (
+	etcdEndpoints = flag.String("etcd", "127.0.0.1:2379", "etcd endpoints, comma separated")
+	natsUrl       = flag.String("nats", "nats://127.0.0.1:4222", "nats url")
+	namespace     = flag.String("namespace", "", "cluster namespace")
+	token         = flag.String("token", os.Getenv("MARSCTL_TOKEN"), "admin token, default $MARSCTL_TOKEN")
+	timeout       = flag.Duration("timeout", cluster.ADMIN_TIME_OUT+time.Second, "admin request timeout")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: marsctl [flags] nodes [service]")
+	fmt.Fprintln(os.Stderr, "       marsctl [flags] stubs|actors|drain|reload|gc|schema <node>")
+	fmt.Fprintln(os.Stderr, "       marsctl [flags] mailbox <node> <type> [id]")
+	fmt.Fprintln(os.Stderr, "       marsctl [flags] call <node> <Actor.Func> <id> [json...]")
+	fmt.Fprintln(os.Stderr, "       marsctl schemadiff <old.json> <new.json>")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func loadNodes() []*common.ClusterInfo {
+	d := discovery.NewNamespace(discovery.NewEtcd(strings.Split(*etcdEndpoints, ",")), *namespace)
+	defer d.Close()
+	kvs, _, err := d.Get(etcd.ETCD_DIR)
+	if err != nil {
+		fatal("read nodes: %v", err)
+	}
+	nodes := make([]*common.ClusterInfo, 0, len(kvs))
+	for _, kv := range kvs {
+		info := &common.ClusterInfo{}
+		if json.Unmarshal(kv.Value, info) == nil {
+			nodes = append(nodes, info)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Type != nodes[j].Type {
+			return nodes[i].Type < nodes[j].Type
+		}
+		return nodes[i].IpString() < nodes[j].IpString()
+	})
+	return nodes
+}
+
+func listNodes(args []string) {
+	service := ""
+	if len(args) > 0 {
+		service = strings.ToLower(args[0])
+	}
+	for _, info := range loadNodes() {
+		if service != "" && info.String() != service {
+			continue
+		}
+		fmt.Printf("%-10s %-10d %-21s %-8s weight=%d load=%d\n", info.String(), info.Id(), info.IpString(),
+			strings.ToLower(info.State.String()), info.Weight, info.Load)
+	}
+}
+
+func parseNode(node string) uint32 {
+	if strings.Contains(node, ":") {
+		return base.ToHash(node)
+	}
+	clusterId, err := strconv.ParseUint(node, 10, 32)
+	if err != nil {
+		fatal("node %s: %v", node, err)
+	}
+	return uint32(clusterId)
+}
+
+func admin(node string, cmd string, args []string) {
+	nt, err := transport.NewNats(*natsUrl, nil)
+	if err != nil {
+		fatal("connect nats: %v", err)
+	}
+	defer nt.Close()
+	t := transport.NewNamespace(nt, *namespace)
+
+	data, _ := json.Marshal(&cluster.AdminRequest{Token: *token, Cmd: cmd, Args: args})
+	data, err = t.Request(cluster.AdminChannel(parseNode(node)), data, *timeout)
+	if err != nil {
+		fatal("admin %s: %v", cmd, err)
+	}
+	resp := &cluster.AdminResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		fatal("admin %s: %v", cmd, err)
+	}
+	if resp.Error != "" {
+		fatal("admin %s: %s", cmd, resp.Error)
+	}
+	var buf bytes.Buffer
+	if json.Indent(&buf, resp.Data, "", "  ") != nil {
+		buf.Write(resp.Data)
+	}
+	fmt.Println(buf.String())
+}
+
+func loadSchema(fileName string) rpc.Schema {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		fatal("read schema: %v", err)
+	}
+	s, err := rpc.LoadSchema(data)
+	if err != nil {
+		fatal("schema %s: %v", fileName, err)
+	}
+	return s
+}
+
+func diffSchema(oldFile, newFile string) {
+	breaking := false
+	for _, diff := range rpc.DiffSchema(loadSchema(oldFile), loadSchema(newFile)) {
+		fmt.Println(diff.String())
+		breaking = breaking || diff.Breaking
+	}
+	if breaking {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+
+	switch cmd := args[0]; cmd {
+	case "nodes":
+		listNodes(args[1:])
+	case "stubs", "actors", "drain", "reload", "gc", "schema":
+		if len(args) != 2 {
+			usage()
+		}
+		admin(args[1], cmd, nil)
+	case "mailbox":
+		if len(args) < 3 {
+			usage()
+		}
+		if _, bEx := rpc.MAIL_value[args[2]]; !bEx {
+			fatal("mailbox type %s not found", args[2])
+		}
+		admin(args[1], cmd, args[2:])
+	case "call":
+		if len(args) < 4 {
+			usage()
+		}
+		admin(args[1], cmd, args[2:])
+	case "schemadiff":
+		if len(args) != 3 {
+			usage()
+		}
+		diffSchema(args[1], args[2])
+	default:
+		usage()
+	}
+}
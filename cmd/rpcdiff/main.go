@@ -0,0 +1,46 @@
+// rpcdiff 比较两个版本导出的rpc签名(rpc.DumpSchema), 有不兼容修改时返回非0
+//
+//	rpcdiff old.json new.json
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/fengqk/mars-base/rpc"
+)
+
+func loadSchema(path string) rpc.Schema {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read %s: %v\n", path, err)
+		os.Exit(2)
+	}
+	s, err := rpc.LoadSchema(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse %s: %v\n", path, err)
+		os.Exit(2)
+	}
+	return s
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: rpcdiff old.json new.json")
+		os.Exit(2)
+	}
+
+	diffs := rpc.DiffSchema(loadSchema(os.Args[1]), loadSchema(os.Args[2]))
+	breaking := 0
+	for _, d := range diffs {
+		fmt.Println(d.String())
+		if d.Breaking {
+			breaking++
+		}
+	}
+	if breaking > 0 {
+		fmt.Printf("%d breaking change(s)\n", breaking)
+		os.Exit(1)
+	}
+}
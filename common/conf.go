@@ -28,6 +28,11 @@ type (
 		Endpoints []string `yaml:"endpoints"`
 	}
 
+	// 集群命名空间, 加在所有etcd key和subject前, 共用etcd/nats的环境或区服互相隔离
+	Namespace struct {
+		Name string `yaml:"name"` //如dev/qa/realm1, 为空不隔离
+	}
+
 	SnowFlake struct {
 		Endpoints []string `yaml:"endpoints"`
 	}
@@ -36,6 +41,14 @@ type (
 		Endpoints string `yaml:"endpoints"`
 	}
 
+	Transport struct {
+		Type       string `yaml:"type"`        //nats/local/tcp
+		Endpoints  string `yaml:"endpoints"`   //nats地址
+		PortOffset int32  `yaml:"port_offset"` //tcp网格监听端口相对服务端口的偏移
+		Compress   int32  `yaml:"compress"`    //RpcBody超过该字节数压缩, 0不压缩, 所有节点支持解压后再开启
+		Secret     string `yaml:"secret"`      //tcp网格的共享密钥, 握手时校验, 未配置时能连上端口的节点都可加入
+	}
+
 	Raft struct {
 		Endpoints []string `yaml:"endpoints"`
 	}
@@ -46,5 +59,55 @@ type (
 
 	Stub struct {
 		StubCount map[string]int64 `yaml:"stub_count"`
+		JumpHash  bool             `yaml:"jump_hash"` //stub按jump一致性hash路由, 与按Id取模不兼容, 需全集群重启后同时开启
+	}
+
+	// 零值为mailbox/<MailType>/<Id>布局和10秒会话租约
+	// 与旧版本节点混部时开启LegacyKey, player沿用mailbox/<Id>, 全部升级后再关闭
+	MailBox struct {
+		TTL       int64 `yaml:"ttl"`        //会话租约秒数, 0为默认值
+		LegacyKey bool  `yaml:"legacy_key"` //player mailbox使用旧版key
+	}
+
+	// 独立部署的区服集群
+	Realm struct {
+		Id        uint32 `yaml:"id"`
+		Namespace string `yaml:"namespace"` //区服集群的命名空间
+		Endpoints string `yaml:"endpoints"` //区服集群的nats地址
+	}
+
+	// 跨区服网关
+	Federation struct {
+		Realms []Realm  `yaml:"realms"`
+		Allow  []string `yaml:"allow"` //允许其他区服调用的Actor或Actor.Func, "*"表示全部, 为空全部拒绝
+	}
+
+	// 管理命令鉴权, Token为空时拒绝全部管理命令
+	Admin struct {
+		Token string   `yaml:"token"` //请求需携带的令牌
+		Allow []string `yaml:"allow"` //call允许调用的Actor或Actor.Func, "*"表示全部, 为空拒绝call
+	}
+
+	// 集群call策略, 时间单位毫秒, 字段含义同cluster.CallPolicy
+	CallPolicy struct {
+		Service     string   `yaml:"service"`      //目标服务类型, 如game
+		Retry       int      `yaml:"retry"`        //幂等调用的最大重试次数
+		Backoff     int64    `yaml:"backoff"`      //重试退避基数
+		Idempotent  []string `yaml:"idempotent"`   //幂等的函数名, "*"表示全部
+		Breaker     int      `yaml:"breaker"`      //连续失败次数达到后熔断, 0不熔断
+		BreakerTime int64    `yaml:"breaker_time"` //熔断持续时间
+		Hedge       int64    `yaml:"hedge"`        //对冲等待时间, 0不对冲
+	}
+
+	StaticService struct {
+		Type   string `yaml:"type"`
+		Ip     string `yaml:"ip"`
+		Port   int32  `yaml:"port"`
+		Weight int32  `yaml:"weight"`
+	}
+
+	Static struct {
+		Namespace string          `yaml:"namespace"`
+		Services  []StaticService `yaml:"services"`
 	}
 )
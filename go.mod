@@ -8,6 +8,7 @@ require (
 	github.com/gomodule/redigo v1.8.9
 	github.com/nats-io/nats.go v1.25.0
 	github.com/xtaci/kcp-go v4.3.4+incompatible
+	go.etcd.io/etcd/api/v3 v3.5.8
 	go.etcd.io/etcd/client/v3 v3.5.8
 	golang.org/x/net v0.9.0
 	google.golang.org/protobuf v1.30.0
@@ -27,7 +28,6 @@ require (
 	github.com/templexxx/cpufeat v0.0.0-20180724012125-cef66df7f161 // indirect
 	github.com/templexxx/xor v0.0.0-20191217153810-f85b25db303b // indirect
 	github.com/tjfoc/gmsm v1.4.1 // indirect
-	go.etcd.io/etcd/api/v3 v3.5.8 // indirect
 	go.etcd.io/etcd/client/pkg/v3 v3.5.8 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
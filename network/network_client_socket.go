@@ -44,10 +44,14 @@ func (c *ClientSocket) Start() bool {
 }
 
 func (c *ClientSocket) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
-	c.Send(head, rpc.Marshal(&head, &funcName, params...))
+	c.sendFrame(c.packetParser.WriteHead(rpc.MarshalTo(&head, &funcName, c.packetParser.HeadLen(), c.compress, params...)))
 }
 
 func (c *ClientSocket) Send(head rpc.RpcHead, packet rpc.Packet) int {
+	return c.sendFrame(c.packetParser.Write(packet.Buff))
+}
+
+func (c *ClientSocket) sendFrame(frame []byte) int {
 	defer func() {
 		if err := recover(); err != nil {
 			base.TraceCode(err)
@@ -58,8 +62,12 @@ func (c *ClientSocket) Send(head rpc.RpcHead, packet rpc.Packet) int {
 		return 0
 	}
 
-	n, err := c.conn.Write(c.packetParser.Write(packet.Buff))
-	log.Printf("错误：%s\n", err.Error())
+	n, err := c.conn.Write(frame)
+	//帧已写出, 归还供rpc.MarshalTo复用
+	rpc.PutFrame(frame)
+	if err != nil {
+		log.Printf("错误：%s\n", err.Error())
+	}
 	if n > 0 {
 		return n
 	}
@@ -100,31 +100,39 @@ ParsePacket:
 	return true
 }
 
-func (p *PacketParser) Write(data []byte) []byte {
-	msgLen := len(data)
-	if msgLen+int(p.len) > base.MAX_PACKET {
+// 包头长度, rpc.MarshalTo按此预留
+func (p *PacketParser) HeadLen() int {
+	return int(p.len)
+}
+
+// 在frame前p.len字节原地写入包长
+func (p *PacketParser) WriteHead(frame []byte) []byte {
+	msgLen := len(frame) - int(p.len)
+	if len(frame) > base.MAX_PACKET {
 		fmt.Println("write over common.MAX_PACKET")
 	}
 
-	msg := make([]byte, int(p.len)+msgLen)
-
 	switch p.len {
 	case PACKET_LEN_BYTE:
-		msg[0] = byte(msgLen)
+		frame[0] = byte(msgLen)
 	case PACKET_LEN_WORD:
 		if p.littleEndian {
-			binary.LittleEndian.PutUint16(msg, uint16(msgLen))
+			binary.LittleEndian.PutUint16(frame, uint16(msgLen))
 		} else {
-			binary.BigEndian.PutUint16(msg, uint16(msgLen))
+			binary.BigEndian.PutUint16(frame, uint16(msgLen))
 		}
 	case PACKET_LEN_DWORD:
 		if p.littleEndian {
-			binary.LittleEndian.PutUint32(msg, uint32(msgLen))
+			binary.LittleEndian.PutUint32(frame, uint32(msgLen))
 		} else {
-			binary.BigEndian.PutUint32(msg, uint32(msgLen))
+			binary.BigEndian.PutUint32(frame, uint32(msgLen))
 		}
 	}
+	return frame
+}
 
+func (p *PacketParser) Write(data []byte) []byte {
+	msg := make([]byte, int(p.len)+len(data))
 	copy(msg[p.len:], data)
-	return msg
+	return p.WriteHead(msg)
 }
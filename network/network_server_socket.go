@@ -92,6 +92,7 @@ func (s *ServerSocket) AddClient(conn net.Conn, addr string, connectType int32)
 		client.Init("", 0)
 		client.server = s
 		client.recvBuffSize = s.recvBuffSize
+		client.compress = s.compress
 		client.SetMaxPacketLen(s.GetMaxPacketLen())
 		client.clientId = s.AssignClientId()
 		client.ip = addr
@@ -139,7 +140,7 @@ func (s *ServerSocket) Send(head rpc.RpcHead, packet rpc.Packet) int {
 func (s *ServerSocket) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
 	client := s.GetClientById(head.SocketId)
 	if client != nil {
-		client.Send(head, rpc.Marshal(&head, &funcName, params...))
+		client.SendMsg(head, funcName, params...)
 	}
 }
 
@@ -167,8 +168,8 @@ func (s *ServerSocket) Close() {
 func (s *ServerSocket) Run() bool {
 	for {
 		tcpConn, err := s.listen.AcceptTCP()
-		log.Printf("错误：%s\n", err.Error())
 		if err != nil {
+			log.Printf("错误：%s\n", err.Error())
 			return false
 		}
 
@@ -180,8 +181,8 @@ func (s *ServerSocket) Run() bool {
 func (s *ServerSocket) RunKcp() bool {
 	for {
 		kcpConn, err := s.kcpListern.Accept()
-		log.Printf("错误：%s\n", err.Error())
 		if err != nil {
+			log.Printf("错误：%s\n", err.Error())
 			return false
 		}
 
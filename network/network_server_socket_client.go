@@ -69,7 +69,15 @@ func (s *ServerSocketClient) Start() bool {
 	return true
 }
 
+func (s *ServerSocketClient) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
+	s.sendFrame(s.packetParser.WriteHead(rpc.MarshalTo(&head, &funcName, s.packetParser.HeadLen(), s.compress, params...)))
+}
+
 func (s *ServerSocketClient) Send(head rpc.RpcHead, packet rpc.Packet) int {
+	return s.sendFrame(s.packetParser.Write(packet.Buff))
+}
+
+func (s *ServerSocketClient) sendFrame(frame []byte) int {
 	defer func() {
 		if err := recover(); err != nil {
 			base.TraceCode(err)
@@ -78,23 +86,31 @@ func (s *ServerSocketClient) Send(head rpc.RpcHead, packet rpc.Packet) int {
 
 	if s.connType == CLIENT_CONNECT { //对外链接send不阻塞
 		select {
-		case s.sendChan <- packet.Buff:
+		case s.sendChan <- frame:
 		default: //网络太卡,tcp send缓存满了并且发送队列也满了
 			s.OnNetFail(1)
 		}
 	} else {
-		return s.DoSend(packet.Buff)
+		return s.writeFrame(frame)
 	}
 	return 0
 }
 
 func (s *ServerSocketClient) DoSend(buff []byte) int {
+	return s.writeFrame(s.packetParser.Write(buff))
+}
+
+func (s *ServerSocketClient) writeFrame(frame []byte) int {
 	if s.conn == nil {
 		return 0
 	}
 
-	n, err := s.conn.Write(s.packetParser.Write(buff))
-	log.Printf("错误：%s\n", err.Error())
+	n, err := s.conn.Write(frame)
+	//帧已写出, 归还供rpc.MarshalTo复用
+	rpc.PutFrame(frame)
+	if err != nil {
+		log.Printf("错误：%s\n", err.Error())
+	}
 	if n > 0 {
 		return n
 	}
@@ -207,7 +223,7 @@ func (s *ServerSocketClient) SendLoop() bool {
 			if buff == nil { //信道关闭
 				return false
 			} else {
-				s.DoSend(buff)
+				s.writeFrame(buff)
 			}
 		}
 	}
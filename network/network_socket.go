@@ -28,7 +28,8 @@ type (
 	PacketFunc func(packet rpc.Packet) bool
 
 	Op struct {
-		kcp bool
+		kcp      bool
+		compress int
 	}
 
 	OpOption func(*Op)
@@ -57,6 +58,7 @@ type (
 		packetParser PacketParser
 		heartTime    int64
 		isKcp        bool
+		compress     int //SendMsg时RpcBody超过该字节数压缩, 0不压缩
 	}
 
 	ISocket interface {
@@ -100,6 +102,13 @@ func WithKcp() OpOption {
 	}
 }
 
+// SendMsg时RpcBody超过threshold字节压缩, 对端需支持解压
+func WithCompress(threshold int) OpOption {
+	return func(op *Op) {
+		op.compress = threshold
+	}
+}
+
 func (this *Socket) Init(ip string, port int32, params ...OpOption) bool {
 	op := &Op{}
 	op.applyOpts(params)
@@ -116,6 +125,7 @@ func (this *Socket) Init(ip string, port int32, params ...OpOption) bool {
 	if op.kcp {
 		this.isKcp = true
 	}
+	this.compress = op.compress
 	return true
 }
 
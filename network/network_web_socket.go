@@ -80,6 +80,7 @@ func (w *WebSocket) AddClient(tcpConn *websocket.Conn, addr string, connectType
 		client.Init("", 0)
 		client.server = w
 		client.recvBuffSize = w.recvBuffSize
+		client.compress = w.compress
 		client.SetMaxPacketLen(w.GetMaxPacketLen())
 		client.clientId = w.AssignClientId()
 		client.ip = addr
@@ -126,7 +127,7 @@ func (w *WebSocket) Send(head rpc.RpcHead, packet rpc.Packet) int {
 func (w *WebSocket) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
 	client := w.GetClientById(head.SocketId)
 	if client != nil {
-		client.Send(head, rpc.Marshal(&head, &funcName, params...))
+		client.SendMsg(head, funcName, params...)
 	}
 }
 
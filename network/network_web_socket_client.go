@@ -66,7 +66,15 @@ func (w *WebSocketClient) Stop() bool {
 	return false
 }
 
+func (w *WebSocketClient) SendMsg(head rpc.RpcHead, funcName string, params ...interface{}) {
+	w.sendFrame(w.packetParser.WriteHead(rpc.MarshalTo(&head, &funcName, w.packetParser.HeadLen(), w.compress, params...)))
+}
+
 func (w *WebSocketClient) Send(head rpc.RpcHead, packet rpc.Packet) int {
+	return w.sendFrame(w.packetParser.Write(packet.Buff))
+}
+
+func (w *WebSocketClient) sendFrame(frame []byte) int {
 	defer func() {
 		if err := recover(); err != nil {
 			base.TraceCode(err)
@@ -75,23 +83,31 @@ func (w *WebSocketClient) Send(head rpc.RpcHead, packet rpc.Packet) int {
 
 	if w.connType == CLIENT_CONNECT { //对外链接send不阻塞
 		select {
-		case w.sendChan <- packet.Buff:
+		case w.sendChan <- frame:
 		default: //网络太卡,tcp send缓存满了并且发送队列也满了
 			w.OnNetFail(1)
 		}
 	} else {
-		return w.DoSend(packet.Buff)
+		return w.writeFrame(frame)
 	}
 	return 0
 }
 
 func (w *WebSocketClient) DoSend(buff []byte) int {
+	return w.writeFrame(w.packetParser.Write(buff))
+}
+
+func (w *WebSocketClient) writeFrame(frame []byte) int {
 	if w.conn == nil {
 		return 0
 	}
 
-	n, err := w.conn.Write(w.packetParser.Write(buff))
-	log.Printf("错误：%s\n", err.Error())
+	n, err := w.conn.Write(frame)
+	//帧已写出, 归还供rpc.MarshalTo复用
+	rpc.PutFrame(frame)
+	if err != nil {
+		log.Printf("错误：%s\n", err.Error())
+	}
 	if n > 0 {
 		return n
 	}
@@ -190,7 +206,7 @@ func (w *WebSocketClient) SendLoop() bool {
 			if buff == nil { //信道关闭
 				return false
 			} else {
-				w.DoSend(buff)
+				w.writeFrame(buff)
 			}
 		}
 	}
@@ -88,6 +88,7 @@ type SEND int32
 const (
 	SEND_POINT      SEND = 0 //指定集群id
 	SEND_BOARD_CAST SEND = 1 //广播
+	SEND_BALANCE    SEND = 2 //负载均衡
 )
 
 // Enum value maps for SEND.
@@ -95,10 +96,12 @@ var (
 	SEND_name = map[int32]string{
 		0: "POINT",
 		1: "BOARD_CAST",
+		2: "BALANCE",
 	}
 	SEND_value = map[string]int32{
 		"POINT":      0,
 		"BOARD_CAST": 1,
+		"BALANCE":    2,
 	}
 )
 
@@ -129,6 +132,162 @@ func (SEND) EnumDescriptor() ([]byte, []int) {
 	return file_rpc3_proto_rawDescGZIP(), []int{1}
 }
 
+// 流帧类型
+type FRAME int32
+
+const (
+	FRAME_DATA   FRAME = 0 //数据
+	FRAME_OPEN   FRAME = 1 //建立流
+	FRAME_CREDIT FRAME = 2 //流控额度
+	FRAME_EOS    FRAME = 3 //流结束
+	FRAME_ERR    FRAME = 4 //流错误
+)
+
+// Enum value maps for FRAME.
+var (
+	FRAME_name = map[int32]string{
+		0: "DATA",
+		1: "OPEN",
+		2: "CREDIT",
+		3: "EOS",
+		4: "ERR",
+	}
+	FRAME_value = map[string]int32{
+		"DATA":   0,
+		"OPEN":   1,
+		"CREDIT": 2,
+		"EOS":    3,
+		"ERR":    4,
+	}
+)
+
+func (x FRAME) Enum() *FRAME {
+	p := new(FRAME)
+	*p = x
+	return p
+}
+
+func (x FRAME) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FRAME) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc3_proto_enumTypes[2].Descriptor()
+}
+
+func (FRAME) Type() protoreflect.EnumType {
+	return &file_rpc3_proto_enumTypes[2]
+}
+
+func (x FRAME) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FRAME.Descriptor instead.
+func (FRAME) EnumDescriptor() ([]byte, []int) {
+	return file_rpc3_proto_rawDescGZIP(), []int{2}
+}
+
+// 压缩算法
+type COMPRESS int32
+
+const (
+	COMPRESS_RAW   COMPRESS = 0 //不压缩
+	COMPRESS_FLATE COMPRESS = 1 //deflate
+)
+
+// Enum value maps for COMPRESS.
+var (
+	COMPRESS_name = map[int32]string{
+		0: "RAW",
+		1: "FLATE",
+	}
+	COMPRESS_value = map[string]int32{
+		"RAW":   0,
+		"FLATE": 1,
+	}
+)
+
+func (x COMPRESS) Enum() *COMPRESS {
+	p := new(COMPRESS)
+	*p = x
+	return p
+}
+
+func (x COMPRESS) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (COMPRESS) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc3_proto_enumTypes[3].Descriptor()
+}
+
+func (COMPRESS) Type() protoreflect.EnumType {
+	return &file_rpc3_proto_enumTypes[3]
+}
+
+func (x COMPRESS) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use COMPRESS.Descriptor instead.
+func (COMPRESS) EnumDescriptor() ([]byte, []int) {
+	return file_rpc3_proto_rawDescGZIP(), []int{3}
+}
+
+// 节点状态
+type NODE int32
+
+const (
+	NODE_READY    NODE = 0 //可分配
+	NODE_STARTING NODE = 1 //启动中
+	NODE_DRAINING NODE = 2 //排空中, 不再分配新流量
+	NODE_STOPPING NODE = 3 //停止中
+)
+
+// Enum value maps for NODE.
+var (
+	NODE_name = map[int32]string{
+		0: "READY",
+		1: "STARTING",
+		2: "DRAINING",
+		3: "STOPPING",
+	}
+	NODE_value = map[string]int32{
+		"READY":    0,
+		"STARTING": 1,
+		"DRAINING": 2,
+		"STOPPING": 3,
+	}
+)
+
+func (x NODE) Enum() *NODE {
+	p := new(NODE)
+	*p = x
+	return p
+}
+
+func (x NODE) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (NODE) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc3_proto_enumTypes[4].Descriptor()
+}
+
+func (NODE) Type() protoreflect.EnumType {
+	return &file_rpc3_proto_enumTypes[4]
+}
+
+func (x NODE) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use NODE.Descriptor instead.
+func (NODE) EnumDescriptor() ([]byte, []int) {
+	return file_rpc3_proto_rawDescGZIP(), []int{4}
+}
+
 // STUB类型
 type STUB int32
 
@@ -169,11 +328,11 @@ func (x STUB) String() string {
 }
 
 func (STUB) Descriptor() protoreflect.EnumDescriptor {
-	return file_rpc3_proto_enumTypes[2].Descriptor()
+	return file_rpc3_proto_enumTypes[5].Descriptor()
 }
 
 func (STUB) Type() protoreflect.EnumType {
-	return &file_rpc3_proto_enumTypes[2]
+	return &file_rpc3_proto_enumTypes[5]
 }
 
 func (x STUB) Number() protoreflect.EnumNumber {
@@ -182,7 +341,7 @@ func (x STUB) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use STUB.Descriptor instead.
 func (STUB) EnumDescriptor() ([]byte, []int) {
-	return file_rpc3_proto_rawDescGZIP(), []int{2}
+	return file_rpc3_proto_rawDescGZIP(), []int{5}
 }
 
 // 邮件类型
@@ -190,15 +349,24 @@ type MAIL int32
 
 const (
 	MAIL_Player MAIL = 0 //player
+	MAIL_Guild  MAIL = 1 //公会
+	MAIL_Room   MAIL = 2 //房间
+	MAIL_Battle MAIL = 3 //战斗
 )
 
 // Enum value maps for MAIL.
 var (
 	MAIL_name = map[int32]string{
 		0: "Player",
+		1: "Guild",
+		2: "Room",
+		3: "Battle",
 	}
 	MAIL_value = map[string]int32{
 		"Player": 0,
+		"Guild":  1,
+		"Room":   2,
+		"Battle": 3,
 	}
 )
 
@@ -213,11 +381,11 @@ func (x MAIL) String() string {
 }
 
 func (MAIL) Descriptor() protoreflect.EnumDescriptor {
-	return file_rpc3_proto_enumTypes[3].Descriptor()
+	return file_rpc3_proto_enumTypes[6].Descriptor()
 }
 
 func (MAIL) Type() protoreflect.EnumType {
-	return &file_rpc3_proto_enumTypes[3]
+	return &file_rpc3_proto_enumTypes[6]
 }
 
 func (x MAIL) Number() protoreflect.EnumNumber {
@@ -226,7 +394,7 @@ func (x MAIL) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use MAIL.Descriptor instead.
 func (MAIL) EnumDescriptor() ([]byte, []int) {
-	return file_rpc3_proto_rawDescGZIP(), []int{3}
+	return file_rpc3_proto_rawDescGZIP(), []int{6}
 }
 
 // rpc 包头
@@ -235,14 +403,20 @@ type RpcHead struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id             int64   `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"` //token
-	SocketId       uint32  `protobuf:"varint,2,opt,name=SocketId,proto3" json:"SocketId,omitempty"`
-	SrcClusterId   uint32  `protobuf:"varint,3,opt,name=SrcClusterId,proto3" json:"SrcClusterId,omitempty"`                      //源集群id
-	ClusterId      uint32  `protobuf:"varint,4,opt,name=ClusterId,proto3" json:"ClusterId,omitempty"`                            //目标集群id
-	DestServerType SERVICE `protobuf:"varint,5,opt,name=DestServerType,proto3,enum=rpc.SERVICE" json:"DestServerType,omitempty"` //目标集群
-	SendType       SEND    `protobuf:"varint,6,opt,name=SendType,proto3,enum=rpc.SEND" json:"SendType,omitempty"`
-	ActorName      string  `protobuf:"bytes,7,opt,name=ActorName,proto3" json:"ActorName,omitempty"`
-	Reply          string  `protobuf:"bytes,8,opt,name=Reply,proto3" json:"Reply,omitempty"` //call sessionid
+	Id             int64             `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"` //token
+	SocketId       uint32            `protobuf:"varint,2,opt,name=SocketId,proto3" json:"SocketId,omitempty"`
+	SrcClusterId   uint32            `protobuf:"varint,3,opt,name=SrcClusterId,proto3" json:"SrcClusterId,omitempty"`                      //源集群id
+	ClusterId      uint32            `protobuf:"varint,4,opt,name=ClusterId,proto3" json:"ClusterId,omitempty"`                            //目标集群id
+	DestServerType SERVICE           `protobuf:"varint,5,opt,name=DestServerType,proto3,enum=rpc.SERVICE" json:"DestServerType,omitempty"` //目标集群
+	SendType       SEND              `protobuf:"varint,6,opt,name=SendType,proto3,enum=rpc.SEND" json:"SendType,omitempty"`
+	ActorName      string            `protobuf:"bytes,7,opt,name=ActorName,proto3" json:"ActorName,omitempty"`
+	Reply          string            `protobuf:"bytes,8,opt,name=Reply,proto3" json:"Reply,omitempty"`                                                                                               //call sessionid
+	Metadata       map[string]string `protobuf:"bytes,9,rep,name=Metadata,proto3" json:"Metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"` //透传元数据(locale, version...)
+	StreamId       int64             `protobuf:"varint,10,opt,name=StreamId,proto3" json:"StreamId,omitempty"`                                                                                       //流id
+	Frame          FRAME             `protobuf:"varint,11,opt,name=Frame,proto3,enum=rpc.FRAME" json:"Frame,omitempty"`                                                                              //流帧类型
+	ToServer       bool              `protobuf:"varint,12,opt,name=ToServer,proto3" json:"ToServer,omitempty"`                                                                                       //流帧发往服务端
+	RealmId        uint32            `protobuf:"varint,13,opt,name=RealmId,proto3" json:"RealmId,omitempty"`                                                                                         //目标区服, 0为本区服
+	SrcRealmId     uint32            `protobuf:"varint,14,opt,name=SrcRealmId,proto3" json:"SrcRealmId,omitempty"`                                                                                   //源区服
 }
 
 func (x *RpcHead) Reset() {
@@ -333,16 +507,60 @@ func (x *RpcHead) GetReply() string {
 	return ""
 }
 
+func (x *RpcHead) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *RpcHead) GetStreamId() int64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *RpcHead) GetFrame() FRAME {
+	if x != nil {
+		return x.Frame
+	}
+	return FRAME_DATA
+}
+
+func (x *RpcHead) GetToServer() bool {
+	if x != nil {
+		return x.ToServer
+	}
+	return false
+}
+
+func (x *RpcHead) GetRealmId() uint32 {
+	if x != nil {
+		return x.RealmId
+	}
+	return 0
+}
+
+func (x *RpcHead) GetSrcRealmId() uint32 {
+	if x != nil {
+		return x.SrcRealmId
+	}
+	return 0
+}
+
 // rpc 包
 type RpcPacket struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	FuncName string   `protobuf:"bytes,1,opt,name=FuncName,proto3" json:"FuncName,omitempty"`
-	ArgLen   int32    `protobuf:"varint,2,opt,name=ArgLen,proto3" json:"ArgLen,omitempty"`
-	RpcHead  *RpcHead `protobuf:"bytes,3,opt,name=RpcHead,proto3" json:"RpcHead,omitempty"`
-	RpcBody  []byte   `protobuf:"bytes,4,opt,name=RpcBody,proto3" json:"RpcBody,omitempty"`
+	FuncName    string   `protobuf:"bytes,1,opt,name=FuncName,proto3" json:"FuncName,omitempty"`
+	ArgLen      int32    `protobuf:"varint,2,opt,name=ArgLen,proto3" json:"ArgLen,omitempty"`
+	RpcHead     *RpcHead `protobuf:"bytes,3,opt,name=RpcHead,proto3" json:"RpcHead,omitempty"`
+	RpcBody     []byte   `protobuf:"bytes,4,opt,name=RpcBody,proto3" json:"RpcBody,omitempty"`
+	Fingerprint uint32   `protobuf:"varint,5,opt,name=Fingerprint,proto3" json:"Fingerprint,omitempty"`             //参数签名指纹
+	Compress    COMPRESS `protobuf:"varint,6,opt,name=Compress,proto3,enum=rpc.COMPRESS" json:"Compress,omitempty"` //RpcBody压缩算法
 }
 
 func (x *RpcPacket) Reset() {
@@ -405,17 +623,34 @@ func (x *RpcPacket) GetRpcBody() []byte {
 	return nil
 }
 
+func (x *RpcPacket) GetFingerprint() uint32 {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return 0
+}
+
+func (x *RpcPacket) GetCompress() COMPRESS {
+	if x != nil {
+		return x.Compress
+	}
+	return COMPRESS_RAW
+}
+
 // 集群信息
 type ClusterInfo struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Type     SERVICE `protobuf:"varint,1,opt,name=Type,proto3,enum=rpc.SERVICE" json:"Type,omitempty"`
-	Ip       string  `protobuf:"bytes,2,opt,name=Ip,proto3" json:"Ip,omitempty"`
-	Port     int32   `protobuf:"varint,3,opt,name=Port,proto3" json:"Port,omitempty"`
-	Weight   int32   `protobuf:"varint,4,opt,name=Weight,proto3" json:"Weight,omitempty"`
-	SocketId uint32  `protobuf:"varint,5,opt,name=SocketId,proto3" json:"SocketId,omitempty"`
+	Type     SERVICE          `protobuf:"varint,1,opt,name=Type,proto3,enum=rpc.SERVICE" json:"Type,omitempty"`
+	Ip       string           `protobuf:"bytes,2,opt,name=Ip,proto3" json:"Ip,omitempty"`
+	Port     int32            `protobuf:"varint,3,opt,name=Port,proto3" json:"Port,omitempty"`
+	Weight   int32            `protobuf:"varint,4,opt,name=Weight,proto3" json:"Weight,omitempty"`
+	SocketId uint32           `protobuf:"varint,5,opt,name=SocketId,proto3" json:"SocketId,omitempty"`
+	State    NODE             `protobuf:"varint,6,opt,name=State,proto3,enum=rpc.NODE" json:"State,omitempty"`                                                                               //节点状态
+	Load     int64            `protobuf:"varint,7,opt,name=Load,proto3" json:"Load,omitempty"`                                                                                               //负载(在线数等, 业务上报)
+	Metrics  map[string]int64 `protobuf:"bytes,8,rep,name=Metrics,proto3" json:"Metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"` //其他负载指标
 }
 
 func (x *ClusterInfo) Reset() {
@@ -485,6 +720,27 @@ func (x *ClusterInfo) GetSocketId() uint32 {
 	return 0
 }
 
+func (x *ClusterInfo) GetState() NODE {
+	if x != nil {
+		return x.State
+	}
+	return NODE_READY
+}
+
+func (x *ClusterInfo) GetLoad() int64 {
+	if x != nil {
+		return x.Load
+	}
+	return 0
+}
+
+func (x *ClusterInfo) GetMetrics() map[string]int64 {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
 // 原始包
 type Packet struct {
 	state         protoimpl.MessageState
@@ -705,7 +961,7 @@ var File_rpc3_proto protoreflect.FileDescriptor
 
 var file_rpc3_proto_rawDesc = []byte{
 	0x0a, 0x0a, 0x72, 0x70, 0x63, 0x33, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x72, 0x70,
-	0x63, 0x22, 0x88, 0x02, 0x0a, 0x07, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x12, 0x0e, 0x0a,
+	0x63, 0x22, 0x91, 0x04, 0x0a, 0x07, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x12, 0x0e, 0x0a,
 	0x02, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x49, 0x64, 0x12, 0x1a, 0x0a,
 	0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
 	0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x12, 0x22, 0x0a, 0x0c, 0x53, 0x72, 0x63,
@@ -721,24 +977,56 @@ var file_rpc3_proto_rawDesc = []byte{
 	0x53, 0x65, 0x6e, 0x64, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x41, 0x63, 0x74, 0x6f,
 	0x72, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x41, 0x63, 0x74,
 	0x6f, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x18,
-	0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x81, 0x01, 0x0a,
-	0x09, 0x52, 0x70, 0x63, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x46, 0x75,
-	0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x46, 0x75,
-	0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x41, 0x72, 0x67, 0x4c, 0x65, 0x6e,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x41, 0x72, 0x67, 0x4c, 0x65, 0x6e, 0x12, 0x26,
-	0x0a, 0x07, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
-	0x0c, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x52, 0x07, 0x52,
-	0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x52, 0x70, 0x63, 0x42, 0x6f, 0x64,
-	0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x52, 0x70, 0x63, 0x42, 0x6f, 0x64, 0x79,
-	0x22, 0x87, 0x01, 0x0a, 0x0b, 0x43, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f,
-	0x12, 0x20, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0c,
-	0x2e, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x45, 0x52, 0x56, 0x49, 0x43, 0x45, 0x52, 0x04, 0x54, 0x79,
-	0x70, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x49, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
-	0x49, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x50, 0x6f, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05,
-	0x52, 0x04, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74,
-	0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x1a,
-	0x0a, 0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d,
-	0x52, 0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x22, 0x70, 0x0a, 0x06, 0x50, 0x61,
+	0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x36, 0x0a, 0x08,
+	0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x2e, 0x4d, 0x65, 0x74,
+	0x61, 0x64, 0x61, 0x74, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x08, 0x4d, 0x65, 0x74, 0x61,
+	0x64, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64,
+	0x12, 0x20, 0x0a, 0x05, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x0a, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x46, 0x52, 0x41, 0x4d, 0x45, 0x52, 0x05, 0x46, 0x72, 0x61,
+	0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x54, 0x6f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x18, 0x0c,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x54, 0x6f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x18,
+	0x0a, 0x07, 0x52, 0x65, 0x61, 0x6c, 0x6d, 0x49, 0x64, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x52, 0x65, 0x61, 0x6c, 0x6d, 0x49, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x53, 0x72, 0x63, 0x52,
+	0x65, 0x61, 0x6c, 0x6d, 0x49, 0x64, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x53, 0x72,
+	0x63, 0x52, 0x65, 0x61, 0x6c, 0x6d, 0x49, 0x64, 0x1a, 0x3b, 0x0a, 0x0d, 0x4d, 0x65, 0x74, 0x61,
+	0x64, 0x61, 0x74, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0xce, 0x01, 0x0a, 0x09, 0x52, 0x70, 0x63, 0x50, 0x61, 0x63,
+	0x6b, 0x65, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12,
+	0x16, 0x0a, 0x06, 0x41, 0x72, 0x67, 0x4c, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x06, 0x41, 0x72, 0x67, 0x4c, 0x65, 0x6e, 0x12, 0x26, 0x0a, 0x07, 0x52, 0x70, 0x63, 0x48, 0x65,
+	0x61, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x52,
+	0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x52, 0x07, 0x52, 0x70, 0x63, 0x48, 0x65, 0x61, 0x64, 0x12,
+	0x18, 0x0a, 0x07, 0x52, 0x70, 0x63, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x07, 0x52, 0x70, 0x63, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x20, 0x0a, 0x0b, 0x46, 0x69, 0x6e,
+	0x67, 0x65, 0x72, 0x70, 0x72, 0x69, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b,
+	0x46, 0x69, 0x6e, 0x67, 0x65, 0x72, 0x70, 0x72, 0x69, 0x6e, 0x74, 0x12, 0x29, 0x0a, 0x08, 0x43,
+	0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x43, 0x4f, 0x4d, 0x50, 0x52, 0x45, 0x53, 0x53, 0x52, 0x08, 0x43, 0x6f,
+	0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x22, 0xb1, 0x02, 0x0a, 0x0b, 0x43, 0x6c, 0x75, 0x73, 0x74,
+	0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x20, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0c, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x45, 0x52, 0x56, 0x49,
+	0x43, 0x45, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x49, 0x70, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x49, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x50, 0x6f, 0x72, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x16, 0x0a, 0x06,
+	0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x57, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x53, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x49, 0x64,
+	0x12, 0x1f, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x09, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x4f, 0x44, 0x45, 0x52, 0x05, 0x53, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x4c, 0x6f, 0x61, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x04, 0x4c, 0x6f, 0x61, 0x64, 0x12, 0x37, 0x0a, 0x07, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73,
+	0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x43, 0x6c, 0x75,
+	0x73, 0x74, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x2e, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x1a, 0x3a,
+	0x0a, 0x0c, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x70, 0x0a, 0x06, 0x50, 0x61,
 	0x63, 0x6b, 0x65, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
 	0x52, 0x02, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x18, 0x02, 0x20,
 	0x01, 0x28, 0x09, 0x52, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x42, 0x75,
@@ -766,16 +1054,28 @@ var file_rpc3_proto_rawDesc = []byte{
 	0x45, 0x4e, 0x54, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x47, 0x41, 0x54, 0x45, 0x10, 0x02, 0x12,
 	0x08, 0x0a, 0x04, 0x47, 0x41, 0x4d, 0x45, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x5a, 0x4f, 0x4e,
 	0x45, 0x10, 0x04, 0x12, 0x06, 0x0a, 0x02, 0x44, 0x42, 0x10, 0x05, 0x12, 0x07, 0x0a, 0x03, 0x4e,
-	0x55, 0x4d, 0x10, 0x06, 0x2a, 0x21, 0x0a, 0x04, 0x53, 0x45, 0x4e, 0x44, 0x12, 0x09, 0x0a, 0x05,
+	0x55, 0x4d, 0x10, 0x06, 0x2a, 0x2e, 0x0a, 0x04, 0x53, 0x45, 0x4e, 0x44, 0x12, 0x09, 0x0a, 0x05,
 	0x50, 0x4f, 0x49, 0x4e, 0x54, 0x10, 0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x42, 0x4f, 0x41, 0x52, 0x44,
-	0x5f, 0x43, 0x41, 0x53, 0x54, 0x10, 0x01, 0x2a, 0x47, 0x0a, 0x04, 0x53, 0x54, 0x55, 0x42, 0x12,
-	0x0a, 0x0a, 0x06, 0x4d, 0x61, 0x73, 0x74, 0x65, 0x72, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x50,
-	0x6c, 0x61, 0x79, 0x65, 0x72, 0x4d, 0x67, 0x72, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x41, 0x63,
-	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x4d, 0x67, 0x72, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x43, 0x68,
-	0x61, 0x74, 0x4d, 0x67, 0x72, 0x10, 0x03, 0x12, 0x07, 0x0a, 0x03, 0x45, 0x4e, 0x44, 0x10, 0x04,
-	0x2a, 0x12, 0x0a, 0x04, 0x4d, 0x41, 0x49, 0x4c, 0x12, 0x0a, 0x0a, 0x06, 0x50, 0x6c, 0x61, 0x79,
-	0x65, 0x72, 0x10, 0x00, 0x42, 0x07, 0x5a, 0x05, 0x2f, 0x3b, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x5f, 0x43, 0x41, 0x53, 0x54, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x42, 0x41, 0x4c, 0x41, 0x4e,
+	0x43, 0x45, 0x10, 0x02, 0x2a, 0x39, 0x0a, 0x05, 0x46, 0x52, 0x41, 0x4d, 0x45, 0x12, 0x08, 0x0a,
+	0x04, 0x44, 0x41, 0x54, 0x41, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x4f, 0x50, 0x45, 0x4e, 0x10,
+	0x01, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x52, 0x45, 0x44, 0x49, 0x54, 0x10, 0x02, 0x12, 0x07, 0x0a,
+	0x03, 0x45, 0x4f, 0x53, 0x10, 0x03, 0x12, 0x07, 0x0a, 0x03, 0x45, 0x52, 0x52, 0x10, 0x04, 0x2a,
+	0x1e, 0x0a, 0x08, 0x43, 0x4f, 0x4d, 0x50, 0x52, 0x45, 0x53, 0x53, 0x12, 0x07, 0x0a, 0x03, 0x52,
+	0x41, 0x57, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x46, 0x4c, 0x41, 0x54, 0x45, 0x10, 0x01, 0x2a,
+	0x3b, 0x0a, 0x04, 0x4e, 0x4f, 0x44, 0x45, 0x12, 0x09, 0x0a, 0x05, 0x52, 0x45, 0x41, 0x44, 0x59,
+	0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x54, 0x41, 0x52, 0x54, 0x49, 0x4e, 0x47, 0x10, 0x01,
+	0x12, 0x0c, 0x0a, 0x08, 0x44, 0x52, 0x41, 0x49, 0x4e, 0x49, 0x4e, 0x47, 0x10, 0x02, 0x12, 0x0c,
+	0x0a, 0x08, 0x53, 0x54, 0x4f, 0x50, 0x50, 0x49, 0x4e, 0x47, 0x10, 0x03, 0x2a, 0x47, 0x0a, 0x04,
+	0x53, 0x54, 0x55, 0x42, 0x12, 0x0a, 0x0a, 0x06, 0x4d, 0x61, 0x73, 0x74, 0x65, 0x72, 0x10, 0x00,
+	0x12, 0x0d, 0x0a, 0x09, 0x50, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x4d, 0x67, 0x72, 0x10, 0x01, 0x12,
+	0x0e, 0x0a, 0x0a, 0x41, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x4d, 0x67, 0x72, 0x10, 0x02, 0x12,
+	0x0b, 0x0a, 0x07, 0x43, 0x68, 0x61, 0x74, 0x4d, 0x67, 0x72, 0x10, 0x03, 0x12, 0x07, 0x0a, 0x03,
+	0x45, 0x4e, 0x44, 0x10, 0x04, 0x2a, 0x33, 0x0a, 0x04, 0x4d, 0x41, 0x49, 0x4c, 0x12, 0x0a, 0x0a,
+	0x06, 0x50, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x47, 0x75, 0x69,
+	0x6c, 0x64, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x52, 0x6f, 0x6f, 0x6d, 0x10, 0x02, 0x12, 0x0a,
+	0x0a, 0x06, 0x42, 0x61, 0x74, 0x74, 0x6c, 0x65, 0x10, 0x03, 0x42, 0x07, 0x5a, 0x05, 0x2f, 0x3b,
+	0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -790,33 +1090,43 @@ func file_rpc3_proto_rawDescGZIP() []byte {
 	return file_rpc3_proto_rawDescData
 }
 
-var file_rpc3_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
-var file_rpc3_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_rpc3_proto_enumTypes = make([]protoimpl.EnumInfo, 7)
+var file_rpc3_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_rpc3_proto_goTypes = []interface{}{
 	(SERVICE)(0),        // 0: rpc.SERVICE
 	(SEND)(0),           // 1: rpc.SEND
-	(STUB)(0),           // 2: rpc.STUB
-	(MAIL)(0),           // 3: rpc.MAIL
-	(*RpcHead)(nil),     // 4: rpc.RpcHead
-	(*RpcPacket)(nil),   // 5: rpc.RpcPacket
-	(*ClusterInfo)(nil), // 6: rpc.ClusterInfo
-	(*Packet)(nil),      // 7: rpc.Packet
-	(*MailBox)(nil),     // 8: rpc.MailBox
-	(*StubMailBox)(nil), // 9: rpc.StubMailBox
+	(FRAME)(0),          // 2: rpc.FRAME
+	(COMPRESS)(0),       // 3: rpc.COMPRESS
+	(NODE)(0),           // 4: rpc.NODE
+	(STUB)(0),           // 5: rpc.STUB
+	(MAIL)(0),           // 6: rpc.MAIL
+	(*RpcHead)(nil),     // 7: rpc.RpcHead
+	(*RpcPacket)(nil),   // 8: rpc.RpcPacket
+	(*ClusterInfo)(nil), // 9: rpc.ClusterInfo
+	(*Packet)(nil),      // 10: rpc.Packet
+	(*MailBox)(nil),     // 11: rpc.MailBox
+	(*StubMailBox)(nil), // 12: rpc.StubMailBox
+	nil,                 // 13: rpc.RpcHead.MetadataEntry
+	nil,                 // 14: rpc.ClusterInfo.MetricsEntry
 }
 var file_rpc3_proto_depIdxs = []int32{
-	0, // 0: rpc.RpcHead.DestServerType:type_name -> rpc.SERVICE
-	1, // 1: rpc.RpcHead.SendType:type_name -> rpc.SEND
-	4, // 2: rpc.RpcPacket.RpcHead:type_name -> rpc.RpcHead
-	0, // 3: rpc.ClusterInfo.Type:type_name -> rpc.SERVICE
-	5, // 4: rpc.Packet.RpcPacket:type_name -> rpc.RpcPacket
-	3, // 5: rpc.MailBox.MailType:type_name -> rpc.MAIL
-	2, // 6: rpc.StubMailBox.StubType:type_name -> rpc.STUB
-	7, // [7:7] is the sub-list for method output_type
-	7, // [7:7] is the sub-list for method input_type
-	7, // [7:7] is the sub-list for extension type_name
-	7, // [7:7] is the sub-list for extension extendee
-	0, // [0:7] is the sub-list for field type_name
+	0,  // 0: rpc.RpcHead.DestServerType:type_name -> rpc.SERVICE
+	1,  // 1: rpc.RpcHead.SendType:type_name -> rpc.SEND
+	13, // 2: rpc.RpcHead.Metadata:type_name -> rpc.RpcHead.MetadataEntry
+	2,  // 3: rpc.RpcHead.Frame:type_name -> rpc.FRAME
+	7,  // 4: rpc.RpcPacket.RpcHead:type_name -> rpc.RpcHead
+	3,  // 5: rpc.RpcPacket.Compress:type_name -> rpc.COMPRESS
+	0,  // 6: rpc.ClusterInfo.Type:type_name -> rpc.SERVICE
+	4,  // 7: rpc.ClusterInfo.State:type_name -> rpc.NODE
+	14, // 8: rpc.ClusterInfo.Metrics:type_name -> rpc.ClusterInfo.MetricsEntry
+	8,  // 9: rpc.Packet.RpcPacket:type_name -> rpc.RpcPacket
+	6,  // 10: rpc.MailBox.MailType:type_name -> rpc.MAIL
+	5,  // 11: rpc.StubMailBox.StubType:type_name -> rpc.STUB
+	12, // [12:12] is the sub-list for method output_type
+	12, // [12:12] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_rpc3_proto_init() }
@@ -903,8 +1213,8 @@ func file_rpc3_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_rpc3_proto_rawDesc,
-			NumEnums:      4,
-			NumMessages:   6,
+			NumEnums:      7,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
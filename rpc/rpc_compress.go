@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/fengqk/mars-base/base"
+)
+
+var (
+	flateWriterPool = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+
+	ErrCompressOverflow = errors.New("rpc body decompress over MAX_PACKET")
+)
+
+// 按传输层各自的阈值压缩线上数据, 未达阈值或无收益时返回原来的Buff
+// threshold为0不压缩, 所有节点升级到支持解压的版本后再开启
+func Compress(packet Packet, threshold int) []byte {
+	rpcPacket := packet.RpcPacket
+	if threshold <= 0 || rpcPacket == nil || rpcPacket.Compress != COMPRESS_RAW || len(rpcPacket.RpcBody) < threshold {
+		return packet.Buff
+	}
+	wire := &RpcPacket{FuncName: rpcPacket.FuncName, ArgLen: rpcPacket.ArgLen, RpcHead: rpcPacket.RpcHead, RpcBody: rpcPacket.RpcBody, Fingerprint: rpcPacket.Fingerprint}
+	if !compress(wire, threshold) {
+		return packet.Buff
+	}
+	return marshalFrame(wire, 0, false)
+}
+
+// RpcBody超过threshold时压缩, 返回是否压缩
+func compress(rpcPacket *RpcPacket, threshold int) bool {
+	if threshold <= 0 || len(rpcPacket.RpcBody) < threshold {
+		return false
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(rpcPacket.RpcBody)/2))
+	w := flateWriterPool.Get().(*flate.Writer)
+	w.Reset(buf)
+	_, err := w.Write(rpcPacket.RpcBody)
+	if err == nil {
+		err = w.Close()
+	}
+	flateWriterPool.Put(w)
+	//压缩无收益时保持原样
+	if err == nil && buf.Len() < len(rpcPacket.RpcBody) {
+		rpcPacket.RpcBody = buf.Bytes()
+		rpcPacket.Compress = COMPRESS_FLATE
+		return true
+	}
+	return false
+}
+
+func decompress(rpcPacket *RpcPacket) error {
+	switch rpcPacket.Compress {
+	case COMPRESS_FLATE:
+		r := flate.NewReader(bytes.NewReader(rpcPacket.RpcBody))
+		defer r.Close()
+		body, err := io.ReadAll(io.LimitReader(r, base.MAX_PACKET+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > base.MAX_PACKET {
+			return ErrCompressOverflow
+		}
+		rpcPacket.RpcBody = body
+		rpcPacket.Compress = COMPRESS_RAW
+	}
+	return nil
+}
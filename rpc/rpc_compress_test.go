@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fengqk/mars-base/base"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	tests := []struct {
+		threshold int
+		body      string
+		compress  COMPRESS
+	}{
+		{0, strings.Repeat("a", 4096), COMPRESS_RAW},      //未开启
+		{1024, strings.Repeat("a", 100), COMPRESS_RAW},    //未到阈值
+		{1024, strings.Repeat("a", 4096), COMPRESS_FLATE}, //压缩
+		{16, "abcdefghijklmnopqrstuvwxyz", COMPRESS_RAW},  //无收益不压缩
+	}
+	for _, tt := range tests {
+		head, funcName := RpcHead{Id: 1}, "Player.Chat"
+		packet := Marshal(&head, &funcName, tt.body)
+		head, funcName = RpcHead{Id: 1}, "Player.Chat"
+		frame := MarshalTo(&head, &funcName, 0, tt.threshold, tt.body)
+		for _, data := range [][]byte{Compress(packet, tt.threshold), frame} {
+			wire := &RpcPacket{}
+			if err := proto.Unmarshal(data, wire); err != nil {
+				t.Fatal(err)
+			}
+			if wire.Compress != tt.compress {
+				t.Errorf("threshold %d body %d compress %v, want %v", tt.threshold, len(tt.body), wire.Compress, tt.compress)
+			}
+			rpcPacket, _, err := UnmarshalPacket(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rpcPacket.RpcBody, packet.RpcPacket.RpcBody) {
+				t.Errorf("threshold %d body %d round trip mismatch", tt.threshold, len(tt.body))
+			}
+		}
+		//Marshal本身不压缩, 本地投递的包保持原文
+		if packet.RpcPacket.Compress != COMPRESS_RAW || !bytes.Equal(Compress(packet, 0), packet.Buff) {
+			t.Errorf("marshal compressed")
+		}
+	}
+}
+
+func TestDecompressError(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		err  bool
+	}{
+		{"corrupt", []byte{0xff, 0xff, 0xff}, true},
+		{"overflow", bombBody(t), true},
+	}
+	for _, tt := range tests {
+		data, _ := proto.Marshal(&RpcPacket{FuncName: "Player.Chat", RpcHead: &RpcHead{}, RpcBody: tt.body, Compress: COMPRESS_FLATE})
+		_, _, err := UnmarshalPacket(data)
+		if (err != nil) != tt.err {
+			t.Errorf("%s err %v", tt.name, err)
+		}
+	}
+}
+
+// 解压后超过MAX_PACKET的包
+func bombBody(t *testing.T) []byte {
+	rpcPacket := &RpcPacket{RpcBody: make([]byte, base.MAX_PACKET+1)}
+	if !compress(rpcPacket, 1) {
+		t.Fatal("bomb not compressed")
+	}
+	return rpcPacket.RpcBody
+}
@@ -14,10 +14,26 @@ import (
 // rpc UnmarshalHead
 func UnmarshalHead(buff []byte) (*RpcPacket, RpcHead) {
 	nLen := base.Clamp(len(buff), 0, 256)
-	return Unmarshal(buff[:nLen])
+	return unmarshal(buff[:nLen])
 }
 
+// 解压失败时RpcBody为nil, 投递给handler的路径使用UnmarshalPacket
 func Unmarshal(buff []byte) (*RpcPacket, RpcHead) {
+	rpcPacket, head, err := UnmarshalPacket(buff)
+	if err != nil {
+		base.LOG.Printf("rpc [%s] decompress error %v", rpcPacket.FuncName, err)
+		rpcPacket.RpcBody = nil
+	}
+	return rpcPacket, head
+}
+
+// 同Unmarshal, 解压失败返回错误, 包应丢弃
+func UnmarshalPacket(buff []byte) (*RpcPacket, RpcHead, error) {
+	rpcPacket, head := unmarshal(buff)
+	return rpcPacket, head, decompress(rpcPacket)
+}
+
+func unmarshal(buff []byte) (*RpcPacket, RpcHead) {
 	rpcPacket := &RpcPacket{}
 	proto.Unmarshal(buff, rpcPacket)
 	if rpcPacket.RpcHead == nil {
@@ -35,7 +51,7 @@ func UnmarshalBody(rpcPacket *RpcPacket, pFuncType reflect.Type) []interface{} {
 	dec := gob.NewDecoder(buf)
 	for i := 1; i < nCurLen; i++ {
 		if i == 1 {
-			params[1] = context.WithValue(context.Background(), "rpcHead", *(*RpcHead)(rpcPacket.RpcHead))
+			params[1] = NewContext(context.Background(), rpcPacket.RpcHead)
 			continue
 		}
 
@@ -60,7 +76,7 @@ func UnmarshalBodyCall(rpcPacket *RpcPacket, pFuncType reflect.Type) (error, []i
 	}
 	for i := 0; i < nCurLen; i++ {
 		if i == 0 {
-			params[0] = context.WithValue(context.Background(), "rpcHead", *(*RpcHead)(rpcPacket.RpcHead))
+			params[0] = NewContext(context.Background(), rpcPacket.RpcHead)
 			continue
 		}
 
@@ -73,6 +89,22 @@ func UnmarshalBodyCall(rpcPacket *RpcPacket, pFuncType reflect.Type) (error, []i
 	return nil, params
 }
 
+// rpc UnmarshalStream
+// 流帧按顺序解码到params指针
+func UnmarshalStream(rpcPacket *RpcPacket, params ...interface{}) error {
+	buf := bytes.NewBuffer(rpcPacket.RpcBody)
+	dec := gob.NewDecoder(buf)
+	for i, param := range params {
+		if i >= int(rpcPacket.ArgLen) {
+			break
+		}
+		if err := dec.Decode(param); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // rpc  UnmarshalPB
 func unmarshalPB(bitstream *base.BitStream) (proto.Message, error) {
 	packetName := bitstream.ReadString()
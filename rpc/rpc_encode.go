@@ -2,12 +2,262 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"reflect"
+	"sync"
 
 	"github.com/fengqk/mars-base/base"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	protov2 "google.golang.org/protobuf/proto"
 )
 
+const (
+	MAX_POOL_BUFFER    = 64 * 1024 //超过的buffer不回收, 避免大包撑大池子
+	MAX_ENCODER_PARAMS = 4         //参数个数不超过的按签名复用gob encoder
+	MAX_ENCODER_TYPES  = 4 * 1024  //encoder缓存的类型定义超过后不再复用
+	RPC_BODY_FIELD     = 4         //RpcPacket.RpcBody的字段号
+	MAX_POOL_FRAME     = 1024      //空闲发送帧的个数上限
+)
+
+type (
+	// gob encoder只在首次遇到类型时写类型定义, 而每个包都是独立解码
+	// 按参数类型复用encoder, 并缓存它写过的类型定义, 每个包都带上完整的类型定义
+	encoder struct {
+		buf   bytes.Buffer
+		enc   *gob.Encoder
+		types []byte
+	}
+
+	encoderKey struct {
+		n     int
+		types [MAX_ENCODER_PARAMS]reflect.Type
+	}
+)
+
+var (
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+	rpcPacketPool = sync.Pool{
+		New: func() interface{} {
+			return new(RpcPacket)
+		},
+	}
+	encoderPoolMap sync.Map //encoderKey -> *sync.Pool
+	framePool      = make(chan []byte, MAX_POOL_FRAME)
+)
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= MAX_POOL_BUFFER {
+		bufferPool.Put(buf)
+	}
+}
+
+// 取空闲发送帧, 容量不够的丢弃重新分配
+func getFrame(size int) []byte {
+	select {
+	case frame := <-framePool:
+		if cap(frame) >= size {
+			return frame[:0]
+		}
+	default:
+	}
+	return make([]byte, 0, size)
+}
+
+// 发送帧写出后归还给MarshalTo复用, 归还后调用方不能再引用
+func PutFrame(frame []byte) {
+	if cap(frame) == 0 || cap(frame) > MAX_POOL_BUFFER {
+		return
+	}
+	select {
+	case framePool <- frame:
+	default:
+	}
+}
+
+func newEncoder() interface{} {
+	e := &encoder{}
+	e.enc = gob.NewEncoder(&e.buf)
+	return e
+}
+
+func getEncoderPool(params []interface{}) *sync.Pool {
+	if len(params) > MAX_ENCODER_PARAMS {
+		return nil
+	}
+	key := encoderKey{n: len(params)}
+	for i, param := range params {
+		if param == nil {
+			return nil
+		}
+		key.types[i] = reflect.TypeOf(param)
+	}
+	if pool, bEx := encoderPoolMap.Load(key); bEx {
+		return pool.(*sync.Pool)
+	}
+	//接口值的具体类型定义写在值消息内部, 无法缓存, 含接口的签名不复用
+	var pool *sync.Pool
+	visited := map[reflect.Type]bool{}
+	bOk := true
+	for _, t := range key.types[:key.n] {
+		bOk = bOk && !hasInterface(t, visited)
+	}
+	if bOk {
+		pool = &sync.Pool{New: newEncoder}
+	}
+	val, _ := encoderPoolMap.LoadOrStore(key, pool)
+	return val.(*sync.Pool)
+}
+
+func hasInterface(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasInterface(t.Elem(), visited)
+	case reflect.Map:
+		return hasInterface(t.Key(), visited) || hasInterface(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasInterface(t.Field(i).Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gob消息为长度+消息体, 消息体以类型id开头, 负数为类型定义
+func gobUint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	if data[0] < 0x80 {
+		return uint64(data[0]), 1
+	}
+	n := -int(int8(data[0]))
+	if n > 8 || len(data) < n+1 {
+		return 0, 0
+	}
+	x := uint64(0)
+	for _, b := range data[1 : n+1] {
+		x = x<<8 | uint64(b)
+	}
+	return x, n + 1
+}
+
+// 取出新写入的类型定义, 只有签名不含接口时类型定义才都是单独的消息
+func appendGobTypes(types []byte, data []byte) ([]byte, bool) {
+	for len(data) > 0 {
+		size, n := gobUint(data)
+		if n == 0 || uint64(len(data)-n) < size {
+			return types, false
+		}
+		msg := data[:n+int(size)]
+		id, m := gobUint(msg[n:])
+		if m == 0 {
+			return types, false
+		}
+		if id&1 == 1 {
+			types = append(types, msg...)
+		}
+		data = data[len(msg):]
+	}
+	return types, true
+}
+
+func encodeParams(buf *bytes.Buffer, params []interface{}) {
+	pool := getEncoderPool(params)
+	if pool == nil {
+		enc := gob.NewEncoder(buf)
+		for _, param := range params {
+			enc.Encode(param)
+		}
+		return
+	}
+
+	e := pool.Get().(*encoder)
+	e.buf.Reset()
+	bOk := true
+	for _, param := range params {
+		if err := e.enc.Encode(param); err != nil {
+			bOk = false
+			break
+		}
+	}
+	buf.Write(e.types)
+	buf.Write(e.buf.Bytes())
+	//出错的encoder状态不确定, 不再复用
+	if bOk {
+		e.types, bOk = appendGobTypes(e.types, e.buf.Bytes())
+	}
+	if bOk && len(e.types) <= MAX_ENCODER_TYPES && e.buf.Cap() <= MAX_POOL_BUFFER {
+		pool.Put(e)
+	}
+}
+
+func newRpcPacket(head *RpcHead, funcName *string, buf *bytes.Buffer, params []interface{}) *RpcPacket {
+	rpcPacket := &RpcPacket{}
+	initRpcPacket(rpcPacket, head, funcName, buf, params)
+	return rpcPacket
+}
+
+func initRpcPacket(rpcPacket *RpcPacket, head *RpcHead, funcName *string, buf *bytes.Buffer, params []interface{}) {
+	//首个参数为handler的ctx时转发其metadata, ctx本身不编码
+	if len(params) > 0 {
+		if ctx, bOk := params[0].(context.Context); bOk {
+			ForwardHead(ctx, head)
+			params = params[1:]
+		}
+	}
+	*funcName = Route(head, *funcName)
+	rpcPacket.FuncName, rpcPacket.ArgLen, rpcPacket.RpcHead = *funcName, int32(len(params)), head
+	if *funcName != "" {
+		rpcPacket.Fingerprint = paramsFingerprint(params)
+	}
+	encodeParams(buf, params)
+}
+
+// 编码成帧, 前headroom字节留空, 整帧只分配一次, pooled时从空闲帧中取
+// RpcBody单独追加在帧尾, 之后rpcPacket.RpcBody引用帧内的数据
+func marshalFrame(rpcPacket *RpcPacket, headroom int, pooled bool) []byte {
+	body := rpcPacket.RpcBody
+	rpcPacket.RpcBody = nil
+	opts := protov2.MarshalOptions{}
+	size := headroom + opts.Size(rpcPacket)
+	if len(body) > 0 {
+		size += protowire.SizeTag(RPC_BODY_FIELD) + protowire.SizeBytes(len(body))
+	}
+	var data []byte
+	if pooled {
+		data = getFrame(size)[:headroom]
+	} else {
+		data = make([]byte, headroom, size)
+	}
+	data, _ = opts.MarshalAppend(data, rpcPacket)
+	if len(body) > 0 {
+		data = protowire.AppendTag(data, RPC_BODY_FIELD, protowire.BytesType)
+		data = protowire.AppendBytes(data, body)
+		rpcPacket.RpcBody = data[len(data)-len(body):]
+	}
+	return data
+}
+
 // rpc  Marshal
 func Marshal(head *RpcHead, funcName *string, params ...interface{}) Packet {
 	return marshal(head, funcName, params...)
@@ -21,16 +271,42 @@ func marshal(head *RpcHead, funcName *string, params ...interface{}) Packet {
 		}
 	}()
 
-	*funcName = Route(head, *funcName)
-	rpcPacket := &RpcPacket{FuncName: *funcName, ArgLen: int32(len(params)), RpcHead: (*RpcHead)(head)}
-	buf := bytes.NewBuffer([]byte{})
-	enc := gob.NewEncoder(buf)
-	for _, param := range params {
-		enc.Encode(param)
-	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	rpcPacket := newRpcPacket(head, funcName, buf, params)
+	rpcPacket.RpcBody = buf.Bytes()
+	//本地投递直接引用帧内的RpcBody, 压缩由各传输层按配置调用Compress
+	return Packet{Buff: marshalFrame(rpcPacket, 0, false), RpcPacket: rpcPacket}
+}
+
+// 修改包头后重新编码, 如转发时改写路由字段, rpcPacket.RpcBody为解压后的原文
+func MarshalHead(rpcPacket *RpcPacket, head *RpcHead) Packet {
+	packet := &RpcPacket{FuncName: rpcPacket.FuncName, ArgLen: rpcPacket.ArgLen, RpcHead: head, RpcBody: rpcPacket.RpcBody, Fingerprint: rpcPacket.Fingerprint}
+	return Packet{Buff: marshalFrame(packet, 0, false), RpcPacket: packet}
+}
+
+// rpc  MarshalTo
+// 直接编码成发送帧, 前headroom字节留给包长由调用方原地写入
+// 帧从空闲帧中取, 写出后由调用方PutFrame归还, RpcBody超过threshold时压缩, 0不压缩
+func MarshalTo(head *RpcHead, funcName *string, headroom, threshold int, params ...interface{}) []byte {
+	defer func() {
+		if err := recover(); err != nil {
+			base.TraceCode(err)
+		}
+	}()
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	//帧内不引用rpcPacket, 编码后即可复用
+	rpcPacket := rpcPacketPool.Get().(*RpcPacket)
+	defer func() {
+		rpcPacket.Reset()
+		rpcPacketPool.Put(rpcPacket)
+	}()
+	initRpcPacket(rpcPacket, head, funcName, buf, params)
 	rpcPacket.RpcBody = buf.Bytes()
-	data, _ := proto.Marshal(rpcPacket)
-	return Packet{Buff: data, RpcPacket: rpcPacket}
+	compress(rpcPacket, threshold)
+	return marshalFrame(rpcPacket, headroom, true)
 }
 
 // rpc  MarshalPB
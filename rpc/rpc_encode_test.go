@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type encodeItem struct {
+	Id    int64
+	Name  string
+	Count []int32
+	Attr  map[string]int64
+}
+
+type encodeActor struct{}
+
+func (a *encodeActor) Buy(ctx context.Context, id int64, item *encodeItem, tags []string) {}
+
+func benchParams() []interface{} {
+	item := &encodeItem{Id: 1, Name: "sword", Count: []int32{1, 2, 3}, Attr: map[string]int64{"atk": 10}}
+	return []interface{}{int64(100), item, []string{"a", "b"}}
+}
+
+// 复用encoder后每个包仍需能被独立的decoder解码
+func TestMarshalIndependentDecode(t *testing.T) {
+	funcType := reflect.TypeOf((*encodeActor).Buy)
+	for i := 0; i < 3; i++ {
+		for _, packet := range [][]byte{
+			func() []byte {
+				head, funcName := RpcHead{Id: int64(i)}, "Player.Buy"
+				return Marshal(&head, &funcName, benchParams()...).Buff
+			}(),
+			func() []byte {
+				head, funcName := RpcHead{Id: int64(i)}, "Player.Buy"
+				return MarshalTo(&head, &funcName, 4, 0, benchParams()...)[4:]
+			}(),
+		} {
+			rpcPacket, head, err := UnmarshalPacket(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head.Id != int64(i) || rpcPacket.FuncName != "Buy" || head.ActorName != "Player" || rpcPacket.Fingerprint == 0 {
+				t.Fatalf("head %v func %s", head.Id, rpcPacket.FuncName)
+			}
+			params := UnmarshalBody(rpcPacket, funcType)
+			want := benchParams()
+			if params[2] != want[0] || !reflect.DeepEqual(params[3], want[1]) || !reflect.DeepEqual(params[4], want[2]) {
+				t.Fatalf("round %d params %v", i, params[2:])
+			}
+		}
+	}
+}
+
+// 本地投递的RpcBody与线上解码的一致
+func TestMarshalLocalBody(t *testing.T) {
+	head, funcName := RpcHead{Id: 1}, "Player.Buy"
+	packet := Marshal(&head, &funcName, benchParams()...)
+	rpcPacket, _, err := UnmarshalPacket(packet.Buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rpcPacket.RpcBody, packet.RpcPacket.RpcBody) {
+		t.Fatal("local body mismatch")
+	}
+}
+
+// 改动前的编码路径: 每包新建gob encoder, proto.Marshal后再拷贝进带包长的帧
+func marshalBaseline(head *RpcHead, funcName *string, headroom int, params ...interface{}) []byte {
+	*funcName = Route(head, *funcName)
+	rpcPacket := &RpcPacket{FuncName: *funcName, ArgLen: int32(len(params)), RpcHead: head}
+	rpcPacket.Fingerprint = paramsFingerprint(params)
+	buf := bytes.NewBuffer([]byte{})
+	enc := gob.NewEncoder(buf)
+	for _, param := range params {
+		enc.Encode(param)
+	}
+	rpcPacket.RpcBody = buf.Bytes()
+	data, _ := proto.Marshal(rpcPacket)
+	frame := make([]byte, headroom+len(data))
+	copy(frame[headroom:], data)
+	return frame
+}
+
+// 新旧路径编出的帧解码一致, 字段和类型定义的顺序可能不同
+func TestMarshalToBaseline(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		head, funcName := RpcHead{Id: 1}, "Player.Buy"
+		want, _, err := UnmarshalPacket(marshalBaseline(&head, &funcName, 4, benchParams()...)[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, funcName = RpcHead{Id: 1}, "Player.Buy"
+		frame := MarshalTo(&head, &funcName, 4, 0, benchParams()...)
+		got, _, err := UnmarshalPacket(frame[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		funcType := reflect.TypeOf((*encodeActor).Buy)
+		if got.FuncName != want.FuncName || got.Fingerprint != want.Fingerprint || !proto.Equal(got.RpcHead, want.RpcHead) ||
+			!reflect.DeepEqual(UnmarshalBody(got, funcType)[2:], UnmarshalBody(want, funcType)[2:]) {
+			t.Fatalf("round %d frame mismatch", i)
+		}
+		PutFrame(frame)
+	}
+}
+
+func BenchmarkMarshalBaseline(b *testing.B) {
+	params := benchParams()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		head, funcName := RpcHead{Id: 1}, "Player.Buy"
+		marshalBaseline(&head, &funcName, 4, params...)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	params := benchParams()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		head, funcName := RpcHead{Id: 1}, "Player.Buy"
+		Marshal(&head, &funcName, params...)
+	}
+}
+
+// 与socket发送一致, 帧写出后归还
+func BenchmarkMarshalTo(b *testing.B) {
+	params := benchParams()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		head, funcName := RpcHead{Id: 1}, "Player.Buy"
+		PutFrame(MarshalTo(&head, &funcName, 4, 0, params...))
+	}
+}
+
+type (
+	encodeAny struct {
+		V interface{}
+	}
+	encodeA struct{ A int }
+	encodeB struct{ B string }
+)
+
+func (a *encodeActor) Any(ctx context.Context, v encodeAny) {}
+
+// 接口字段的具体类型定义写在值消息内, 含接口的签名不复用encoder
+func TestMarshalInterfaceTypes(t *testing.T) {
+	gob.Register(encodeA{})
+	gob.Register(encodeB{})
+	funcType := reflect.TypeOf((*encodeActor).Any)
+	for _, v := range []interface{}{encodeA{1}, encodeB{"b"}, encodeA{2}, encodeB{"c"}} {
+		head, funcName := RpcHead{}, "Player.Any"
+		rpcPacket, _, err := UnmarshalPacket(Marshal(&head, &funcName, encodeAny{v}).Buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := UnmarshalBody(rpcPacket, funcType)
+		if got := params[2].(encodeAny).V; got != v {
+			t.Fatalf("got %v, want %v", got, v)
+		}
+	}
+}
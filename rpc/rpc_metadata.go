@@ -0,0 +1,58 @@
+package rpc
+
+import "context"
+
+type (
+	rpcHeadKey struct{}
+)
+
+// 把rpchead放入context, 供handler读取, handler不应修改head
+func NewContext(ctx context.Context, head *RpcHead) context.Context {
+	return context.WithValue(ctx, rpcHeadKey{}, head)
+}
+
+// 从handler的context读取rpchead
+func FromContext(ctx context.Context) (*RpcHead, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	head, bOk := ctx.Value(rpcHeadKey{}).(*RpcHead)
+	return head, bOk && head != nil
+}
+
+// 从handler的context读取metadata
+func GetMeta(ctx context.Context, key string) (string, bool) {
+	head, bOk := FromContext(ctx)
+	if !bOk {
+		return "", false
+	}
+	val, bEx := head.Metadata[key]
+	return val, bEx
+}
+
+// 设置metadata, head按值传递, 写时复制避免修改到其他副本共享的map
+func (x *RpcHead) SetMeta(key, val string) {
+	metadata := make(map[string]string, len(x.Metadata)+1)
+	for k, v := range x.Metadata {
+		metadata[k] = v
+	}
+	metadata[key] = val
+	x.Metadata = metadata
+}
+
+// 转发调用时从ctx继承metadata, head上已有的key优先
+// Marshal的首个参数为context.Context时自动调用
+func ForwardHead(ctx context.Context, head *RpcHead) {
+	src, bOk := FromContext(ctx)
+	if !bOk || len(src.Metadata) == 0 {
+		return
+	}
+	metadata := make(map[string]string, len(src.Metadata)+len(head.Metadata))
+	for k, v := range src.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	head.Metadata = metadata
+}
@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// 首个参数为handler的ctx时, metadata随转发的调用传递, ctx不占参数位
+func TestForwardHead(t *testing.T) {
+	src := &RpcHead{Metadata: map[string]string{"locale": "en", "ver": "1"}}
+	ctx := NewContext(context.Background(), src)
+	tests := []struct {
+		name   string
+		head   RpcHead
+		params []interface{}
+		want   map[string]string
+	}{
+		{"inherit", RpcHead{}, []interface{}{ctx}, map[string]string{"locale": "en", "ver": "1"}},
+		{"head first", RpcHead{Metadata: map[string]string{"ver": "2"}}, []interface{}{ctx}, map[string]string{"locale": "en", "ver": "2"}},
+		{"no ctx", RpcHead{Metadata: map[string]string{"gm": "1"}}, nil, map[string]string{"gm": "1"}},
+		{"empty ctx", RpcHead{}, []interface{}{context.Background()}, nil},
+	}
+	funcType := reflect.TypeOf((*encodeActor).Buy)
+	for _, test := range tests {
+		head, funcName := test.head, "Player.Buy"
+		packet := Marshal(&head, &funcName, append(test.params, benchParams()...)...)
+		rpcPacket, head1, err := UnmarshalPacket(packet.Buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(head1.Metadata) != len(test.want) || (len(test.want) > 0 && !reflect.DeepEqual(head1.Metadata, test.want)) {
+			t.Errorf("%s: metadata %v, want %v", test.name, head1.Metadata, test.want)
+		}
+		if rpcPacket.ArgLen != 3 {
+			t.Errorf("%s: arg len %d", test.name, rpcPacket.ArgLen)
+		}
+		params := UnmarshalBody(rpcPacket, funcType)
+		if params[2] != benchParams()[0] {
+			t.Errorf("%s: params %v", test.name, params[2:])
+		}
+		if val, _ := GetMeta(params[1].(context.Context), "ver"); val != test.want["ver"] {
+			t.Errorf("%s: handler meta ver %s", test.name, val)
+		}
+	}
+	//源head不被修改
+	if len(src.Metadata) != 2 || src.Metadata["ver"] != "1" {
+		t.Fatalf("source metadata changed %v", src.Metadata)
+	}
+}
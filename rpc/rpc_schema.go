@@ -0,0 +1,274 @@
+package rpc
+
+import (
+	"context"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// 方法签名
+	MethodSchema struct {
+		Name        string   `json:"name"`
+		Params      []string `json:"params"`
+		Fingerprint uint32   `json:"fingerprint"`
+	}
+
+	// actor名 -> 方法名 -> 签名
+	Schema map[string]map[string]*MethodSchema
+
+	SchemaDiff struct {
+		Method   string
+		Old      *MethodSchema
+		New      *MethodSchema
+		Breaking bool
+	}
+)
+
+var (
+	contextType         = reflect.TypeOf((*context.Context)(nil)).Elem()
+	gobEncoderType      = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	schema              = Schema{}
+	schemaLocker        = &sync.RWMutex{}
+	kindMap             sync.Map //reflect.Type -> string, 参数类型的签名
+)
+
+// gob对指针透明, 签名只看元素类型
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+// 参数类型的签名按类型缓存, 发送路径上不重复展开结构体字段
+func typeKind(t reflect.Type) string {
+	if kind, bEx := kindMap.Load(t); bEx {
+		return kind.(string)
+	}
+	kind := string(appendKind(nil, t, map[reflect.Type]bool{}))
+	kindMap.Store(t, kind)
+	return kind
+}
+
+// gob能互相解码的类型归为同一类: 各宽度的有符号整数, 无符号整数, 浮点数, 以及底层类型相同的命名类型
+// 结构体按字段名解码, 保留类型名和导出字段, 增删字段视为不一致
+func appendKind(buf []byte, t reflect.Type, visited map[reflect.Type]bool) []byte {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(buf, "int"...)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return append(buf, "uint"...)
+	case reflect.Float32, reflect.Float64:
+		return append(buf, "float"...)
+	case reflect.Complex64, reflect.Complex128:
+		return append(buf, "complex"...)
+	case reflect.Bool:
+		return append(buf, "bool"...)
+	case reflect.String:
+		return append(buf, "string"...)
+	case reflect.Slice:
+		return appendKind(append(buf, "[]"...), t.Elem(), visited)
+	case reflect.Array:
+		buf = append(buf, '[')
+		buf = strconv.AppendInt(buf, int64(t.Len()), 10)
+		return appendKind(append(buf, ']'), t.Elem(), visited)
+	case reflect.Map:
+		buf = appendKind(append(buf, "map["...), t.Key(), visited)
+		return appendKind(append(buf, ']'), t.Elem(), visited)
+	case reflect.Struct:
+		return appendStruct(buf, t, visited)
+	}
+	return append(buf, t.String()...)
+}
+
+// 类型名{字段哈希}, 字段按名字排序后计算, gob按名字匹配字段, 调整顺序仍兼容
+// 自定义编码的类型和递归出现的类型只保留类型名
+func appendStruct(buf []byte, t reflect.Type, visited map[reflect.Type]bool) []byte {
+	buf = append(buf, t.String()...)
+	if visited[t] || isGobEncoder(t) {
+		return buf
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		//gob只编码导出字段, 跳过chan和func
+		if f.PkgPath != "" || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	sig := []byte{}
+	for _, f := range fields {
+		sig = append(sig, f.Name...)
+		sig = append(sig, ':')
+		sig = appendKind(sig, f.Type, visited)
+		sig = append(sig, ',')
+	}
+	buf = append(buf, '{')
+	buf = strconv.AppendUint(buf, uint64(fnv32a(sig)), 16)
+	return append(buf, '}')
+}
+
+func isGobEncoder(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return t.Implements(gobEncoderType) || pt.Implements(gobEncoderType) ||
+		t.Implements(binaryMarshalerType) || pt.Implements(binaryMarshalerType)
+}
+
+// crc32.ChecksumIEEE会让buf逃逸到堆上, 指纹用内联的fnv-1a
+func fnv32a(buf []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range buf {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+// 签名指纹, 按参数个数和gob兼容的类型计算, 发送int给int64参数不算不一致
+// 含接口类型的参数无法比较返回0
+func Fingerprint(types []reflect.Type) uint32 {
+	var scratch [256]byte
+	buf := scratch[:0]
+	for i, t := range types {
+		if t == nil || t.Kind() == reflect.Interface {
+			return 0
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, typeKind(t)...)
+	}
+	return fnv32a(buf)
+}
+
+// 同Fingerprint, 发送路径上避免分配
+func paramsFingerprint(params []interface{}) uint32 {
+	var scratch [256]byte
+	buf := scratch[:0]
+	for i, param := range params {
+		t := reflect.TypeOf(param)
+		if t == nil || t.Kind() == reflect.Interface {
+			return 0
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, typeKind(t)...)
+	}
+	return fnv32a(buf)
+}
+
+// handler (this *X)func(ctx, params)的参数签名
+func NewMethodSchema(m reflect.Method) *MethodSchema {
+	types := []reflect.Type{}
+	for i := 2; i < m.Type.NumIn(); i++ {
+		types = append(types, m.Type.In(i))
+	}
+	params := make([]string, len(types))
+	for i, t := range types {
+		params[i] = typeName(t)
+	}
+	return &MethodSchema{Name: m.Name, Params: params, Fingerprint: Fingerprint(types)}
+}
+
+// 注册actor所有rpc方法的签名
+func RegisterSchema(actorName string, rType reflect.Type) {
+	methods := make(map[string]*MethodSchema)
+	for i := 0; i < rType.NumMethod(); i++ {
+		m := rType.Method(i)
+		if m.Type.NumIn() < 2 || !m.Type.In(1).Implements(contextType) {
+			continue
+		}
+		methods[m.Name] = NewMethodSchema(m)
+	}
+	schemaLocker.Lock()
+	schema[actorName] = methods
+	schemaLocker.Unlock()
+}
+
+func GetSchema(actorName string, funcName string) *MethodSchema {
+	schemaLocker.RLock()
+	defer schemaLocker.RUnlock()
+	return schema[actorName][funcName]
+}
+
+// 校验发送方参数签名, 任一方为0不校验
+func CheckFingerprint(rpcPacket *RpcPacket, method *MethodSchema) error {
+	if rpcPacket.Fingerprint == 0 || method == nil || method.Fingerprint == 0 {
+		return nil
+	}
+	if rpcPacket.Fingerprint != method.Fingerprint {
+		return fmt.Errorf("rpc [%s] signature mismatch, want (%s) fingerprint %d, got %d",
+			rpcPacket.FuncName, strings.Join(method.Params, ", "), method.Fingerprint, rpcPacket.Fingerprint)
+	}
+	return nil
+}
+
+// 导出当前进程注册的签名, 用于部署前比较
+func DumpSchema() ([]byte, error) {
+	schemaLocker.RLock()
+	defer schemaLocker.RUnlock()
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func LoadSchema(data []byte) (Schema, error) {
+	s := Schema{}
+	err := json.Unmarshal(data, &s)
+	return s, err
+}
+
+// 比较两个版本的签名, 删除和修改的方法为不兼容
+func DiffSchema(oldSchema, newSchema Schema) []SchemaDiff {
+	diffs := []SchemaDiff{}
+	for actorName, methods := range oldSchema {
+		for funcName, old := range methods {
+			method := fmt.Sprintf("%s.%s", actorName, funcName)
+			cur := newSchema[actorName][funcName]
+			if cur == nil {
+				diffs = append(diffs, SchemaDiff{Method: method, Old: old, Breaking: true})
+			} else if cur.Fingerprint != old.Fingerprint {
+				diffs = append(diffs, SchemaDiff{Method: method, Old: old, New: cur, Breaking: true})
+			}
+		}
+	}
+	for actorName, methods := range newSchema {
+		for funcName, cur := range methods {
+			if oldSchema[actorName][funcName] == nil {
+				diffs = append(diffs, SchemaDiff{Method: fmt.Sprintf("%s.%s", actorName, funcName), New: cur})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Method < diffs[j].Method
+	})
+	return diffs
+}
+
+func (d *SchemaDiff) String() string {
+	switch {
+	case d.New == nil:
+		return fmt.Sprintf("- %s(%s)", d.Method, strings.Join(d.Old.Params, ", "))
+	case d.Old == nil:
+		return fmt.Sprintf("+ %s(%s)", d.Method, strings.Join(d.New.Params, ", "))
+	}
+	return fmt.Sprintf("~ %s(%s) -> (%s)", d.Method, strings.Join(d.Old.Params, ", "), strings.Join(d.New.Params, ", "))
+}
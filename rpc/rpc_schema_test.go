@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type schemaActor struct{}
+
+func (a *schemaActor) Login(ctx context.Context, id int64, name string)        {}
+func (a *schemaActor) Move(ctx context.Context, pos []float32, mail MAIL)      {}
+func (a *schemaActor) Count(ctx context.Context, n uint32)                     {}
+func (a *schemaActor) Ptr(ctx context.Context, head *RpcHead)                  {}
+func (a *schemaActor) Any(ctx context.Context, v interface{})                  {}
+func (a *schemaActor) Table(ctx context.Context, m map[string][]int32, b bool) {}
+func (a *schemaActor) NoContext(id int64)                                      {}
+
+func TestFingerprint(t *testing.T) {
+	RegisterSchema("schemaActor", reflect.TypeOf(&schemaActor{}))
+	tests := []struct {
+		funcName string
+		params   []interface{}
+		match    bool
+	}{
+		{"Login", []interface{}{int64(1), "a"}, true},
+		{"Login", []interface{}{1, "a"}, true}, //int发给int64参数
+		{"Login", []interface{}{int32(1), "a"}, true},
+		{"Login", []interface{}{uint64(1), "a"}, false},
+		{"Login", []interface{}{"a", int64(1)}, false},
+		{"Login", []interface{}{int64(1)}, false},
+		{"Move", []interface{}{[]float64{1}, int32(2)}, true}, //命名类型按底层类型
+		{"Move", []interface{}{[]int{1}, MAIL_Guild}, false},
+		{"Count", []interface{}{uint8(1)}, true},
+		{"Count", []interface{}{1}, false},
+		{"Ptr", []interface{}{RpcHead{}}, true}, //gob对指针透明
+		{"Table", []interface{}{map[string][]int{}, true}, true},
+		{"Table", []interface{}{map[string][]string{}, true}, false},
+	}
+	for _, tt := range tests {
+		method := GetSchema("schemaActor", tt.funcName)
+		if method == nil {
+			t.Fatalf("%s not registered", tt.funcName)
+		}
+		rpcPacket := &RpcPacket{FuncName: tt.funcName, Fingerprint: paramsFingerprint(tt.params)}
+		if err := CheckFingerprint(rpcPacket, method); (err == nil) != tt.match {
+			t.Errorf("%s%v match %v, got %v", tt.funcName, tt.params, tt.match, err)
+		}
+	}
+	if GetSchema("schemaActor", "Any").Fingerprint != 0 {
+		t.Error("interface param should not be fingerprinted")
+	}
+	if GetSchema("schemaActor", "NoContext") != nil {
+		t.Error("method without context registered")
+	}
+}
+
+// 同名结构体按导出字段比较, 增删字段或改字段类型不一致, 调整顺序和非导出字段兼容
+func TestFingerprintStruct(t *testing.T) {
+	base := func() reflect.Type {
+		type schemaItem struct {
+			Id   int64
+			Name string
+		}
+		return reflect.TypeOf(schemaItem{})
+	}()
+	tests := []struct {
+		name  string
+		typ   reflect.Type
+		match bool
+	}{
+		{"reorder", func() reflect.Type {
+			type schemaItem struct {
+				Name string
+				Id   int32
+				num  int
+			}
+			return reflect.TypeOf(&schemaItem{})
+		}(), true},
+		{"add field", func() reflect.Type {
+			type schemaItem struct {
+				Id    int64
+				Name  string
+				Count int
+			}
+			return reflect.TypeOf(schemaItem{})
+		}(), false},
+		{"remove field", func() reflect.Type {
+			type schemaItem struct {
+				Id int64
+			}
+			return reflect.TypeOf(schemaItem{})
+		}(), false},
+		{"change field", func() reflect.Type {
+			type schemaItem struct {
+				Id   string
+				Name string
+			}
+			return reflect.TypeOf(schemaItem{})
+		}(), false},
+	}
+	want := Fingerprint([]reflect.Type{base})
+	for _, tt := range tests {
+		if got := Fingerprint([]reflect.Type{tt.typ}); (got == want) != tt.match {
+			t.Errorf("%s: match %v", tt.name, !tt.match)
+		}
+	}
+
+	//递归类型不展开自身
+	type schemaNode struct {
+		Id       int64
+		Children []*schemaNode
+	}
+	if Fingerprint([]reflect.Type{reflect.TypeOf(schemaNode{})}) == 0 {
+		t.Error("recursive struct not fingerprinted")
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	method := func(name string, params ...interface{}) *MethodSchema {
+		types := make([]reflect.Type, len(params))
+		names := make([]string, len(params))
+		for i, param := range params {
+			types[i] = reflect.TypeOf(param)
+			names[i] = typeName(types[i])
+		}
+		return &MethodSchema{Name: name, Params: names, Fingerprint: Fingerprint(types)}
+	}
+	oldSchema := Schema{
+		"Player": {
+			"Login":  method("Login", int32(0)),
+			"Logout": method("Logout"),
+			"Chat":   method("Chat", ""),
+		},
+	}
+	newSchema := Schema{
+		"Player": {
+			"Login": method("Login", int64(0)), //同类整数, 兼容
+			"Chat":  method("Chat", "", 0),
+			"Buy":   method("Buy", 0),
+		},
+	}
+	want := []struct {
+		diff     string
+		breaking bool
+	}{
+		{"+ Player.Buy(int)", false},
+		{"~ Player.Chat(string) -> (string, int)", true},
+		{"- Player.Logout()", true},
+	}
+	diffs := DiffSchema(oldSchema, newSchema)
+	if len(diffs) != len(want) {
+		t.Fatalf("diffs %v, want %d", diffs, len(want))
+	}
+	for i, w := range want {
+		if diffs[i].String() != w.diff || diffs[i].Breaking != w.breaking {
+			t.Errorf("diff %d: %s breaking %v, want %s breaking %v", i, diffs[i].String(), diffs[i].Breaking, w.diff, w.breaking)
+		}
+	}
+	if diffs := DiffSchema(oldSchema, oldSchema); len(diffs) != 0 {
+		t.Errorf("same schema diffs %v", diffs)
+	}
+}
+
+func BenchmarkParamsFingerprint(b *testing.B) {
+	params := []interface{}{int64(1), "name", []int32{1, 2}, &RpcHead{}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		paramsFingerprint(params)
+	}
+}
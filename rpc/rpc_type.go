@@ -13,9 +13,9 @@ type (
 var MGR ICluster
 
 func Route(head *RpcHead, funcName string) string {
-	serverArgs := strings.Split(funcName, "<-")
-	if len(serverArgs) == 2 {
-		switch strings.ToLower(serverArgs[0]) {
+	//恰好一个分隔符时才拆分, 用Index避免Split的分配
+	if i := strings.Index(funcName, "<-"); i >= 0 && !strings.Contains(funcName[i+2:], "<-") {
+		switch strings.ToLower(funcName[:i]) {
 		case "client":
 			head.DestServerType = SERVICE_CLIENT
 		case "gate":
@@ -25,13 +25,12 @@ func Route(head *RpcHead, funcName string) string {
 		case "db":
 			head.DestServerType = SERVICE_DB
 		}
-		funcName = serverArgs[1]
+		funcName = funcName[i+2:]
 	}
 
-	actorArgs := strings.Split(funcName, ".")
-	if len(actorArgs) == 2 {
-		head.ActorName = actorArgs[0]
-		funcName = actorArgs[1]
+	if i := strings.IndexByte(funcName, '.'); i >= 0 && strings.IndexByte(funcName[i+1:], '.') < 0 {
+		head.ActorName = funcName[:i]
+		funcName = funcName[i+1:]
 	}
 	return funcName
 }
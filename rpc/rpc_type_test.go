@@ -0,0 +1,26 @@
+package rpc
+
+import "testing"
+
+// 恰好一个分隔符时才拆分
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		funcName  string
+		service   SERVICE
+		actorName string
+		want      string
+	}{
+		{"Buy", SERVICE_NONE, "", "Buy"},
+		{"Player.Buy", SERVICE_NONE, "Player", "Buy"},
+		{"game<-Player.Buy", SERVICE_GAME, "Player", "Buy"},
+		{"DB<-Buy", SERVICE_DB, "", "Buy"},
+		{"a.b.c", SERVICE_NONE, "", "a.b.c"},
+		{"game<-db<-Buy", SERVICE_NONE, "", "game<-db<-Buy"},
+	}
+	for _, tt := range tests {
+		head := RpcHead{}
+		if got := Route(&head, tt.funcName); got != tt.want || head.DestServerType != tt.service || head.ActorName != tt.actorName {
+			t.Fatalf("%s: got %s %v %s", tt.funcName, got, head.DestServerType, head.ActorName)
+		}
+	}
+}